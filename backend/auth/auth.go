@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TokenTTL is how long a login token stays valid
+const TokenTTL = 30 * 24 * time.Hour
+
+const (
+	userKey      = "authUser"
+	tokenHashKey = "authTokenHash"
+)
+
+// HashPassword hashes a plaintext password with bcrypt
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword reports whether password matches the stored bcrypt hash
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// IsHashed reports whether a stored password is already a bcrypt hash
+func IsHashed(password string) bool {
+	_, err := bcrypt.Cost([]byte(password))
+	return err == nil
+}
+
+// NewToken generates a random opaque login token
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashToken returns the hash under which a token is stored
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueToken creates and stores a new login token for a user
+func IssueToken(db *sql.DB, userID int) (string, time.Time, error) {
+	token, err := NewToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiresAt := time.Now().Add(TokenTTL)
+	_, err = db.Exec(
+		"INSERT INTO auth_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)",
+		userID, HashToken(token), expiresAt,
+	)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiresAt, nil
+}
+
+// Middleware authenticates requests using a bearer token (or access_token
+// query parameter) and stores the user in the gin context
+func Middleware(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c.GetHeader("Authorization"))
+		if token == "" {
+			// EventSource cannot set headers, so streams pass the token in
+			// the query string
+			token = c.Query("access_token")
+		}
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+
+		tokenHash := HashToken(token)
+		var user models.User
+		err := db.QueryRow(
+			`SELECT u.id, u.email, u.active_household_id, u.created_at FROM auth_tokens t
+			JOIN users u ON u.id = t.user_id
+			WHERE t.token_hash = $1 AND t.expires_at > CURRENT_TIMESTAMP`,
+			tokenHash,
+		).Scan(&user.ID, &user.Email, &user.ActiveHouseholdID, &user.CreatedAt)
+		if err == sql.ErrNoRows {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to authenticate"})
+			return
+		}
+
+		SetUser(c, user)
+		c.Set(tokenHashKey, tokenHash)
+		c.Next()
+	}
+}
+
+// SetUser makes user the authenticated user of a request, as Middleware
+// does once it has checked their token
+func SetUser(c *gin.Context, user models.User) {
+	c.Set(userKey, user)
+}
+
+// CurrentUser returns the authenticated user set by Middleware
+func CurrentUser(c *gin.Context) models.User {
+	user, _ := c.MustGet(userKey).(models.User)
+	return user
+}
+
+// UserID returns the id of the authenticated user set by Middleware
+func UserID(c *gin.Context) int {
+	return CurrentUser(c).ID
+}
+
+// TokenHash returns the hash of the token used to authenticate the request
+func TokenHash(c *gin.Context) string {
+	return c.GetString(tokenHashKey)
+}
+
+func bearerToken(header string) string {
+	const prefix = "Bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(header[len(prefix):])
+}
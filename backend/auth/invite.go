@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidInvite is returned for malformed, forged or expired invite tokens
+var ErrInvalidInvite = errors.New("invalid or expired invite")
+
+var secret []byte
+
+// SetSecret sets the key used to sign invite tokens. Every instance behind
+// a load balancer must use the same secret.
+func SetSecret(key []byte) {
+	secret = key
+}
+
+// RandomSecret generates a throwaway signing key for when none is configured
+func RandomSecret() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// SignInvite returns the token for an invite, which encodes its id and
+// expiry and is signed with the configured secret
+func SignInvite(inviteID int, expiresAt time.Time) string {
+	payload := fmt.Sprintf("%d.%d", inviteID, expiresAt.Unix())
+	return payload + "." + inviteSignature(payload)
+}
+
+// ParseInvite verifies an invite token and returns the invite id it carries
+func ParseInvite(token string) (int, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, ErrInvalidInvite
+	}
+
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(inviteSignature(payload))) {
+		return 0, ErrInvalidInvite
+	}
+
+	inviteID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, ErrInvalidInvite
+	}
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() >= expiresAt {
+		return 0, ErrInvalidInvite
+	}
+
+	return inviteID, nil
+}
+
+func inviteSignature(payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("invite:" + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
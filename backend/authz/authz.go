@@ -0,0 +1,403 @@
+package authz
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/auth"
+)
+
+var (
+	// ErrNotFound is returned when the resource does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrForbidden is returned when the resource belongs to someone else
+	ErrForbidden = errors.New("forbidden")
+)
+
+const (
+	listIDKey      = "authzListID"
+	householdIDKey = "authzHouseholdID"
+	roleKey        = "authzRole"
+)
+
+// Role is a user's level of access to a list or household
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleOwner  Role = "owner"
+
+	// RoleMember is the household role of everyone but its owners
+	RoleMember Role = "member"
+)
+
+var roleRank = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleMember: 2,
+	RoleOwner:  3,
+}
+
+// ValidForList reports whether r can be granted on a list. Member is a
+// household role only.
+func (r Role) ValidForList() bool {
+	return r == RoleViewer || r == RoleEditor || r == RoleOwner
+}
+
+// ValidForHousehold reports whether r can be granted in a household
+func (r Role) ValidForHousehold() bool {
+	return r == RoleMember || r == RoleOwner
+}
+
+// AtLeast reports whether r grants at least the access of min
+func (r Role) AtLeast(min Role) bool {
+	return roleRank[r] >= roleRank[min]
+}
+
+// ListRole returns the role a user has on a list. The list's creator is
+// always its owner; other users get access through a list_members row or
+// by belonging to the household the list is in, whichever grants more.
+// Lists in the trash or marked done are not found.
+func ListRole(db *sql.DB, userID, listID int) (Role, error) {
+	return listRole(db, userID, listID, liveLists)
+}
+
+// TrashedListRole is ListRole for a list in the trash
+func TrashedListRole(db *sql.DB, userID, listID int) (Role, error) {
+	return listRole(db, userID, listID, trashedLists)
+}
+
+// Conditions selecting the lists a role lookup applies to. Lists marked
+// done are archived: hidden like the trash but only brought back by undo.
+const (
+	liveLists     = "l.deleted_at IS NULL"
+	trashedLists  = "l.deleted_at IS NOT NULL AND NOT l.archived"
+	undoableLists = "(l.deleted_at IS NULL OR l.archived)"
+)
+
+func listRole(db *sql.DB, userID, listID int, state string) (Role, error) {
+	var ownerID int
+	var memberRole, householdRole sql.NullString
+	err := db.QueryRow(
+		`SELECT l.user_id, m.role, hm.role FROM shopping_lists l
+		LEFT JOIN list_members m ON m.list_id = l.id AND m.user_id = $2
+		LEFT JOIN household_members hm ON hm.household_id = l.household_id AND hm.user_id = $2
+		WHERE l.id = $1 AND `+state,
+		listID, userID,
+	).Scan(&ownerID, &memberRole, &householdRole)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if ownerID == userID {
+		return RoleOwner, nil
+	}
+
+	var role Role
+	if memberRole.Valid {
+		role = Role(memberRole.String)
+	}
+	if householdRole.Valid {
+		// Household members can edit every household list
+		hr := RoleEditor
+		if Role(householdRole.String) == RoleOwner {
+			hr = RoleOwner
+		}
+		if roleRank[hr] > roleRank[role] {
+			role = hr
+		}
+	}
+	if role == "" {
+		return "", ErrForbidden
+	}
+	return role, nil
+}
+
+// HouseholdRole returns the role a user has in a household
+func HouseholdRole(db *sql.DB, userID, householdID int) (Role, error) {
+	var exists bool
+	var role sql.NullString
+	err := db.QueryRow(
+		`SELECT TRUE, hm.role FROM households h
+		LEFT JOIN household_members hm ON hm.household_id = h.id AND hm.user_id = $2
+		WHERE h.id = $1`,
+		householdID, userID,
+	).Scan(&exists, &role)
+	if err == sql.ErrNoRows {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if !role.Valid {
+		return "", ErrForbidden
+	}
+	return Role(role.String), nil
+}
+
+// CheckList verifies that a user has at least the given role on a list
+func CheckList(db *sql.DB, userID, listID int, min Role) error {
+	role, err := ListRole(db, userID, listID)
+	if err != nil {
+		return err
+	}
+	if !role.AtLeast(min) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// CheckItem verifies that a user has at least the given role on an item's
+// list and returns the id of that list
+func CheckItem(db *sql.DB, userID, itemID int, min Role) (int, error) {
+	return checkItem(db, userID, itemID, min, false)
+}
+
+// CheckTrashedItem is CheckItem for an item in the trash. Items are only
+// restored or purged on their own while their list is not in the trash.
+func CheckTrashedItem(db *sql.DB, userID, itemID int, min Role) (int, error) {
+	return checkItem(db, userID, itemID, min, true)
+}
+
+func checkItem(db *sql.DB, userID, itemID int, min Role, trashed bool) (int, error) {
+	var listID int
+	err := db.QueryRow(
+		"SELECT list_id FROM shopping_items WHERE id = $1 AND (deleted_at IS NOT NULL) = $2",
+		itemID, trashed,
+	).Scan(&listID)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return listID, CheckList(db, userID, listID, min)
+}
+
+// Checker authorizes access to lists and items. It lets handlers be tested
+// without a database.
+type Checker interface {
+	CheckList(userID, listID int, min Role) error
+	CheckItem(userID, itemID int, min Role) (int, error)
+}
+
+type dbChecker struct {
+	db *sql.DB
+}
+
+// NewChecker returns a Checker backed by the given database
+func NewChecker(db *sql.DB) Checker {
+	return dbChecker{db: db}
+}
+
+func (c dbChecker) CheckList(userID, listID int, min Role) error {
+	return CheckList(c.db, userID, listID, min)
+}
+
+func (c dbChecker) CheckItem(userID, itemID int, min Role) (int, error) {
+	return CheckItem(c.db, userID, itemID, min)
+}
+
+// CheckHistory verifies that a user may access a history entry, either as
+// its owner or as a member of the household it was recorded in
+func CheckHistory(db *sql.DB, userID, historyID int) error {
+	var ownerID int
+	var inHousehold bool
+	err := db.QueryRow(
+		`SELECT h.user_id, EXISTS (
+			SELECT 1 FROM household_members hm
+			WHERE hm.household_id = h.household_id AND hm.user_id = $2
+		) FROM list_history h WHERE h.id = $1`,
+		historyID, userID,
+	).Scan(&ownerID, &inHousehold)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if ownerID != userID && !inHousehold {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// RequireList authorizes access with at least the given role to the list
+// identified by the :id param
+func RequireList(db *sql.DB, min Role) gin.HandlerFunc {
+	return requireList(db, min, liveLists)
+}
+
+// RequireTrashedList is RequireList for a list in the trash
+func RequireTrashedList(db *sql.DB, min Role) gin.HandlerFunc {
+	return requireList(db, min, trashedLists)
+}
+
+// RequireUndoableList is RequireList that also accepts lists marked done,
+// so marking them done can be undone
+func RequireUndoableList(db *sql.DB, min Role) gin.HandlerFunc {
+	return requireList(db, min, undoableLists)
+}
+
+func requireList(db *sql.DB, min Role, state string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listID, ok := paramID(c)
+		if !ok {
+			return
+		}
+		role, err := listRole(db, auth.UserID(c), listID, state)
+		if err == nil && !role.AtLeast(min) {
+			err = ErrForbidden
+		}
+		if err != nil {
+			Abort(c, err)
+			return
+		}
+		SetListID(c, listID)
+		c.Set(roleKey, role)
+		c.Next()
+	}
+}
+
+// RequireItem authorizes access with at least the given role to the list
+// owning the item identified by the :id param
+func RequireItem(db *sql.DB, min Role) gin.HandlerFunc {
+	return requireItem(db, min, false)
+}
+
+// RequireTrashedItem is RequireItem for an item in the trash
+func RequireTrashedItem(db *sql.DB, min Role) gin.HandlerFunc {
+	return requireItem(db, min, true)
+}
+
+func requireItem(db *sql.DB, min Role, trashed bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		itemID, ok := paramID(c)
+		if !ok {
+			return
+		}
+		listID, err := checkItem(db, auth.UserID(c), itemID, min, trashed)
+		if err != nil {
+			Abort(c, err)
+			return
+		}
+		SetListID(c, listID)
+		c.Next()
+	}
+}
+
+// RequireHistory authorizes access to the history entry identified by the
+// :id param
+func RequireHistory(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		historyID, ok := paramID(c)
+		if !ok {
+			return
+		}
+		if err := CheckHistory(db, auth.UserID(c), historyID); err != nil {
+			Abort(c, err)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireHousehold authorizes access with at least the given role to the
+// household identified by the :id param
+func RequireHousehold(db *sql.DB, min Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		householdID, ok := paramID(c)
+		if !ok {
+			return
+		}
+		role, err := HouseholdRole(db, auth.UserID(c), householdID)
+		if err == nil && !role.AtLeast(min) {
+			err = ErrForbidden
+		}
+		if err != nil {
+			Abort(c, err)
+			return
+		}
+		c.Set(householdIDKey, householdID)
+		c.Set(roleKey, role)
+		c.Next()
+	}
+}
+
+// admins holds the lowercased emails of the users allowed to use the admin
+// endpoints
+var admins = map[string]bool{}
+
+// SetAdmins sets the emails of the admins, compared ignoring case
+func SetAdmins(emails []string) {
+	admins = map[string]bool{}
+	for _, email := range emails {
+		if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+			admins[email] = true
+		}
+	}
+}
+
+// RequireAdmin only lets admins through
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !admins[strings.ToLower(auth.CurrentUser(c).Email)] {
+			Abort(c, ErrForbidden)
+			return
+		}
+		c.Next()
+	}
+}
+
+// HouseholdID returns the id of the household resolved by RequireHousehold
+func HouseholdID(c *gin.Context) int {
+	return c.GetInt(householdIDKey)
+}
+
+// ListID returns the id of the list resolved by RequireList or RequireItem
+func ListID(c *gin.Context) int {
+	return c.GetInt(listIDKey)
+}
+
+// SetListID records the list a request was authorized for, as RequireList
+// and RequireItem do
+func SetListID(c *gin.Context, listID int) {
+	c.Set(listIDKey, listID)
+}
+
+// CurrentRole returns the caller's role on the list or household resolved by
+// RequireList or RequireHousehold
+func CurrentRole(c *gin.Context) Role {
+	role, _ := c.Get(roleKey)
+	r, _ := role.(Role)
+	return r
+}
+
+// Abort ends the request with the status matching an authorization error
+func Abort(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "Not found"})
+	case errors.Is(err, ErrForbidden):
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+	default:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to authorize request"})
+	}
+}
+
+func paramID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		Abort(c, ErrNotFound)
+		return 0, false
+	}
+	return id, true
+}
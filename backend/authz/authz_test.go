@@ -0,0 +1,181 @@
+package authz
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/auth"
+	"github.com/shopping-list/backend/db/dbtest"
+	"github.com/shopping-list/backend/models"
+)
+
+// resolved is what a request got through the middlewares with
+type resolved struct {
+	ListID      int  `json:"list_id"`
+	HouseholdID int  `json:"household_id"`
+	Role        Role `json:"role"`
+}
+
+// newRouter guards test routes with the middlewares. Requests are made as
+// the user whose id is in the X-User header.
+func newRouter(conn *sql.DB) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		id, _ := strconv.Atoi(c.GetHeader("X-User"))
+		auth.SetUser(c, models.User{ID: id})
+	})
+	ok := func(c *gin.Context) {
+		c.JSON(http.StatusOK, resolved{ListID: ListID(c), HouseholdID: HouseholdID(c), Role: CurrentRole(c)})
+	}
+	router.GET("/lists/:id", RequireList(conn, RoleViewer), ok)
+	router.PUT("/lists/:id", RequireList(conn, RoleEditor), ok)
+	router.DELETE("/lists/:id", RequireList(conn, RoleOwner), ok)
+	router.POST("/trash/lists/:id/restore", RequireTrashedList(conn, RoleOwner), ok)
+	router.PUT("/items/:id", RequireItem(conn, RoleEditor), ok)
+	router.GET("/households/:id", RequireHousehold(conn, RoleMember), ok)
+	router.PUT("/households/:id", RequireHousehold(conn, RoleOwner), ok)
+	return router
+}
+
+func insertList(t *testing.T, conn *sql.DB, userID int, householdID *int, name string) int {
+	t.Helper()
+	var id int
+	err := conn.QueryRow("INSERT INTO shopping_lists (user_id, household_id, name) VALUES ($1, $2, $3) RETURNING id", userID, householdID, name).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestRequireMiddlewares(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, conn *sql.DB) {
+		alice := dbtest.User(t, conn, "alice@example.com")
+		bob := dbtest.User(t, conn, "bob@example.com")
+		carol := dbtest.User(t, conn, "carol@example.com")
+		dave := dbtest.User(t, conn, "dave@example.com")
+		erin := dbtest.User(t, conn, "erin@example.com")
+
+		// Carol views and Dave edits Alice's list, Erin is in her household
+		list := insertList(t, conn, alice, nil, "Groceries")
+		dbtest.Share(t, conn, list, carol, string(RoleViewer))
+		dbtest.Share(t, conn, list, dave, string(RoleEditor))
+		household := dbtest.Household(t, conn, "Home", alice, erin)
+		homeList := insertList(t, conn, alice, &household, "Home")
+		trashed := insertList(t, conn, alice, nil, "Old")
+		dbtest.Exec(t, conn, "UPDATE shopping_lists SET deleted_at = CURRENT_TIMESTAMP WHERE id = $1", trashed)
+		var item int
+		if err := conn.QueryRow("INSERT INTO shopping_items (list_id, name) VALUES ($1, 'Milk') RETURNING id", list).Scan(&item); err != nil {
+			t.Fatal(err)
+		}
+
+		router := newRouter(conn)
+		path := func(prefix string, id int) string { return prefix + strconv.Itoa(id) }
+		tests := []struct {
+			name   string
+			user   int
+			method string
+			path   string
+			status int
+			want   resolved
+		}{
+			{"owner reads list", alice, http.MethodGet, path("/lists/", list), http.StatusOK, resolved{ListID: list, Role: RoleOwner}},
+			{"viewer reads list", carol, http.MethodGet, path("/lists/", list), http.StatusOK, resolved{ListID: list, Role: RoleViewer}},
+			{"stranger reads list", bob, http.MethodGet, path("/lists/", list), http.StatusForbidden, resolved{}},
+			{"viewer edits list", carol, http.MethodPut, path("/lists/", list), http.StatusForbidden, resolved{}},
+			{"editor edits list", dave, http.MethodPut, path("/lists/", list), http.StatusOK, resolved{ListID: list, Role: RoleEditor}},
+			{"editor deletes list", dave, http.MethodDelete, path("/lists/", list), http.StatusForbidden, resolved{}},
+			{"stranger deletes list", bob, http.MethodDelete, path("/lists/", list), http.StatusForbidden, resolved{}},
+			{"owner deletes list", alice, http.MethodDelete, path("/lists/", list), http.StatusOK, resolved{ListID: list, Role: RoleOwner}},
+			{"missing list", alice, http.MethodGet, "/lists/999999", http.StatusNotFound, resolved{}},
+			{"bad list id", alice, http.MethodGet, "/lists/groceries", http.StatusNotFound, resolved{}},
+
+			{"household member edits list", erin, http.MethodPut, path("/lists/", homeList), http.StatusOK, resolved{ListID: homeList, Role: RoleEditor}},
+			{"household member deletes list", erin, http.MethodDelete, path("/lists/", homeList), http.StatusForbidden, resolved{}},
+			{"non-member reads household list", bob, http.MethodGet, path("/lists/", homeList), http.StatusForbidden, resolved{}},
+
+			{"trashed list is gone", alice, http.MethodGet, path("/lists/", trashed), http.StatusNotFound, resolved{}},
+			{"owner restores trashed list", alice, http.MethodPost, path("/trash/lists/", trashed) + "/restore", http.StatusOK, resolved{ListID: trashed, Role: RoleOwner}},
+			{"live list is not in the trash", alice, http.MethodPost, path("/trash/lists/", list) + "/restore", http.StatusNotFound, resolved{}},
+
+			{"editor edits item", dave, http.MethodPut, path("/items/", item), http.StatusOK, resolved{ListID: list}},
+			{"viewer edits item", carol, http.MethodPut, path("/items/", item), http.StatusForbidden, resolved{}},
+			{"stranger edits item", bob, http.MethodPut, path("/items/", item), http.StatusForbidden, resolved{}},
+			{"missing item", alice, http.MethodPut, "/items/999999", http.StatusNotFound, resolved{}},
+
+			{"member reads household", erin, http.MethodGet, path("/households/", household), http.StatusOK, resolved{HouseholdID: household, Role: RoleMember}},
+			{"owner edits household", alice, http.MethodPut, path("/households/", household), http.StatusOK, resolved{HouseholdID: household, Role: RoleOwner}},
+			{"member edits household", erin, http.MethodPut, path("/households/", household), http.StatusForbidden, resolved{}},
+			{"non-member reads household", bob, http.MethodGet, path("/households/", household), http.StatusForbidden, resolved{}},
+			{"missing household", alice, http.MethodGet, "/households/999999", http.StatusNotFound, resolved{}},
+		}
+		for _, tt := range tests {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("X-User", strconv.Itoa(tt.user))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Errorf("%s: status %d %s, want %d", tt.name, w.Code, w.Body.String(), tt.status)
+				continue
+			}
+			if tt.status != http.StatusOK {
+				continue
+			}
+			var got resolved
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("%s: resolved %+v, want %+v", tt.name, got, tt.want)
+			}
+		}
+	})
+}
+
+func TestChecker(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, conn *sql.DB) {
+		alice := dbtest.User(t, conn, "alice@example.com")
+		bob := dbtest.User(t, conn, "bob@example.com")
+		carol := dbtest.User(t, conn, "carol@example.com")
+		list := insertList(t, conn, alice, nil, "Groceries")
+		dbtest.Share(t, conn, list, carol, string(RoleViewer))
+		var item int
+		if err := conn.QueryRow("INSERT INTO shopping_items (list_id, name) VALUES ($1, 'Milk') RETURNING id", list).Scan(&item); err != nil {
+			t.Fatal(err)
+		}
+
+		access := NewChecker(conn)
+		tests := []struct {
+			name string
+			err  error
+			want error
+		}{
+			{"owner", access.CheckList(alice, list, RoleOwner), nil},
+			{"viewer reads", access.CheckList(carol, list, RoleViewer), nil},
+			{"viewer writes", access.CheckList(carol, list, RoleEditor), ErrForbidden},
+			{"stranger", access.CheckList(bob, list, RoleViewer), ErrForbidden},
+			{"missing list", access.CheckList(alice, 999999, RoleViewer), ErrNotFound},
+		}
+		for _, tt := range tests {
+			if !errors.Is(tt.err, tt.want) {
+				t.Errorf("%s: CheckList = %v, want %v", tt.name, tt.err, tt.want)
+			}
+		}
+
+		if listID, err := access.CheckItem(alice, item, RoleEditor); err != nil || listID != list {
+			t.Errorf("CheckItem as owner = %d, %v; want %d", listID, err, list)
+		}
+		if _, err := access.CheckItem(carol, item, RoleEditor); !errors.Is(err, ErrForbidden) {
+			t.Errorf("CheckItem as viewer = %v, want ErrForbidden", err)
+		}
+		if _, err := access.CheckItem(alice, 999999, RoleEditor); !errors.Is(err, ErrNotFound) {
+			t.Errorf("CheckItem of a missing item = %v, want ErrNotFound", err)
+		}
+	})
+}
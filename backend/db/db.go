@@ -3,16 +3,25 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 
 	_ "github.com/lib/pq"
+	"github.com/shopping-list/backend/auth"
 )
 
-// InitDB initializes the database connection
+// InitDB initializes the database connection. The driver is picked from
+// the URL scheme: sqlite:path/to/file.db (or sqlite::memory:) opens SQLite,
+// anything else is handed to Postgres.
 func InitDB(dbURL string) (*sql.DB, error) {
-	db, err := sql.Open("postgres", dbURL)
+	driverName, dsn, inMemory := DataSource(dbURL)
+	db, err := sql.Open(driverName, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	if inMemory {
+		// Every connection would get its own empty database
+		db.SetMaxOpenConns(1)
+	}
 
 	// Test the connection
 	if err = db.Ping(); err != nil {
@@ -22,49 +31,97 @@ func InitDB(dbURL string) (*sql.DB, error) {
 	return db, nil
 }
 
-// RunMigrations runs all database migrations
-func RunMigrations(db *sql.DB) error {
-	migrations := []string{
-		createUsersTable,
-		createShoppingListsTable,
-		createShoppingItemsTable,
-		createListHistoryTable,
+// DataSource returns the driver and data source name to open a database URL
+// with. In-memory SQLite databases only exist for as long as their one
+// connection.
+func DataSource(dbURL string) (driverName, dsn string, inMemory bool) {
+	if strings.HasPrefix(dbURL, "sqlite:") {
+		dsn, inMemory = sqliteDSN(dbURL)
+		return sqliteDriverName, dsn, inMemory
 	}
+	return "postgres", dbURL, false
+}
 
-	for _, migration := range migrations {
-		if _, err := db.Exec(migration); err != nil {
-			return fmt.Errorf("migration failed: %w", err)
-		}
+// RunMigrations applies all pending migrations, see MigrateUp
+func RunMigrations(db *sql.DB) error {
+	if err := MigrateUp(db); err != nil {
+		return err
 	}
 
-	// Create default user if it doesn't exist
-	if err := createDefaultUser(db); err != nil {
-		return fmt.Errorf("failed to create default user: %w", err)
+	if err := upgradeLegacyUsers(db); err != nil {
+		return fmt.Errorf("failed to upgrade legacy users: %w", err)
 	}
 
 	return nil
 }
 
-// createDefaultUser creates a default user for testing
-func createDefaultUser(db *sql.DB) error {
-	var count int
-	err := db.QueryRow("SELECT COUNT(*) FROM users WHERE id = 1").Scan(&count)
+// postgresMigrations are applied in order and never edited once released;
+// change the schema by appending a new migration. The first twelve predate
+// versioning and are idempotent so existing databases adopt them as-is.
+var postgresMigrations = []Migration{
+	{1, "create_users", createUsersTable, "DROP TABLE IF EXISTS users;"},
+	{2, "create_shopping_lists", createShoppingListsTable, "DROP TABLE IF EXISTS shopping_lists;"},
+	{3, "create_shopping_items", createShoppingItemsTable, "DROP TABLE IF EXISTS shopping_items;"},
+	{4, "create_list_history", createListHistoryTable, "DROP TABLE IF EXISTS list_history;"},
+	{5, "create_auth_tokens", createAuthTokensTable, "DROP TABLE IF EXISTS auth_tokens;"},
+	{6, "create_list_members", createListMembersTable, "DROP TABLE IF EXISTS list_members;"},
+	{7, "create_households", createHouseholdsTables, dropHouseholdsTables},
+	{8, "create_invites", createInvitesTable, "DROP TABLE IF EXISTS invites;"},
+	{9, "create_list_events", createListEventsTable, "DROP TABLE IF EXISTS list_events;"},
+	{10, "add_change_tracking", createChangeTracking, dropChangeTracking},
+	{11, "add_version_columns", createVersionColumns, dropVersionColumns},
+	{12, "add_field_timestamps", createFieldTimestamps, "ALTER TABLE shopping_items DROP COLUMN IF EXISTS field_updated_at;"},
+	{13, "index_shopping_items_list_id", "CREATE INDEX IF NOT EXISTS idx_shopping_items_list_id ON shopping_items(list_id);", "DROP INDEX IF EXISTS idx_shopping_items_list_id;"},
+	{14, "normalize_history_payloads", normalizeHistoryPayloads, "SELECT 1;"},
+	{15, "create_list_activity", createListActivityTable, "DROP TABLE IF EXISTS list_activity;"},
+	{16, "add_soft_delete", createSoftDelete, dropSoftDelete},
+	{17, "add_undo", createUndo, dropUndo},
+	{18, "add_list_kind", createListKind, dropListKind},
+	{19, "create_recurrences", createRecurrencesTable, "DROP TABLE IF EXISTS recurrences;"},
+	{20, "create_jobs", createJobsTable, "DROP TABLE IF EXISTS jobs;"},
+	{21, "add_job_unique_keys", createJobUniqueKeys, dropJobUniqueKeys},
+}
+
+// upgradeLegacyUsers hashes passwords stored in plaintext by older versions
+// and resyncs the users id sequence, which the old seeded default user
+// (inserted with an explicit id) left behind
+func upgradeLegacyUsers(db *sql.DB) error {
+	rows, err := db.Query("SELECT id, password FROM users")
 	if err != nil {
 		return err
 	}
+	defer rows.Close()
+
+	plaintext := map[int]string{}
+	for rows.Next() {
+		var id int
+		var password string
+		if err := rows.Scan(&id, &password); err != nil {
+			return err
+		}
+		if !auth.IsHashed(password) {
+			plaintext[id] = password
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
 
-	if count == 0 {
-		_, err := db.Exec(
-			"INSERT INTO users (id, email, password) VALUES (1, $1, $2)",
-			"user@example.com",
-			"password123",
-		)
+	for id, password := range plaintext {
+		hash, err := auth.HashPassword(password)
 		if err != nil {
 			return err
 		}
+		if _, err := db.Exec("UPDATE users SET password = $1 WHERE id = $2", hash, id); err != nil {
+			return err
+		}
 	}
 
-	return nil
+	if IsSQLite(db) {
+		return nil
+	}
+	_, err = db.Exec("SELECT setval(pg_get_serial_sequence('users', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM users")
+	return err
 }
 
 const (
@@ -109,4 +166,335 @@ const (
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 	);
 	`
+
+	createAuthTokensTable = `
+	CREATE TABLE IF NOT EXISTS auth_tokens (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		token_hash VARCHAR(64) UNIQUE NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	`
+
+	createListMembersTable = `
+	CREATE TABLE IF NOT EXISTS list_members (
+		list_id INTEGER NOT NULL REFERENCES shopping_lists(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		role VARCHAR(20) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (list_id, user_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_list_members_user_id ON list_members(user_id);
+	`
+
+	createHouseholdsTables = `
+	CREATE TABLE IF NOT EXISTS households (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS household_members (
+		household_id INTEGER NOT NULL REFERENCES households(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		role VARCHAR(20) NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (household_id, user_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_household_members_user_id ON household_members(user_id);
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS active_household_id INTEGER REFERENCES households(id) ON DELETE SET NULL;
+	ALTER TABLE shopping_lists ADD COLUMN IF NOT EXISTS household_id INTEGER REFERENCES households(id) ON DELETE SET NULL;
+	ALTER TABLE list_history ADD COLUMN IF NOT EXISTS household_id INTEGER REFERENCES households(id) ON DELETE SET NULL;
+	CREATE INDEX IF NOT EXISTS idx_shopping_lists_household_id ON shopping_lists(household_id);
+	CREATE INDEX IF NOT EXISTS idx_list_history_household_id ON list_history(household_id);
+	`
+
+	createInvitesTable = `
+	CREATE TABLE IF NOT EXISTS invites (
+		id SERIAL PRIMARY KEY,
+		list_id INTEGER REFERENCES shopping_lists(id) ON DELETE CASCADE,
+		household_id INTEGER REFERENCES households(id) ON DELETE CASCADE,
+		role VARCHAR(20) NOT NULL,
+		created_by INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		expires_at TIMESTAMP NOT NULL,
+		accepted_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		accepted_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		CHECK ((list_id IS NULL) <> (household_id IS NULL))
+	);
+	CREATE INDEX IF NOT EXISTS idx_invites_list_id ON invites(list_id);
+	CREATE INDEX IF NOT EXISTS idx_invites_household_id ON invites(household_id);
+	`
+
+	createListEventsTable = `
+	CREATE TABLE IF NOT EXISTS list_events (
+		id BIGSERIAL PRIMARY KEY,
+		list_id INTEGER NOT NULL,
+		type VARCHAR(50) NOT NULL,
+		user_id INTEGER,
+		data JSONB,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_list_events_list_id ON list_events(list_id, id);
+	`
+
+	// createChangeTracking stamps every list and item write with a value
+	// from a shared sequence and keeps tombstones for deletes, so clients
+	// can sync everything that changed after a cursor
+	createChangeTracking = `
+	CREATE SEQUENCE IF NOT EXISTS change_seq;
+	ALTER TABLE shopping_lists ADD COLUMN IF NOT EXISTS change_seq BIGINT NOT NULL DEFAULT nextval('change_seq');
+	ALTER TABLE shopping_items ADD COLUMN IF NOT EXISTS change_seq BIGINT NOT NULL DEFAULT nextval('change_seq');
+	CREATE INDEX IF NOT EXISTS idx_shopping_lists_change_seq ON shopping_lists(change_seq);
+	CREATE INDEX IF NOT EXISTS idx_shopping_items_change_seq ON shopping_items(change_seq);
+
+	CREATE TABLE IF NOT EXISTS sync_tombstones (
+		change_seq BIGINT PRIMARY KEY DEFAULT nextval('change_seq'),
+		entity_type VARCHAR(20) NOT NULL,
+		entity_id INTEGER NOT NULL,
+		list_id INTEGER NOT NULL,
+		user_ids INTEGER[] NOT NULL DEFAULT '{}',
+		household_id INTEGER,
+		deleted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_sync_tombstones_list_id ON sync_tombstones(list_id);
+
+	CREATE OR REPLACE FUNCTION bump_change_seq() RETURNS trigger AS $$
+	BEGIN
+		NEW.change_seq := nextval('change_seq');
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;
+
+	-- Runs before the cascade so the list's members are still known
+	CREATE OR REPLACE FUNCTION record_list_tombstone() RETURNS trigger AS $$
+	BEGIN
+		INSERT INTO sync_tombstones (entity_type, entity_id, list_id, user_ids, household_id)
+		VALUES ('list', OLD.id, OLD.id,
+			ARRAY[OLD.user_id] || ARRAY(SELECT user_id FROM list_members WHERE list_id = OLD.id),
+			OLD.household_id);
+		RETURN OLD;
+	END;
+	$$ LANGUAGE plpgsql;
+
+	-- Items removed by a list cascade are covered by the list's tombstone
+	CREATE OR REPLACE FUNCTION record_item_tombstone() RETURNS trigger AS $$
+	BEGIN
+		IF EXISTS (SELECT 1 FROM shopping_lists WHERE id = OLD.list_id) THEN
+			INSERT INTO sync_tombstones (entity_type, entity_id, list_id)
+			VALUES ('item', OLD.id, OLD.list_id);
+		END IF;
+		RETURN OLD;
+	END;
+	$$ LANGUAGE plpgsql;
+
+	DROP TRIGGER IF EXISTS shopping_lists_change_seq ON shopping_lists;
+	CREATE TRIGGER shopping_lists_change_seq BEFORE UPDATE ON shopping_lists
+		FOR EACH ROW EXECUTE FUNCTION bump_change_seq();
+	DROP TRIGGER IF EXISTS shopping_items_change_seq ON shopping_items;
+	CREATE TRIGGER shopping_items_change_seq BEFORE UPDATE ON shopping_items
+		FOR EACH ROW EXECUTE FUNCTION bump_change_seq();
+	DROP TRIGGER IF EXISTS shopping_lists_tombstone ON shopping_lists;
+	CREATE TRIGGER shopping_lists_tombstone BEFORE DELETE ON shopping_lists
+		FOR EACH ROW EXECUTE FUNCTION record_list_tombstone();
+	DROP TRIGGER IF EXISTS shopping_items_tombstone ON shopping_items;
+	CREATE TRIGGER shopping_items_tombstone AFTER DELETE ON shopping_items
+		FOR EACH ROW EXECUTE FUNCTION record_item_tombstone();
+	`
+
+	// createVersionColumns adds row versions used for optimistic concurrency
+	// control; every update bumps the version
+	createVersionColumns = `
+	ALTER TABLE shopping_lists ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE shopping_items ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+	CREATE OR REPLACE FUNCTION bump_version() RETURNS trigger AS $$
+	BEGIN
+		NEW.version := OLD.version + 1;
+		RETURN NEW;
+	END;
+	$$ LANGUAGE plpgsql;
+
+	DROP TRIGGER IF EXISTS shopping_lists_version ON shopping_lists;
+	CREATE TRIGGER shopping_lists_version BEFORE UPDATE ON shopping_lists
+		FOR EACH ROW EXECUTE FUNCTION bump_version();
+	DROP TRIGGER IF EXISTS shopping_items_version ON shopping_items;
+	CREATE TRIGGER shopping_items_version BEFORE UPDATE ON shopping_items
+		FOR EACH ROW EXECUTE FUNCTION bump_version();
+	`
+
+	// createFieldTimestamps records when each item field last changed so
+	// concurrent edits can be merged field by field
+	createFieldTimestamps = `
+	ALTER TABLE shopping_items ADD COLUMN IF NOT EXISTS field_updated_at JSONB NOT NULL DEFAULT '{}';
+	`
+)
+
+// normalizeHistoryPayloads brings history written before payloads were
+// typed in line with models.ListSnapshot and models.ListReuse: snapshot
+// items get "" for a missing unit, snapshots always have a name and an
+// items array, and reuse entries store the original history id as a
+// number. Only data changes, so there is nothing to revert.
+const normalizeHistoryPayloads = `
+UPDATE list_history SET data = jsonb_set(data, '{original_history_id}', to_jsonb((data->>'original_history_id')::INTEGER))
+WHERE action = 'reused' AND jsonb_typeof(data->'original_history_id') = 'string';
+
+UPDATE list_history SET data = jsonb_set(data, '{items}', '[]')
+WHERE action = 'created' AND jsonb_typeof(data->'items') IS DISTINCT FROM 'array';
+
+UPDATE list_history SET data = jsonb_set(data, '{name}', '"Shopping List"')
+WHERE action = 'created' AND COALESCE(data->>'name', '') = '';
+
+UPDATE list_history SET data = jsonb_set(data, '{items}', (
+	SELECT jsonb_agg(CASE WHEN jsonb_typeof(item->'unit') = 'string' THEN item ELSE jsonb_set(item, '{unit}', '""') END)
+	FROM jsonb_array_elements(data->'items') AS item
+))
+WHERE action = 'created' AND jsonb_array_length(data->'items') > 0;
+`
+
+const createListActivityTable = `
+CREATE TABLE IF NOT EXISTS list_activity (
+	id SERIAL PRIMARY KEY,
+	list_id INTEGER NOT NULL REFERENCES shopping_lists(id) ON DELETE CASCADE,
+	item_id INTEGER,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	action VARCHAR(50) NOT NULL,
+	before_fields JSONB,
+	after_fields JSONB,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_list_activity_list_id ON list_activity(list_id, created_at);
+`
+
+// createRecurrencesTable holds recurring templates and items. next_run_at
+// is advanced in the same transaction that adds the items, so each
+// occurrence is added once even across restarts.
+const createRecurrencesTable = `
+CREATE TABLE IF NOT EXISTS recurrences (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	template_id INTEGER REFERENCES shopping_lists(id) ON DELETE CASCADE,
+	item_id INTEGER REFERENCES shopping_items(id) ON DELETE CASCADE,
+	list_id INTEGER REFERENCES shopping_lists(id) ON DELETE SET NULL,
+	rule VARCHAR(255) NOT NULL,
+	starts_at TIMESTAMP NOT NULL,
+	next_run_at TIMESTAMP NOT NULL,
+	last_run_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	CHECK ((template_id IS NULL) <> (item_id IS NULL))
+);
+CREATE INDEX IF NOT EXISTS idx_recurrences_next_run_at ON recurrences(next_run_at);
+`
+
+// createJobsTable is the background job queue. Workers claim pending jobs
+// that are due with FOR UPDATE SKIP LOCKED; jobs that run out of attempts
+// stay behind as dead letters until an admin retries them.
+const createJobsTable = `
+CREATE TABLE IF NOT EXISTS jobs (
+	id SERIAL PRIMARY KEY,
+	kind VARCHAR(100) NOT NULL,
+	payload JSONB NOT NULL DEFAULT '{}',
+	status VARCHAR(20) NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL DEFAULT 5,
+	run_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	locked_at TIMESTAMP,
+	last_error TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_jobs_due ON jobs(run_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs(status, created_at);
+`
+
+// createJobUniqueKeys lets a job be enqueued at most once, so every
+// instance can schedule the same periodic job
+const createJobUniqueKeys = `
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS unique_key VARCHAR(255);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_unique_key ON jobs(unique_key);
+`
+
+const dropJobUniqueKeys = `
+DROP INDEX IF EXISTS idx_jobs_unique_key;
+ALTER TABLE jobs DROP COLUMN IF EXISTS unique_key;
+`
+
+// createSoftDelete lets lists and items sit in the trash before they are
+// purged
+const createSoftDelete = `
+ALTER TABLE shopping_lists ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE shopping_items ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_shopping_lists_deleted_at ON shopping_lists(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_shopping_items_deleted_at ON shopping_items(deleted_at) WHERE deleted_at IS NOT NULL;
+`
+
+const dropSoftDelete = `
+DELETE FROM shopping_items WHERE deleted_at IS NOT NULL;
+DELETE FROM shopping_lists WHERE deleted_at IS NOT NULL;
+ALTER TABLE shopping_items DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE shopping_lists DROP COLUMN IF EXISTS deleted_at;
+`
+
+// createUndo tracks which activity has been undone and can still be
+// redone. Lists marked done are archived rather than deleted so that can be
+// undone too.
+const createUndo = `
+ALTER TABLE list_activity ADD COLUMN IF NOT EXISTS undone_at TIMESTAMP;
+ALTER TABLE list_activity ADD COLUMN IF NOT EXISTS undone_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE list_activity ADD COLUMN IF NOT EXISTS redoable BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE shopping_lists ADD COLUMN IF NOT EXISTS archived BOOLEAN NOT NULL DEFAULT FALSE;
+`
+
+// createListKind tells templates apart from the lists being shopped for
+const createListKind = `
+ALTER TABLE shopping_lists ADD COLUMN IF NOT EXISTS kind VARCHAR(20) NOT NULL DEFAULT 'list';
+CREATE INDEX IF NOT EXISTS idx_shopping_lists_kind ON shopping_lists(kind) WHERE kind <> 'list';
+`
+
+const dropListKind = `
+DELETE FROM shopping_lists WHERE kind <> 'list';
+DROP INDEX IF EXISTS idx_shopping_lists_kind;
+ALTER TABLE shopping_lists DROP COLUMN IF EXISTS kind;
+`
+
+const dropUndo = `
+DELETE FROM shopping_lists WHERE archived;
+ALTER TABLE shopping_lists DROP COLUMN IF EXISTS archived;
+ALTER TABLE list_activity DROP COLUMN IF EXISTS redoable;
+ALTER TABLE list_activity DROP COLUMN IF EXISTS undone_by;
+ALTER TABLE list_activity DROP COLUMN IF EXISTS undone_at;
+`
+
+const (
+	dropHouseholdsTables = `
+	ALTER TABLE list_history DROP COLUMN IF EXISTS household_id;
+	ALTER TABLE shopping_lists DROP COLUMN IF EXISTS household_id;
+	ALTER TABLE users DROP COLUMN IF EXISTS active_household_id;
+	DROP TABLE IF EXISTS household_members;
+	DROP TABLE IF EXISTS households;
+	`
+
+	dropChangeTracking = `
+	DROP TRIGGER IF EXISTS shopping_items_tombstone ON shopping_items;
+	DROP TRIGGER IF EXISTS shopping_lists_tombstone ON shopping_lists;
+	DROP TRIGGER IF EXISTS shopping_items_change_seq ON shopping_items;
+	DROP TRIGGER IF EXISTS shopping_lists_change_seq ON shopping_lists;
+	DROP FUNCTION IF EXISTS record_item_tombstone();
+	DROP FUNCTION IF EXISTS record_list_tombstone();
+	DROP FUNCTION IF EXISTS bump_change_seq();
+	DROP TABLE IF EXISTS sync_tombstones;
+	ALTER TABLE shopping_items DROP COLUMN IF EXISTS change_seq;
+	ALTER TABLE shopping_lists DROP COLUMN IF EXISTS change_seq;
+	DROP SEQUENCE IF EXISTS change_seq;
+	`
+
+	dropVersionColumns = `
+	DROP TRIGGER IF EXISTS shopping_items_version ON shopping_items;
+	DROP TRIGGER IF EXISTS shopping_lists_version ON shopping_lists;
+	DROP FUNCTION IF EXISTS bump_version();
+	ALTER TABLE shopping_items DROP COLUMN IF EXISTS version;
+	ALTER TABLE shopping_lists DROP COLUMN IF EXISTS version;
+	`
 )
@@ -0,0 +1,145 @@
+// Package dbtest opens fresh, migrated databases for tests on each backend
+// the server supports. SQLite always runs; Postgres runs when
+// TEST_POSTGRES_URL points at a database the tests may create schemas in.
+package dbtest
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopping-list/backend/db"
+)
+
+// PostgresEnv names the variable holding the Postgres URL to test against
+const PostgresEnv = "TEST_POSTGRES_URL"
+
+// Drivers returns the backends to test against
+func Drivers() []string {
+	if os.Getenv(PostgresEnv) != "" {
+		return []string{"sqlite", "postgres"}
+	}
+	return []string{"sqlite"}
+}
+
+// Run runs test as a subtest against a fresh database on each driver
+func Run(t *testing.T, test func(t *testing.T, conn *sql.DB)) {
+	for _, driver := range Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			test(t, Open(t, driver))
+		})
+	}
+}
+
+// Open returns a fresh, migrated database on driver that is dropped when
+// the test ends. SQLite databases live in a file so concurrent connections
+// share them; Postgres ones get a schema of their own.
+func Open(t testing.TB, driver string) *sql.DB {
+	t.Helper()
+	return open(t, testURL(t, driver))
+}
+
+// testURL returns the URL of a fresh database on driver
+func testURL(t testing.TB, driver string) string {
+	t.Helper()
+	switch driver {
+	case "sqlite":
+		return "sqlite:" + filepath.Join(t.TempDir(), "test.db")
+	case "postgres":
+		return postgresSchema(t)
+	}
+	t.Fatalf("unknown driver %q", driver)
+	return ""
+}
+
+// open connects to the database at url and migrates it
+func open(t testing.TB, url string) *sql.DB {
+	t.Helper()
+	conn, err := db.InitDB(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := db.RunMigrations(conn); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// postgresSchema creates an empty schema and returns a URL that uses it
+func postgresSchema(t testing.TB) string {
+	t.Helper()
+
+	base := os.Getenv(PostgresEnv)
+	if base == "" {
+		t.Skipf("%s not set", PostgresEnv)
+	}
+	admin, err := db.InitDB(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("failed to drop schema %s: %v", schema, err)
+		}
+		admin.Close()
+	})
+
+	// lib/pq sends unknown URL parameters as session settings
+	sep := "?"
+	if strings.Contains(base, "?") {
+		sep = "&"
+	}
+	return base + sep + "search_path=" + schema
+}
+
+// User inserts a user and returns its id
+func User(t testing.TB, conn *sql.DB, email string) int {
+	t.Helper()
+	var id int
+	err := conn.QueryRow("INSERT INTO users (email, password) VALUES ($1, 'x') RETURNING id", email).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// Household inserts a household owned by ownerID with the other users as
+// members and returns its id
+func Household(t testing.TB, conn *sql.DB, name string, ownerID int, memberIDs ...int) int {
+	t.Helper()
+	var id int
+	err := conn.QueryRow("INSERT INTO households (name, created_by) VALUES ($1, $2) RETURNING id", name, ownerID).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Exec(t, conn, "INSERT INTO household_members (household_id, user_id, role) VALUES ($1, $2, 'owner')", id, ownerID)
+	for _, userID := range memberIDs {
+		Exec(t, conn, "INSERT INTO household_members (household_id, user_id, role) VALUES ($1, $2, 'member')", id, userID)
+	}
+	return id
+}
+
+// Share gives a user a role on a list
+func Share(t testing.TB, conn *sql.DB, listID, userID int, role string) {
+	t.Helper()
+	Exec(t, conn, "INSERT INTO list_members (list_id, user_id, role) VALUES ($1, $2, $3)", listID, userID, role)
+}
+
+// Exec runs a statement and fails the test if it errors
+func Exec(t testing.TB, conn *sql.DB, query string, args ...interface{}) {
+	t.Helper()
+	if _, err := conn.Exec(query, args...); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
@@ -0,0 +1,221 @@
+package dbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/shopping-list/backend/db"
+)
+
+// ErrInjected is the error of a statement failed by Faults
+var ErrInjected = errors.New("injected fault")
+
+// Faults fails statements run through a database opened by OpenFaulty on
+// demand. Beginning a transaction, each Exec and Query, and committing
+// count as one statement each.
+type Faults struct {
+	mu     sync.Mutex
+	count  int
+	failAt int
+	failed bool
+}
+
+// FailAt makes the nth statement from now on fail with ErrInjected, or
+// none of them when n is 0
+func (f *Faults) FailAt(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.count, f.failAt, f.failed = 0, n, false
+}
+
+// Failed reports whether a statement has failed since FailAt
+func (f *Faults) Failed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.failed
+}
+
+// Count returns the number of statements run since FailAt
+func (f *Faults) Count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.count
+}
+
+func (f *Faults) next() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.count++
+	if f.count == f.failAt {
+		f.failed = true
+		return ErrInjected
+	}
+	return nil
+}
+
+// Snapshot returns the rows of tables as text, for checking that an
+// operation left them as they were
+func Snapshot(t testing.TB, conn *sql.DB, tables ...string) map[string][]string {
+	t.Helper()
+	snapshot := map[string][]string{}
+	for _, table := range tables {
+		rows, err := conn.Query("SELECT * FROM " + table + " ORDER BY 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		columns, err := rows.Columns()
+		if err != nil {
+			t.Fatal(err)
+		}
+		snapshot[table] = []string{}
+		for rows.Next() {
+			values := make([]interface{}, len(columns))
+			for i := range values {
+				values[i] = new(interface{})
+			}
+			if err := rows.Scan(values...); err != nil {
+				t.Fatal(err)
+			}
+			row := ""
+			for i, v := range values {
+				if b, ok := (*v.(*interface{})).([]byte); ok {
+					*v.(*interface{}) = string(b)
+				}
+				row += fmt.Sprintf("%s=%v ", columns[i], *v.(*interface{}))
+			}
+			snapshot[table] = append(snapshot[table], row)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+	}
+	return snapshot
+}
+
+// RunFaulty is Run with databases opened by OpenFaulty
+func RunFaulty(t *testing.T, test func(t *testing.T, conn *sql.DB, faults *Faults)) {
+	for _, driver := range Drivers() {
+		t.Run(driver, func(t *testing.T) {
+			conn, faults := OpenFaulty(t, driver)
+			test(t, conn, faults)
+		})
+	}
+}
+
+// OpenFaulty is Open for a database whose statements fail on demand
+func OpenFaulty(t testing.TB, driver string) (*sql.DB, *Faults) {
+	t.Helper()
+	url := testURL(t, driver)
+	base := open(t, url)
+	_, dsn, _ := db.DataSource(url)
+
+	faults := &Faults{}
+	conn := sql.OpenDB(faultyConnector{driver: base.Driver(), dsn: dsn, faults: faults})
+	t.Cleanup(func() { conn.Close() })
+	return conn, faults
+}
+
+type faultyConnector struct {
+	driver driver.Driver
+	dsn    string
+	faults *Faults
+}
+
+func (c faultyConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyConn{Conn: conn, faults: c.faults}, nil
+}
+
+// Driver returns the wrapped driver so db.IsSQLite still tells the
+// backends apart
+func (c faultyConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+type faultyConn struct {
+	driver.Conn
+	faults *Faults
+}
+
+func (c *faultyConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.faults.next(); err != nil {
+		return nil, err
+	}
+	return execer.ExecContext(ctx, query, args)
+}
+
+func (c *faultyConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.faults.next(); err != nil {
+		return nil, err
+	}
+	return queryer.QueryContext(ctx, query, args)
+}
+
+// PrepareContext counts as a statement since database/sql only prepares
+// statements the driver cannot run directly
+func (c *faultyConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.faults.next(); err != nil {
+		return nil, err
+	}
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *faultyConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.faults.next(); err != nil {
+		return nil, err
+	}
+	var tx driver.Tx
+	var err error
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = beginner.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return faultyTx{Tx: tx, faults: c.faults}, nil
+}
+
+// CheckNamedValue leaves argument conversion to the wrapped driver
+func (c *faultyConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type faultyTx struct {
+	driver.Tx
+	faults *Faults
+}
+
+// Commit rolls back instead when it is the statement to fail, as a real
+// failed commit would
+func (tx faultyTx) Commit() error {
+	if err := tx.faults.next(); err != nil {
+		tx.Tx.Rollback()
+		return err
+	}
+	return tx.Tx.Commit()
+}
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Migration is a numbered, reversible schema change. Up and Down each run
+// in a single transaction together with the schema_migrations bookkeeping.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState reports whether a migration has been applied
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// migrationLockID is the Postgres advisory lock held while migrating so
+// replicas starting at the same time apply each migration only once
+const migrationLockID = 7314_2024
+
+const createSchemaMigrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+`
+
+// migrationsFor returns the migrations for the database behind conn
+func migrationsFor(conn *sql.DB) []Migration {
+	if IsSQLite(conn) {
+		return sqliteMigrations
+	}
+	return postgresMigrations
+}
+
+// MigrateUp applies every pending migration in order
+func MigrateUp(conn *sql.DB) error {
+	return withMigrationLock(conn, func(c *sql.Conn) error {
+		applied, err := appliedVersions(c)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrationsFor(conn) {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			err := runMigration(c, m.Up,
+				"INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+			if err != nil {
+				return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateDown reverts up to the given number of most recently applied
+// migrations and returns how many were reverted
+func MigrateDown(conn *sql.DB, steps int) (int, error) {
+	reverted := 0
+	err := withMigrationLock(conn, func(c *sql.Conn) error {
+		applied, err := appliedVersions(c)
+		if err != nil {
+			return err
+		}
+
+		migrations := migrationsFor(conn)
+		for i := len(migrations) - 1; i >= 0 && reverted < steps; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			err := runMigration(c, m.Down,
+				"DELETE FROM schema_migrations WHERE version = $1", m.Version)
+			if err != nil {
+				return fmt.Errorf("reverting migration %d (%s) failed: %w", m.Version, m.Name, err)
+			}
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// MigrationStatus lists every known migration and when it was applied
+func MigrationStatus(conn *sql.DB) ([]MigrationState, error) {
+	var states []MigrationState
+	err := withMigrationLock(conn, func(c *sql.Conn) error {
+		applied, err := appliedVersions(c)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrationsFor(conn) {
+			state := MigrationState{Migration: m}
+			if at, ok := applied[m.Version]; ok {
+				state.AppliedAt = &at
+			}
+			states = append(states, state)
+		}
+		return nil
+	})
+	return states, err
+}
+
+// withMigrationLock runs fn on a single connection while holding the
+// migration lock. SQLite databases are only used by one process and
+// serialize writes on their own.
+func withMigrationLock(conn *sql.DB, fn func(c *sql.Conn) error) error {
+	ctx := context.Background()
+	c, err := conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if !IsSQLite(conn) {
+		if _, err := c.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+			return fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		defer c.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockID)
+	}
+
+	if _, err := c.ExecContext(ctx, createSchemaMigrationsTable); err != nil {
+		return err
+	}
+	return fn(c)
+}
+
+// appliedVersions returns the applied migrations and when they ran
+func appliedVersions(c *sql.Conn) (map[int]time.Time, error) {
+	rows, err := c.QueryContext(context.Background(), "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// runMigration executes a migration script and its bookkeeping statement
+// in one transaction
+func runMigration(c *sql.Conn, script, bookkeeping string, args ...interface{}) error {
+	ctx := context.Background()
+	tx, err := c.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriverName is the driver used for sqlite:// database URLs. It wraps
+// go-sqlite3 so the Postgres-flavoured queries used throughout the backend
+// run unchanged.
+const sqliteDriverName = "sqlite"
+
+func init() {
+	sql.Register(sqliteDriverName, &sqliteDriver{})
+}
+
+// IsSQLite reports whether conn was opened from a sqlite:// URL
+func IsSQLite(conn *sql.DB) bool {
+	_, ok := conn.Driver().(*sqliteDriver)
+	return ok
+}
+
+// IsUniqueViolation reports whether err was caused by a unique constraint
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	return false
+}
+
+// sqliteDSN turns a sqlite:// URL into a go-sqlite3 data source name with
+// foreign keys enforced and write transactions taking the lock up front
+func sqliteDSN(dbURL string) (dsn string, inMemory bool) {
+	path := strings.TrimPrefix(strings.TrimPrefix(dbURL, "sqlite:"), "//")
+	params := "_foreign_keys=on&_busy_timeout=5000&_txlock=immediate"
+	if i := strings.Index(path, "?"); i >= 0 {
+		params += "&" + path[i+1:]
+		path = path[:i]
+	}
+
+	inMemory = path == "" || path == ":memory:"
+	if inMemory {
+		return "file::memory:?" + params, true
+	}
+	return "file:" + path + "?_journal_mode=WAL&" + params, false
+}
+
+var (
+	placeholderPattern = regexp.MustCompile(`\$(\d+)`)
+	castPattern        = regexp.MustCompile(`::[A-Za-z]+`)
+	lockPattern        = regexp.MustCompile(`\s+FOR UPDATE(\s+SKIP LOCKED)?`)
+
+	rewritten sync.Map
+)
+
+// rewriteQuery translates the Postgres-only syntax used by the backend:
+// $N placeholders become ?N (plain $N is bound in order of appearance by
+// SQLite), type casts are dropped and row locks are removed since SQLite
+// locks the whole database for writes anyway
+func rewriteQuery(query string) string {
+	if q, ok := rewritten.Load(query); ok {
+		return q.(string)
+	}
+	q := placeholderPattern.ReplaceAllString(query, "?$1")
+	q = castPattern.ReplaceAllString(q, "")
+	q = lockPattern.ReplaceAllString(q, "")
+	rewritten.Store(query, q)
+	return q
+}
+
+// sqliteTimeFormat matches the format of CURRENT_TIMESTAMP, with optional
+// fractional seconds, so stored times compare correctly as text
+const sqliteTimeFormat = "2006-01-02 15:04:05.999999999"
+
+// utcArgs stores timestamps as UTC text in sqliteTimeFormat
+func utcArgs(args []driver.NamedValue) []driver.NamedValue {
+	for i, arg := range args {
+		if t, ok := arg.Value.(time.Time); ok {
+			args[i].Value = t.UTC().Format(sqliteTimeFormat)
+		}
+	}
+	return args
+}
+
+type sqliteDriver struct {
+	sqlite3.SQLiteDriver
+}
+
+func (d *sqliteDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.SQLiteDriver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteConn{conn: conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+type sqliteConn struct {
+	conn *sqlite3.SQLiteConn
+}
+
+func (c *sqliteConn) Prepare(query string) (driver.Stmt, error) {
+	return c.conn.Prepare(rewriteQuery(query))
+}
+
+func (c *sqliteConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	return c.conn.PrepareContext(ctx, rewriteQuery(query))
+}
+
+func (c *sqliteConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.conn.QueryContext(ctx, rewriteQuery(query), utcArgs(args))
+}
+
+func (c *sqliteConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.conn.ExecContext(ctx, rewriteQuery(query), utcArgs(args))
+}
+
+func (c *sqliteConn) Begin() (driver.Tx, error) {
+	return c.conn.Begin()
+}
+
+func (c *sqliteConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.conn.BeginTx(ctx, opts)
+}
+
+func (c *sqliteConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,328 @@
+package db
+
+// sqliteMigrations are the SQLite counterpart of postgresMigrations. They
+// are numbered separately: the first one creates the schema as of
+// Postgres migration 13.
+var sqliteMigrations = []Migration{
+	{1, "create_schema", sqliteSchema, dropSQLiteSchema},
+	{2, "normalize_history_payloads", sqliteNormalizeHistoryPayloads, "SELECT 1;"},
+	{3, "create_list_activity", sqliteListActivityTable, "DROP TABLE IF EXISTS list_activity;"},
+	{4, "add_soft_delete", sqliteSoftDelete, sqliteDropSoftDelete},
+	{5, "add_undo", sqliteUndo, sqliteDropUndo},
+	{6, "add_list_kind", sqliteListKind, sqliteDropListKind},
+	{7, "create_recurrences", sqliteRecurrencesTable, "DROP TABLE IF EXISTS recurrences;"},
+	{8, "create_jobs", sqliteJobsTable, "DROP TABLE IF EXISTS jobs;"},
+	{9, "add_job_unique_keys", sqliteJobUniqueKeys, sqliteDropJobUniqueKeys},
+}
+
+const sqliteJobUniqueKeys = `
+ALTER TABLE jobs ADD COLUMN unique_key VARCHAR(255);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_jobs_unique_key ON jobs(unique_key);
+`
+
+const sqliteDropJobUniqueKeys = `
+DROP INDEX IF EXISTS idx_jobs_unique_key;
+ALTER TABLE jobs DROP COLUMN unique_key;
+`
+
+const sqliteJobsTable = `
+CREATE TABLE IF NOT EXISTS jobs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	kind VARCHAR(100) NOT NULL,
+	payload TEXT NOT NULL DEFAULT '{}',
+	status VARCHAR(20) NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL DEFAULT 5,
+	run_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	locked_at TIMESTAMP,
+	last_error TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_jobs_due ON jobs(run_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_jobs_status ON jobs(status, created_at);
+`
+
+const sqliteRecurrencesTable = `
+CREATE TABLE IF NOT EXISTS recurrences (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	template_id INTEGER REFERENCES shopping_lists(id) ON DELETE CASCADE,
+	item_id INTEGER REFERENCES shopping_items(id) ON DELETE CASCADE,
+	list_id INTEGER REFERENCES shopping_lists(id) ON DELETE SET NULL,
+	rule VARCHAR(255) NOT NULL,
+	starts_at TIMESTAMP NOT NULL,
+	next_run_at TIMESTAMP NOT NULL,
+	last_run_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	CHECK ((template_id IS NULL) <> (item_id IS NULL))
+);
+CREATE INDEX IF NOT EXISTS idx_recurrences_next_run_at ON recurrences(next_run_at);
+`
+
+const sqliteListKind = `
+ALTER TABLE shopping_lists ADD COLUMN kind VARCHAR(20) NOT NULL DEFAULT 'list';
+CREATE INDEX IF NOT EXISTS idx_shopping_lists_kind ON shopping_lists(kind) WHERE kind <> 'list';
+`
+
+const sqliteDropListKind = `
+DELETE FROM shopping_lists WHERE kind <> 'list';
+DROP INDEX IF EXISTS idx_shopping_lists_kind;
+ALTER TABLE shopping_lists DROP COLUMN kind;
+`
+
+const sqliteUndo = `
+ALTER TABLE list_activity ADD COLUMN undone_at TIMESTAMP;
+ALTER TABLE list_activity ADD COLUMN undone_by INTEGER REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE list_activity ADD COLUMN redoable BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE shopping_lists ADD COLUMN archived BOOLEAN NOT NULL DEFAULT FALSE;
+`
+
+const sqliteDropUndo = `
+DELETE FROM shopping_lists WHERE archived;
+ALTER TABLE shopping_lists DROP COLUMN archived;
+ALTER TABLE list_activity DROP COLUMN redoable;
+ALTER TABLE list_activity DROP COLUMN undone_by;
+ALTER TABLE list_activity DROP COLUMN undone_at;
+`
+
+const sqliteSoftDelete = `
+ALTER TABLE shopping_lists ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE shopping_items ADD COLUMN deleted_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_shopping_lists_deleted_at ON shopping_lists(deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_shopping_items_deleted_at ON shopping_items(deleted_at) WHERE deleted_at IS NOT NULL;
+`
+
+const sqliteDropSoftDelete = `
+DELETE FROM shopping_items WHERE deleted_at IS NOT NULL;
+DELETE FROM shopping_lists WHERE deleted_at IS NOT NULL;
+DROP INDEX IF EXISTS idx_shopping_items_deleted_at;
+DROP INDEX IF EXISTS idx_shopping_lists_deleted_at;
+ALTER TABLE shopping_items DROP COLUMN deleted_at;
+ALTER TABLE shopping_lists DROP COLUMN deleted_at;
+`
+
+const sqliteListActivityTable = `
+CREATE TABLE IF NOT EXISTS list_activity (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	list_id INTEGER NOT NULL REFERENCES shopping_lists(id) ON DELETE CASCADE,
+	item_id INTEGER,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	action VARCHAR(50) NOT NULL,
+	before_fields TEXT,
+	after_fields TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_list_activity_list_id ON list_activity(list_id, created_at);
+`
+
+// sqliteNormalizeHistoryPayloads is normalizeHistoryPayloads for SQLite
+const sqliteNormalizeHistoryPayloads = `
+UPDATE list_history SET data = json_set(data, '$.original_history_id', CAST(json_extract(data, '$.original_history_id') AS INTEGER))
+WHERE action = 'reused' AND json_type(data, '$.original_history_id') = 'text';
+
+UPDATE list_history SET data = json_set(data, '$.items', json('[]'))
+WHERE action = 'created' AND json_type(data, '$.items') IS NOT 'array';
+
+UPDATE list_history SET data = json_set(data, '$.name', 'Shopping List')
+WHERE action = 'created' AND COALESCE(json_extract(data, '$.name'), '') = '';
+
+UPDATE list_history SET data = json_set(data, '$.items', (
+	SELECT json_group_array(CASE WHEN json_type(value, '$.unit') = 'text' THEN json(value) ELSE json_set(value, '$.unit', '') END)
+	FROM json_each(list_history.data, '$.items')
+))
+WHERE action = 'created' AND json_array_length(data, '$.items') > 0;
+`
+
+// sqliteSchema is the SQLite equivalent of the Postgres migrations. SQLite
+// has no sequences, arrays or BEFORE triggers that can modify the row, so
+// change_seq is kept in a one-row counter table and tombstone recipients
+// are stored as a JSON array.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	email VARCHAR(255) UNIQUE NOT NULL,
+	password VARCHAR(255) NOT NULL,
+	active_household_id INTEGER REFERENCES households(id) ON DELETE SET NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS households (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name VARCHAR(255) NOT NULL,
+	created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS household_members (
+	household_id INTEGER NOT NULL REFERENCES households(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	role VARCHAR(20) NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (household_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_household_members_user_id ON household_members(user_id);
+
+CREATE TABLE IF NOT EXISTS shopping_lists (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	household_id INTEGER REFERENCES households(id) ON DELETE SET NULL,
+	name VARCHAR(255) NOT NULL,
+	version INTEGER NOT NULL DEFAULT 1,
+	change_seq INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_shopping_lists_household_id ON shopping_lists(household_id);
+CREATE INDEX IF NOT EXISTS idx_shopping_lists_change_seq ON shopping_lists(change_seq);
+
+CREATE TABLE IF NOT EXISTS shopping_items (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	list_id INTEGER NOT NULL REFERENCES shopping_lists(id) ON DELETE CASCADE,
+	name VARCHAR(255) NOT NULL,
+	quantity REAL DEFAULT 1,
+	unit VARCHAR(50),
+	purchased BOOLEAN DEFAULT FALSE,
+	version INTEGER NOT NULL DEFAULT 1,
+	change_seq INTEGER NOT NULL DEFAULT 0,
+	field_updated_at TEXT NOT NULL DEFAULT '{}',
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_shopping_items_list_id ON shopping_items(list_id);
+CREATE INDEX IF NOT EXISTS idx_shopping_items_change_seq ON shopping_items(change_seq);
+
+CREATE TABLE IF NOT EXISTS list_history (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	household_id INTEGER REFERENCES households(id) ON DELETE SET NULL,
+	original_list_id INTEGER REFERENCES shopping_lists(id) ON DELETE SET NULL,
+	action VARCHAR(50) NOT NULL,
+	data TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_list_history_household_id ON list_history(household_id);
+
+CREATE TABLE IF NOT EXISTS auth_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	token_hash VARCHAR(64) UNIQUE NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS list_members (
+	list_id INTEGER NOT NULL REFERENCES shopping_lists(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	role VARCHAR(20) NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (list_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_list_members_user_id ON list_members(user_id);
+
+CREATE TABLE IF NOT EXISTS invites (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	list_id INTEGER REFERENCES shopping_lists(id) ON DELETE CASCADE,
+	household_id INTEGER REFERENCES households(id) ON DELETE CASCADE,
+	role VARCHAR(20) NOT NULL,
+	created_by INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	expires_at TIMESTAMP NOT NULL,
+	accepted_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+	accepted_at TIMESTAMP,
+	revoked_at TIMESTAMP,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	CHECK ((list_id IS NULL) <> (household_id IS NULL))
+);
+CREATE INDEX IF NOT EXISTS idx_invites_list_id ON invites(list_id);
+CREATE INDEX IF NOT EXISTS idx_invites_household_id ON invites(household_id);
+
+CREATE TABLE IF NOT EXISTS list_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	list_id INTEGER NOT NULL,
+	type VARCHAR(50) NOT NULL,
+	user_id INTEGER,
+	data TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_list_events_list_id ON list_events(list_id, id);
+
+CREATE TABLE IF NOT EXISTS change_seq (value INTEGER NOT NULL);
+INSERT INTO change_seq (value) SELECT 0 WHERE NOT EXISTS (SELECT 1 FROM change_seq);
+
+CREATE TABLE IF NOT EXISTS sync_tombstones (
+	change_seq INTEGER PRIMARY KEY,
+	entity_type VARCHAR(20) NOT NULL,
+	entity_id INTEGER NOT NULL,
+	list_id INTEGER NOT NULL,
+	user_ids TEXT NOT NULL DEFAULT '[]',
+	household_id INTEGER,
+	deleted_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_sync_tombstones_list_id ON sync_tombstones(list_id);
+
+-- Inserts and updates take the next change_seq. Updates that did not bump
+-- the version themselves get it bumped here; the trigger's own update does
+-- not fire it again since it changes change_seq.
+CREATE TRIGGER IF NOT EXISTS shopping_lists_insert_seq AFTER INSERT ON shopping_lists
+BEGIN
+	UPDATE change_seq SET value = value + 1;
+	UPDATE shopping_lists SET change_seq = (SELECT value FROM change_seq) WHERE id = NEW.id;
+END;
+CREATE TRIGGER IF NOT EXISTS shopping_lists_update_seq AFTER UPDATE ON shopping_lists
+WHEN NEW.change_seq = OLD.change_seq
+BEGIN
+	UPDATE change_seq SET value = value + 1;
+	UPDATE shopping_lists SET change_seq = (SELECT value FROM change_seq),
+		version = CASE WHEN NEW.version = OLD.version THEN OLD.version + 1 ELSE NEW.version END
+	WHERE id = NEW.id;
+END;
+CREATE TRIGGER IF NOT EXISTS shopping_items_insert_seq AFTER INSERT ON shopping_items
+BEGIN
+	UPDATE change_seq SET value = value + 1;
+	UPDATE shopping_items SET change_seq = (SELECT value FROM change_seq) WHERE id = NEW.id;
+END;
+CREATE TRIGGER IF NOT EXISTS shopping_items_update_seq AFTER UPDATE ON shopping_items
+WHEN NEW.change_seq = OLD.change_seq
+BEGIN
+	UPDATE change_seq SET value = value + 1;
+	UPDATE shopping_items SET change_seq = (SELECT value FROM change_seq),
+		version = CASE WHEN NEW.version = OLD.version THEN OLD.version + 1 ELSE NEW.version END
+	WHERE id = NEW.id;
+END;
+
+-- Runs before the cascade so the list's members are still known
+CREATE TRIGGER IF NOT EXISTS shopping_lists_tombstone BEFORE DELETE ON shopping_lists
+BEGIN
+	UPDATE change_seq SET value = value + 1;
+	INSERT INTO sync_tombstones (change_seq, entity_type, entity_id, list_id, user_ids, household_id)
+	VALUES ((SELECT value FROM change_seq), 'list', OLD.id, OLD.id,
+		(SELECT json_group_array(user_id) FROM (
+			SELECT OLD.user_id AS user_id
+			UNION ALL SELECT user_id FROM list_members WHERE list_id = OLD.id
+		)),
+		OLD.household_id);
+END;
+
+-- Items removed by a list cascade are covered by the list's tombstone
+CREATE TRIGGER IF NOT EXISTS shopping_items_tombstone AFTER DELETE ON shopping_items
+WHEN EXISTS (SELECT 1 FROM shopping_lists WHERE id = OLD.list_id)
+BEGIN
+	UPDATE change_seq SET value = value + 1;
+	INSERT INTO sync_tombstones (change_seq, entity_type, entity_id, list_id)
+	VALUES ((SELECT value FROM change_seq), 'item', OLD.id, OLD.list_id);
+END;
+`
+
+const dropSQLiteSchema = `
+DROP TABLE IF EXISTS sync_tombstones;
+DROP TABLE IF EXISTS change_seq;
+DROP TABLE IF EXISTS list_events;
+DROP TABLE IF EXISTS invites;
+DROP TABLE IF EXISTS list_members;
+DROP TABLE IF EXISTS auth_tokens;
+DROP TABLE IF EXISTS list_history;
+DROP TABLE IF EXISTS shopping_items;
+DROP TABLE IF EXISTS shopping_lists;
+DROP TABLE IF EXISTS household_members;
+DROP TABLE IF EXISTS households;
+DROP TABLE IF EXISTS users;
+`
@@ -0,0 +1,33 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// WithTx runs fn inside a single transaction. It commits if fn returns nil
+// and rolls back if fn returns an error or panics.
+func WithTx(conn *sql.DB, fn func(tx *sql.Tx) error) (err error) {
+	tx, err := conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package db_test
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/shopping-list/backend/db"
+	"github.com/shopping-list/backend/db/dbtest"
+)
+
+func countUsers(t *testing.T, conn *sql.DB) int {
+	t.Helper()
+	var n int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM users").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// insertUsers inserts two users, one statement each
+func insertUsers(tx *sql.Tx) error {
+	for _, email := range []string{"alice@example.com", "bob@example.com"} {
+		if _, err := tx.Exec("INSERT INTO users (email, password) VALUES ($1, 'x')", email); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestWithTx(t *testing.T) {
+	dbtest.RunFaulty(t, func(t *testing.T, conn *sql.DB, faults *dbtest.Faults) {
+		// An error from fn rolls back what it did and is returned as is
+		errFn := errors.New("out of milk")
+		err := db.WithTx(conn, func(tx *sql.Tx) error {
+			if err := insertUsers(tx); err != nil {
+				return err
+			}
+			return errFn
+		})
+		if err != errFn || countUsers(t, conn) != 0 {
+			t.Fatalf("WithTx = %v with %d users, want the error and none", err, countUsers(t, conn))
+		}
+
+		// So does a panic, which keeps going
+		func() {
+			defer func() {
+				if p := recover(); p != "boom" {
+					t.Errorf("recovered %v, want the panic of fn", p)
+				}
+			}()
+			db.WithTx(conn, func(tx *sql.Tx) error {
+				insertUsers(tx)
+				panic("boom")
+			})
+		}()
+		if n := countUsers(t, conn); n != 0 {
+			t.Fatalf("%d users after a panic, want 0", n)
+		}
+
+		// A failure at any statement, from begin to commit, leaves nothing
+		// behind. The four statements are begin, two inserts and commit.
+		for n, want := range map[int]string{1: "failed to begin", 2: "", 3: "", 4: "failed to commit"} {
+			faults.FailAt(n)
+			err := db.WithTx(conn, insertUsers)
+			faults.FailAt(0)
+			if !errors.Is(err, dbtest.ErrInjected) || !strings.HasPrefix(err.Error(), want) {
+				t.Errorf("failing statement %d: WithTx = %v, want %q", n, err, want)
+			}
+			if users := countUsers(t, conn); users != 0 {
+				t.Errorf("failing statement %d left %d users", n, users)
+			}
+		}
+
+		if err := db.WithTx(conn, insertUsers); err != nil || countUsers(t, conn) != 2 {
+			t.Fatalf("WithTx = %v with %d users, want 2 committed", err, countUsers(t, conn))
+		}
+	})
+}
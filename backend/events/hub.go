@@ -0,0 +1,244 @@
+package events
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shopping-list/backend/db"
+)
+
+// Event types pushed to list subscribers
+const (
+	ItemCreated  = "item.created"
+	ItemUpdated  = "item.updated"
+	ItemDeleted  = "item.deleted"
+	ItemRestored = "item.restored"
+	ListRenamed  = "list.renamed"
+	ListDone     = "list.done"
+	ListDeleted  = "list.deleted"
+	ListRestored = "list.restored"
+	ListUndone   = "list.undone"
+	ListRedone   = "list.redone"
+
+	// MemberRemoved and HouseholdDeleted take away some user's access to a
+	// list
+	MemberRemoved    = "member.removed"
+	HouseholdDeleted = "household.deleted"
+
+	// Resync tells a client that events may have been missed and it should
+	// refetch the list
+	Resync = "resync"
+)
+
+const (
+	// Channel is the Postgres NOTIFY channel events are announced on
+	Channel = "list_events"
+
+	maxReplay        = 500
+	subscriberBuffer = 32
+)
+
+// Event is a change to a list
+type Event struct {
+	ID        int64           `json:"id"`
+	ListID    int             `json:"list_id"`
+	Type      string          `json:"type"`
+	UserID    int             `json:"user_id"`
+	Data      json.RawMessage `json:"data"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Publisher records list events. Hub is the production implementation.
+type Publisher interface {
+	Publish(listID, userID int, eventType string, data interface{})
+}
+
+// Hub publishes list events through Postgres so every backend instance
+// sees them, and fans the events it hears about out to local subscribers
+type Hub struct {
+	db *sql.DB
+
+	mu    sync.Mutex
+	lists map[int]map[chan Event]struct{}
+}
+
+// NewHub creates a hub backed by the given database
+func NewHub(db *sql.DB) *Hub {
+	return &Hub{db: db, lists: map[int]map[chan Event]struct{}{}}
+}
+
+// Publish records an event for a list and announces it to all instances.
+// Failures are logged rather than returned since the change itself has
+// already been saved.
+func (h *Hub) Publish(listID, userID int, eventType string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		fmt.Printf("Error encoding %s event: %v\n", eventType, err)
+		return
+	}
+
+	if db.IsSQLite(h.db) {
+		h.publishLocal(listID, userID, eventType, payload)
+		return
+	}
+
+	_, err = h.db.Exec(
+		`WITH e AS (
+			INSERT INTO list_events (list_id, type, user_id, data) VALUES ($1, $2, $3, $4)
+			RETURNING id, list_id
+		)
+		SELECT pg_notify($5, json_build_object('id', e.id, 'list_id', e.list_id)::text) FROM e`,
+		listID, eventType, userID, string(payload), Channel,
+	)
+	if err != nil {
+		fmt.Printf("Error publishing %s event: %v\n", eventType, err)
+	}
+}
+
+// publishLocal records an event and delivers it straight to this
+// instance's subscribers. SQLite has no NOTIFY and is only ever used by a
+// single instance.
+func (h *Hub) publishLocal(listID, userID int, eventType string, payload []byte) {
+	e := Event{ListID: listID, Type: eventType, UserID: userID, Data: payload}
+	err := h.db.QueryRow(
+		"INSERT INTO list_events (list_id, type, user_id, data) VALUES ($1, $2, $3, $4) RETURNING id, created_at",
+		listID, eventType, userID, string(payload),
+	).Scan(&e.ID, &e.CreatedAt)
+	if err != nil {
+		fmt.Printf("Error publishing %s event: %v\n", eventType, err)
+		return
+	}
+	h.deliver(e)
+}
+
+// Subscribe registers for a list's events. Events after lastEventID are
+// returned for replay; if too many were missed a single Resync event is
+// returned instead. Live events may overlap the replay, so callers should
+// skip ids they have already sent. The returned function must be called to
+// unsubscribe.
+func (h *Hub) Subscribe(listID int, lastEventID int64) ([]Event, <-chan Event, func(), error) {
+	ch := make(chan Event, subscriberBuffer)
+
+	h.mu.Lock()
+	if h.lists[listID] == nil {
+		h.lists[listID] = map[chan Event]struct{}{}
+	}
+	h.lists[listID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	cancel := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.remove(listID, ch)
+	}
+
+	if lastEventID <= 0 {
+		return nil, ch, cancel, nil
+	}
+
+	replay, err := h.since(listID, lastEventID)
+	if err != nil {
+		cancel()
+		return nil, nil, nil, err
+	}
+	return replay, ch, cancel, nil
+}
+
+const eventColumns = "id, list_id, type, user_id, data, created_at"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanEvent(row scanner, e *Event) error {
+	// SQLite hands JSON back as TEXT, which json.RawMessage cannot scan
+	var data []byte
+	err := row.Scan(&e.ID, &e.ListID, &e.Type, &e.UserID, &data, &e.CreatedAt)
+	e.Data = data
+	return err
+}
+
+// since loads the events recorded for a list after lastEventID
+func (h *Hub) since(listID int, lastEventID int64) ([]Event, error) {
+	rows, err := h.db.Query(
+		`SELECT `+eventColumns+` FROM list_events
+		WHERE list_id = $1 AND id > $2 ORDER BY id LIMIT $3`,
+		listID, lastEventID, maxReplay+1,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	replay := []Event{}
+	for rows.Next() {
+		var e Event
+		if err := scanEvent(rows, &e); err != nil {
+			return nil, err
+		}
+		replay = append(replay, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(replay) > maxReplay {
+		return []Event{{ListID: listID, Type: Resync, CreatedAt: time.Now()}}, nil
+	}
+	return replay, nil
+}
+
+// deliver sends an event to this instance's subscribers of its list
+func (h *Hub) deliver(event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.lists[event.ListID] {
+		select {
+		case ch <- event:
+		default:
+			// Slow subscriber; drop it so it reconnects and replays
+			h.remove(event.ListID, ch)
+		}
+	}
+}
+
+// resyncAll tells every local subscriber to refetch, used after the
+// listener lost its connection and may have missed notifications
+func (h *Hub) resyncAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for listID, subscribers := range h.lists {
+		for ch := range subscribers {
+			select {
+			case ch <- Event{ListID: listID, Type: Resync, CreatedAt: time.Now()}:
+			default:
+				h.remove(listID, ch)
+			}
+		}
+	}
+}
+
+// hasSubscribers reports whether anyone on this instance listens to a list
+func (h *Hub) hasSubscribers(listID int) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.lists[listID]) > 0
+}
+
+// remove drops a subscriber. Callers must hold h.mu.
+func (h *Hub) remove(listID int, ch chan Event) {
+	subscribers := h.lists[listID]
+	if _, ok := subscribers[ch]; !ok {
+		return
+	}
+	delete(subscribers, ch)
+	close(ch)
+	if len(subscribers) == 0 {
+		delete(h.lists, listID)
+	}
+}
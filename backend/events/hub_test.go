@@ -0,0 +1,47 @@
+package events
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/shopping-list/backend/db/dbtest"
+)
+
+func TestSubscribeReplaysMissedEvents(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, conn *sql.DB) {
+		userID := dbtest.User(t, conn, "alice@example.com")
+		var listID int
+		err := conn.QueryRow("INSERT INTO shopping_lists (user_id, name) VALUES ($1, 'Groceries') RETURNING id", userID).Scan(&listID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hub := NewHub(conn)
+		hub.Publish(listID, userID, ItemCreated, map[string]string{"name": "Milk"})
+		hub.Publish(listID, userID, ItemCreated, map[string]string{"name": "Bread"})
+		hub.Publish(listID, userID, ListRenamed, map[string]string{"name": "Weekly"})
+
+		var first int64
+		if err := conn.QueryRow("SELECT MIN(id) FROM list_events WHERE list_id = $1", listID).Scan(&first); err != nil {
+			t.Fatal(err)
+		}
+		replay, _, cancel, err := hub.Subscribe(listID, first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cancel()
+
+		want := []struct{ typ, data string }{
+			{ItemCreated, `{"name":"Bread"}`},
+			{ListRenamed, `{"name":"Weekly"}`},
+		}
+		if len(replay) != len(want) {
+			t.Fatalf("replayed %d events, want %d", len(replay), len(want))
+		}
+		for i, e := range replay {
+			if e.Type != want[i].typ || string(e.Data) != want[i].data || e.ListID != listID || e.UserID != userID {
+				t.Errorf("event %d = %+v, want %s %s", i, e, want[i].typ, want[i].data)
+			}
+		}
+	})
+}
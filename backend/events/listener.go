@@ -0,0 +1,122 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/shopping-list/backend/db"
+)
+
+const (
+	minReconnectInterval = time.Second
+	maxReconnectInterval = time.Minute
+	listenerPingInterval = 90 * time.Second
+	retentionInterval    = time.Hour
+	eventRetention       = 24 * time.Hour
+)
+
+// Listen subscribes to event notifications from all instances and delivers
+// them to local subscribers until ctx is cancelled. Lost connections are
+// retried with exponential backoff, after which subscribers are told to
+// resync.
+func (h *Hub) Listen(ctx context.Context, dbURL string) error {
+	if db.IsSQLite(h.db) {
+		return h.purgeLoop(ctx)
+	}
+
+	listener := pq.NewListener(dbURL, minReconnectInterval, maxReconnectInterval, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventDisconnected:
+			log.Printf("Event listener disconnected: %v", err)
+		case pq.ListenerEventReconnected:
+			log.Println("Event listener reconnected")
+			h.resyncAll()
+		case pq.ListenerEventConnectionAttemptFailed:
+			log.Printf("Event listener reconnect failed: %v", err)
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(Channel); err != nil {
+		return err
+	}
+
+	ping := time.NewTicker(listenerPingInterval)
+	defer ping.Stop()
+	retention := time.NewTicker(retentionInterval)
+	defer retention.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case n := <-listener.Notify:
+			// A nil notification is sent after reconnecting
+			if n != nil {
+				h.handleNotification(n.Extra)
+			}
+		case <-ping.C:
+			go listener.Ping()
+		case <-retention.C:
+			h.purgeOldEvents()
+		}
+	}
+}
+
+// purgeLoop only expires old events; used when events are delivered
+// in-process
+func (h *Hub) purgeLoop(ctx context.Context) error {
+	retention := time.NewTicker(retentionInterval)
+	defer retention.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-retention.C:
+			h.purgeOldEvents()
+		}
+	}
+}
+
+func (h *Hub) handleNotification(payload string) {
+	var ref struct {
+		ID     int64 `json:"id"`
+		ListID int   `json:"list_id"`
+	}
+	if err := json.Unmarshal([]byte(payload), &ref); err != nil {
+		log.Printf("Ignoring malformed event notification: %v", err)
+		return
+	}
+
+	// Only load the event if someone here is listening
+	if !h.hasSubscribers(ref.ListID) {
+		return
+	}
+
+	var e Event
+	err := scanEvent(h.db.QueryRow("SELECT "+eventColumns+" FROM list_events WHERE id = $1", ref.ID), &e)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load event %d: %v", ref.ID, err)
+		return
+	}
+
+	h.deliver(e)
+}
+
+func (h *Hub) purgeOldEvents() {
+	_, err := h.db.Exec(
+		"DELETE FROM list_events WHERE created_at < $1",
+		time.Now().Add(-eventRetention),
+	)
+	if err != nil {
+		log.Printf("Failed to purge old events: %v", err)
+	}
+}
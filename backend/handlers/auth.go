@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/auth"
+	"github.com/shopping-list/backend/models"
+)
+
+type credentials struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+// Register creates a new user account and logs it in
+func Register(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req credentials
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		hash, err := auth.HashPassword(req.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+			return
+		}
+
+		user := models.User{Email: strings.ToLower(strings.TrimSpace(req.Email))}
+		err = db.QueryRow(
+			"INSERT INTO users (email, password) VALUES ($1, $2) RETURNING id, created_at",
+			user.Email, hash,
+		).Scan(&user.ID, &user.CreatedAt)
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error creating user: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+			return
+		}
+
+		token, expiresAt, err := auth.IssueToken(db, user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue token"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"user":       user,
+			"token":      token,
+			"expires_at": expiresAt,
+		})
+	}
+}
+
+// Login exchanges an email and password for a token
+func Login(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Email    string `json:"email" binding:"required"`
+			Password string `json:"password" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var user models.User
+		err := db.QueryRow(
+			"SELECT id, email, password, created_at FROM users WHERE email = $1",
+			strings.ToLower(strings.TrimSpace(req.Email)),
+		).Scan(&user.ID, &user.Email, &user.Password, &user.CreatedAt)
+		if err == sql.ErrNoRows || (err == nil && !auth.CheckPassword(user.Password, req.Password)) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+			return
+		}
+
+		token, expiresAt, err := auth.IssueToken(db, user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"user":       user,
+			"token":      token,
+			"expires_at": expiresAt,
+		})
+	}
+}
+
+// Logout revokes the token used for the current request
+func Logout(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, err := db.Exec("DELETE FROM auth_tokens WHERE token_hash = $1", auth.TokenHash(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+	}
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/auth"
+	"github.com/shopping-list/backend/authz"
+	"github.com/shopping-list/backend/models"
+	"github.com/shopping-list/backend/repository"
+)
+
+// checker is an authz.Checker over the in-memory repositories. The creator
+// of a list owns it; roles holds everyone else's role by list and user.
+type checker struct {
+	lists repository.ListRepository
+	items repository.ItemRepository
+	roles map[int]map[int]authz.Role
+}
+
+func (c checker) CheckList(userID, listID int, min authz.Role) error {
+	list, err := c.lists.Get(listID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return authz.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	role := c.roles[listID][userID]
+	if list.UserID == userID {
+		role = authz.RoleOwner
+	}
+	if !role.AtLeast(min) {
+		return authz.ErrForbidden
+	}
+	return nil
+}
+
+func (c checker) CheckItem(userID, itemID int, min authz.Role) (int, error) {
+	item, err := c.items.Get(itemID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return 0, authz.ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return item.ListID, c.CheckList(userID, item.ListID, min)
+}
+
+// noEvents drops published events
+type noEvents struct{}
+
+func (noEvents) Publish(listID, userID int, eventType string, data interface{}) {}
+
+// asUser authenticates requests as the user whose id is in the X-User
+// header
+func asUser(c *gin.Context) {
+	id, _ := strconv.Atoi(c.GetHeader("X-User"))
+	auth.SetUser(c, models.User{ID: id})
+}
+
+// requireList and requireItem stand in for the authz middlewares, which
+// need a database
+func requireList(access authz.Checker, min authz.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			authz.Abort(c, authz.ErrNotFound)
+			return
+		}
+		if err := access.CheckList(auth.UserID(c), listID, min); err != nil {
+			authz.Abort(c, err)
+			return
+		}
+		authz.SetListID(c, listID)
+	}
+}
+
+func requireItem(access authz.Checker, min authz.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		itemID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			authz.Abort(c, authz.ErrNotFound)
+			return
+		}
+		listID, err := access.CheckItem(auth.UserID(c), itemID, min)
+		if err != nil {
+			authz.Abort(c, err)
+			return
+		}
+		authz.SetListID(c, listID)
+	}
+}
+
+// requestAs sends a request as a user, writing to any version
+func requestAs(t *testing.T, router *gin.Engine, userID int, method, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", "*")
+	req.Header.Set("X-User", strconv.Itoa(userID))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestListAndItemAccess(t *testing.T) {
+	const alice, bob, carol, dave = 101, 102, 103, 104
+	repos := repository.NewMemory()
+	list := models.ShoppingList{UserID: alice, Name: "Groceries"}
+	if err := repos.Lists().Create(&list); err != nil {
+		t.Fatal(err)
+	}
+	milk := models.ShoppingItem{ListID: list.ID, Name: "Milk", Quantity: 1}
+	if err := repos.Items().Create(&milk, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bob has no access, Carol can view and Dave can edit
+	access := checker{
+		lists: repos.Lists(),
+		items: repos.Items(),
+		roles: map[int]map[int]authz.Role{list.ID: {carol: authz.RoleViewer, dave: authz.RoleEditor}},
+	}
+	router := gin.New()
+	router.Use(asUser)
+	router.GET("/lists/:id", requireList(access, authz.RoleViewer), GetList(repos.Lists()))
+	router.PUT("/lists/:id", requireList(access, authz.RoleEditor), UpdateList(repos.Lists(), noEvents{}))
+	router.DELETE("/lists/:id", requireList(access, authz.RoleOwner), DeleteList(repos.Lists(), noEvents{}))
+	router.POST("/items", CreateItem(repos.Items(), access, noEvents{}))
+	router.PATCH("/items/:id", requireItem(access, authz.RoleEditor), PatchItem(repos.Items(), noEvents{}))
+	router.DELETE("/items/:id", requireItem(access, authz.RoleEditor), DeleteItem(repos.Items(), noEvents{}))
+
+	listPath := "/lists/" + strconv.Itoa(list.ID)
+	itemPath := "/items/" + strconv.Itoa(milk.ID)
+	rename := gin.H{"name": "Weekly"}
+	bread := gin.H{"list_id": list.ID, "name": "Bread", "quantity": 1}
+	tests := []struct {
+		name   string
+		user   int
+		method string
+		path   string
+		body   interface{}
+		status int
+	}{
+		{"owner reads", alice, http.MethodGet, listPath, nil, http.StatusOK},
+		{"viewer reads", carol, http.MethodGet, listPath, nil, http.StatusOK},
+		{"stranger reads", bob, http.MethodGet, listPath, nil, http.StatusForbidden},
+		{"missing list", alice, http.MethodGet, "/lists/999", nil, http.StatusNotFound},
+		{"bad list id", alice, http.MethodGet, "/lists/abc", nil, http.StatusNotFound},
+
+		{"stranger renames", bob, http.MethodPut, listPath, rename, http.StatusForbidden},
+		{"viewer renames", carol, http.MethodPut, listPath, rename, http.StatusForbidden},
+		{"editor renames", dave, http.MethodPut, listPath, rename, http.StatusOK},
+		{"stranger deletes list", bob, http.MethodDelete, listPath, nil, http.StatusForbidden},
+		{"editor deletes list", dave, http.MethodDelete, listPath, nil, http.StatusForbidden},
+
+		{"stranger adds item", bob, http.MethodPost, "/items", bread, http.StatusForbidden},
+		{"viewer adds item", carol, http.MethodPost, "/items", bread, http.StatusForbidden},
+		{"item in missing list", alice, http.MethodPost, "/items", gin.H{"list_id": 999, "name": "Bread"}, http.StatusNotFound},
+		{"stranger edits item", bob, http.MethodPatch, itemPath, gin.H{"purchased": true}, http.StatusForbidden},
+		{"viewer edits item", carol, http.MethodPatch, itemPath, gin.H{"purchased": true}, http.StatusForbidden},
+		{"missing item", alice, http.MethodPatch, "/items/999", gin.H{"purchased": true}, http.StatusNotFound},
+		{"stranger deletes item", bob, http.MethodDelete, itemPath, nil, http.StatusForbidden},
+		{"viewer deletes item", carol, http.MethodDelete, itemPath, nil, http.StatusForbidden},
+		{"editor edits item", dave, http.MethodPatch, itemPath, gin.H{"quantity": 2}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := requestAs(t, router, tt.user, tt.method, tt.path, tt.body)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d %s, want %d", tt.name, w.Code, w.Body.String(), tt.status)
+		}
+	}
+
+	// Only the editor's changes went through
+	got, err := repos.Lists().Get(list.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Weekly" || len(got.Items) != 1 {
+		t.Fatalf("list = %s with %d items, want Weekly with 1", got.Name, len(got.Items))
+	}
+	if item := got.Items[0]; item.Purchased || item.Quantity != 2 {
+		t.Errorf("item = %+v, want 2 unpurchased", item)
+	}
+}
+
+func TestSyncAccess(t *testing.T) {
+	const alice, bob, carol = 101, 102, 103
+	repos := repository.NewMemory()
+	list := models.ShoppingList{UserID: alice, Name: "Groceries"}
+	if err := repos.Lists().Create(&list); err != nil {
+		t.Fatal(err)
+	}
+	milk := models.ShoppingItem{ListID: list.ID, Name: "Milk", Quantity: 1}
+	if err := repos.Items().Create(&milk, alice); err != nil {
+		t.Fatal(err)
+	}
+	access := checker{
+		lists: repos.Lists(),
+		items: repos.Items(),
+		roles: map[int]map[int]authz.Role{list.ID: {carol: authz.RoleViewer}},
+	}
+	router := gin.New()
+	router.Use(asUser)
+	router.POST("/sync", ApplySync(repos.Lists(), repos.Items(), access, noEvents{}))
+
+	version := 1
+	ops := []gin.H{
+		{"type": opUpdateList, "list_id": list.ID, "version": version, "name": "Mine now"},
+		{"type": opDeleteList, "list_id": list.ID, "version": version},
+		{"type": opCreateItem, "list_id": list.ID, "name": "Beer"},
+		{"type": opUpdateItem, "item_id": milk.ID, "purchased": true},
+		{"type": opDeleteItem, "item_id": milk.ID, "version": version},
+		{"type": opDeleteItem, "item_id": 999, "version": version},
+	}
+	for _, tt := range []struct {
+		name string
+		user int
+		want []int
+	}{
+		{"stranger", bob, []int{403, 403, 403, 403, 403, 404}},
+		{"viewer", carol, []int{403, 403, 403, 403, 403, 404}},
+	} {
+		w := requestAs(t, router, tt.user, http.MethodPost, "/sync", gin.H{"operations": ops})
+		var resp struct {
+			Results []syncResult `json:"results"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Results) != len(tt.want) {
+			t.Fatalf("%s: got %d results, want %d", tt.name, len(resp.Results), len(tt.want))
+		}
+		for i, result := range resp.Results {
+			if result.Status != tt.want[i] {
+				t.Errorf("%s: %s = %d, want %d", tt.name, ops[i]["type"], result.Status, tt.want[i])
+			}
+		}
+	}
+
+	got, err := repos.Lists().Get(list.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Groceries" || len(got.Items) != 1 || got.Items[0].Purchased {
+		t.Errorf("list = %+v, want it untouched", got)
+	}
+}
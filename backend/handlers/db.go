@@ -0,0 +1,9 @@
+package handlers
+
+import "github.com/shopping-list/backend/db"
+
+// isUniqueViolation reports whether a write failed on a unique constraint,
+// whichever database is in use
+func isUniqueViolation(err error) bool {
+	return db.IsUniqueViolation(err)
+}
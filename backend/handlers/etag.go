@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/repository"
+)
+
+// etag formats a row version as a strong entity tag
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// ifMatch reads the version a PUT or DELETE expects from the If-Match
+// header. "*" matches any version and yields nil. When the header is
+// missing or malformed it responds with 428 and returns ok == false.
+func ifMatch(c *gin.Context) (expected *int, ok bool) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "*" {
+		return nil, true
+	}
+
+	version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(header, "W/"), `"`))
+	if header == "" || err != nil {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header with the current version is required"})
+		return nil, false
+	}
+	return &version, true
+}
+
+// listConflict answers a failed If-Match on a list with the server's copy
+func listConflict(c *gin.Context, lists repository.ListRepository, id int) {
+	current, err := lists.Get(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "List not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve list"})
+		return
+	}
+
+	c.Header("ETag", etag(current.Version))
+	c.JSON(http.StatusPreconditionFailed, gin.H{"error": "List was modified by someone else", "current": current})
+}
+
+// itemConflict answers a failed If-Match on an item with the server's copy
+func itemConflict(c *gin.Context, items repository.ItemRepository, id int) {
+	current, err := items.Get(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve item"})
+		return
+	}
+
+	c.Header("ETag", etag(current.Version))
+	c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Item was modified by someone else", "current": current})
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/auth"
+	"github.com/shopping-list/backend/authz"
+	"github.com/shopping-list/backend/events"
+)
+
+const eventsHeartbeat = 15 * time.Second
+
+// ListEvents streams changes to a list as Server-Sent Events. Clients that
+// reconnect with a Last-Event-ID header (or last_event_id query parameter)
+// are replayed the events they missed. The stream ends once the user can
+// no longer see the list.
+func ListEvents(hub *events.Hub, access authz.Checker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, listID := auth.UserID(c), authz.ListID(c)
+		lastEventID := c.GetHeader("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = c.Query("last_event_id")
+		}
+		lastID, _ := strconv.ParseInt(lastEventID, 10, 64)
+
+		replay, ch, cancel, err := hub.Subscribe(listID, lastID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to subscribe to list events"})
+			return
+		}
+		defer cancel()
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+
+		// Live events can overlap the replay, so skip anything already sent
+		sent := lastID
+		for _, event := range replay {
+			writeEvent(c, event)
+			if event.ID > sent {
+				sent = event.ID
+			}
+		}
+		c.Writer.Flush()
+		if revokesAccess(replay...) && access.CheckList(userID, listID, authz.RoleViewer) != nil {
+			return
+		}
+
+		heartbeat := time.NewTicker(eventsHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-c.Request.Context().Done():
+				return
+			case event, ok := <-ch:
+				if !ok {
+					return
+				}
+				if event.Type != events.Resync && event.ID <= sent {
+					continue
+				}
+				writeEvent(c, event)
+				if event.ID > sent {
+					sent = event.ID
+				}
+				if revokesAccess(event) && access.CheckList(userID, listID, authz.RoleViewer) != nil {
+					c.Writer.Flush()
+					return
+				}
+			case <-heartbeat.C:
+				c.Writer.WriteString(": ping\n\n")
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// revokesAccess reports whether any of the events may have taken away
+// someone's access to the list. A resync may stand for such events too.
+func revokesAccess(changes ...events.Event) bool {
+	for _, event := range changes {
+		switch event.Type {
+		case events.ListDeleted, events.ListDone, events.MemberRemoved, events.HouseholdDeleted, events.Resync:
+			return true
+		}
+	}
+	return false
+}
+
+func writeEvent(c *gin.Context, event events.Event) {
+	e := sse.Event{Event: event.Type, Data: event}
+	// Resync events carry no id so the client's Last-Event-ID is kept
+	if event.ID > 0 {
+		e.Id = strconv.FormatInt(event.ID, 10)
+	}
+	c.Render(-1, e)
+}
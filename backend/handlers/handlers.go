@@ -1,14 +1,21 @@
 package handlers
 
 import (
-	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/auth"
+	"github.com/shopping-list/backend/authz"
+	"github.com/shopping-list/backend/events"
 	"github.com/shopping-list/backend/models"
+	"github.com/shopping-list/backend/repository"
 )
 
 // HealthCheck returns the health status of the API
@@ -20,7 +27,7 @@ func HealthCheck(c *gin.Context) {
 }
 
 // CreateList creates a new shopping list
-func CreateList(db *sql.DB) gin.HandlerFunc {
+func CreateList(lists repository.ListRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var list models.ShoppingList
 		if err := c.ShouldBindJSON(&list); err != nil {
@@ -28,255 +35,278 @@ func CreateList(db *sql.DB) gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		var ok bool
+		if list.Name, ok = requiredName(list.Name); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+		if list.Kind != "" && list.Kind != models.ListKindList && list.Kind != models.ListKindTemplate {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be list or template"})
+			return
+		}
+		user := auth.CurrentUser(c)
+		list.UserID = user.ID
+		list.HouseholdID = user.ActiveHouseholdID
 
 		fmt.Printf("Received list creation request - UserID: %d, Name: %s\n", list.UserID, list.Name)
 
-		err := db.QueryRow(
-			"INSERT INTO shopping_lists (user_id, name) VALUES ($1, $2) RETURNING id, created_at, updated_at",
-			list.UserID, list.Name,
-		).Scan(&list.ID, &list.CreatedAt, &list.UpdatedAt)
-
-		if err != nil {
+		if err := lists.Create(&list); err != nil {
 			fmt.Printf("Error creating list: %v\n", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create list: " + err.Error()})
 			return
 		}
 
 		fmt.Printf("Successfully created list with ID: %d\n", list.ID)
+		c.Header("ETag", etag(list.Version))
 		c.JSON(http.StatusCreated, list)
 	}
 }
 
-// GetUserLists retrieves all lists for a user
-func GetUserLists(db *sql.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		userID := c.Query("user_id")
-		if userID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "user_id query parameter required"})
-			return
-		}
-
-		rows, err := db.Query(
-			"SELECT id, user_id, name, created_at, updated_at FROM shopping_lists WHERE user_id = $1 ORDER BY updated_at DESC",
-			userID,
-		)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve lists"})
-			return
-		}
-		defer rows.Close()
-
-		lists := []models.ShoppingList{}
-		for rows.Next() {
-			var list models.ShoppingList
-			if err := rows.Scan(&list.ID, &list.UserID, &list.Name, &list.CreatedAt, &list.UpdatedAt); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan list"})
-				return
-			}
+// requiredName trims a name and reports whether anything is left of it
+func requiredName(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	return name, name != ""
+}
 
-			// Get items for this list
-			itemRows, err := db.Query(
-				"SELECT id, list_id, name, quantity, unit, purchased, created_at FROM shopping_items WHERE list_id = $1",
-				list.ID,
-			)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve items"})
-				return
-			}
-			defer itemRows.Close()
+// checkItem checks the name and quantity of an item, trimming its name,
+// and returns why they cannot be stored, if they cannot
+func checkItem(item *models.ShoppingItem) string {
+	var ok bool
+	if item.Name, ok = requiredName(item.Name); !ok {
+		return "name is required"
+	}
+	return checkQuantity(item.Quantity)
+}
 
-			items := []models.ShoppingItem{}
-			for itemRows.Next() {
-				var item models.ShoppingItem
-				if err := itemRows.Scan(&item.ID, &item.ListID, &item.Name, &item.Quantity, &item.Unit, &item.Purchased, &item.CreatedAt); err != nil {
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan item"})
-					return
-				}
-				items = append(items, item)
-			}
+// checkQuantity returns why a quantity cannot be stored, if it cannot
+func checkQuantity(quantity float64) string {
+	if quantity <= 0 {
+		return "quantity must be positive"
+	}
+	return ""
+}
 
-			list.Items = items
-			lists = append(lists, list)
+// checkPatch checks the fields present in a patch like checkItem does,
+// trimming the name
+func checkPatch(patch *repository.ItemPatch) string {
+	if patch.Name != nil {
+		name, ok := requiredName(*patch.Name)
+		if !ok {
+			return "name is required"
 		}
+		patch.Name = &name
+	}
+	if patch.Quantity != nil {
+		return checkQuantity(*patch.Quantity)
+	}
+	return ""
+}
 
-		c.JSON(http.StatusOK, lists)
+// newItem checks a new item and fills in its defaults, the same for REST
+// and sync: it starts out unpurchased, with a quantity of one unless told
+// otherwise. It returns why the item cannot be created, if it cannot.
+func newItem(item *models.ShoppingItem) string {
+	if item.Quantity == 0 {
+		item.Quantity = 1
 	}
+	item.Purchased = false
+	return checkItem(item)
 }
 
-// GetList retrieves a specific shopping list with its items
-func GetList(db *sql.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		id := c.Param("id")
+// GetUserLists retrieves a page of the lists of the authenticated user's
+// active household (or their personal lists when none is active), plus any
+// lists shared with them directly. Supports cursor, limit, sort (name,
+// created_at, updated_at, "-" for descending), name and
+// created/updated_after/before filters. Templates are left out.
+func GetUserLists(lists repository.ListRepository) gin.HandlerFunc {
+	return listPage(lists, models.ListKindList)
+}
 
-		var list models.ShoppingList
-		err := db.QueryRow(
-			"SELECT id, user_id, name, created_at, updated_at FROM shopping_lists WHERE id = $1",
-			id,
-		).Scan(&list.ID, &list.UserID, &list.Name, &list.CreatedAt, &list.UpdatedAt)
+func listPage(lists repository.ListRepository, kind string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := auth.CurrentUser(c)
 
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "List not found"})
+		q := repository.ListQuery{Kind: kind, Name: c.Query("name")}
+		var ok bool
+		if q.Page, ok = pageParams(c); !ok {
 			return
 		}
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve list"})
+		if !timeParams(c, map[string]**time.Time{
+			"created_after":  &q.CreatedAfter,
+			"created_before": &q.CreatedBefore,
+			"updated_after":  &q.UpdatedAfter,
+			"updated_before": &q.UpdatedBefore,
+		}) {
 			return
 		}
 
-		// Get items
-		rows, err := db.Query(
-			"SELECT id, list_id, name, quantity, unit, purchased, created_at FROM shopping_items WHERE list_id = $1",
-			id,
-		)
+		result, next, err := lists.Visible(user.ID, user.ActiveHouseholdID, q)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve items"})
+			respondPageError(c, err, "Failed to retrieve lists")
 			return
 		}
-		defer rows.Close()
 
-		items := []models.ShoppingItem{}
-		for rows.Next() {
-			var item models.ShoppingItem
-			if err := rows.Scan(&item.ID, &item.ListID, &item.Name, &item.Quantity, &item.Unit, &item.Purchased, &item.CreatedAt); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan item"})
-				return
-			}
-			items = append(items, item)
+		c.JSON(http.StatusOK, newPage(result, next))
+	}
+}
+
+// GetList retrieves a specific shopping list with its items
+func GetList(lists repository.ListRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		list, err := lists.Get(authz.ListID(c))
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "List not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve list"})
+			return
 		}
 
-		list.Items = items
+		c.Header("ETag", etag(list.Version))
 		c.JSON(http.StatusOK, list)
 	}
 }
 
 // UpdateList updates a shopping list
-func UpdateList(db *sql.DB) gin.HandlerFunc {
+func UpdateList(lists repository.ListRepository, hub events.Publisher) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Param("id")
+		id := authz.ListID(c)
 		var list models.ShoppingList
 		if err := c.ShouldBindJSON(&list); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		var ok bool
+		if list.Name, ok = requiredName(list.Name); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+		expected, ok := ifMatch(c)
+		if !ok {
+			return
+		}
 
-		_, err := db.Exec(
-			"UPDATE shopping_lists SET name = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
-			list.Name, id,
-		)
-
+		version, err := lists.Rename(id, list.Name, expected, auth.UserID(c))
+		if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrVersionMismatch) {
+			listConflict(c, lists, id)
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update list"})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"message": "List updated successfully"})
+		hub.Publish(id, auth.UserID(c), events.ListRenamed, gin.H{"id": id, "name": list.Name, "version": version})
+		c.Header("ETag", etag(version))
+		c.JSON(http.StatusOK, gin.H{"message": "List updated successfully", "version": version})
 	}
 }
 
-// DeleteList deletes a shopping list
-func DeleteList(db *sql.DB) gin.HandlerFunc {
+// DeleteList moves a shopping list to the trash
+func DeleteList(lists repository.ListRepository, hub events.Publisher) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Param("id")
+		id := authz.ListID(c)
+		expected, ok := ifMatch(c)
+		if !ok {
+			return
+		}
 
-		_, err := db.Exec("DELETE FROM shopping_lists WHERE id = $1", id)
+		err := lists.Delete(id, expected)
+		if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrVersionMismatch) {
+			listConflict(c, lists, id)
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete list"})
 			return
 		}
 
+		hub.Publish(id, auth.UserID(c), events.ListDeleted, gin.H{"id": id})
 		c.JSON(http.StatusOK, gin.H{"message": "List deleted successfully"})
 	}
 }
 
-// MarkListDone marks a shopping list as done and moves it to history
-func MarkListDone(db *sql.DB) gin.HandlerFunc {
+// MarkListDone marks a shopping list as done and moves it to history. The
+// optional carry_over body moves the items that were not purchased on to an
+// existing list (list_id) or a new one (name, defaulting to the list's).
+func MarkListDone(history repository.HistoryRepository, lists repository.ListRepository, access authz.Checker, hub events.Publisher) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Param("id")
+		id := authz.ListID(c)
+		user := auth.CurrentUser(c)
+
 		var req struct {
-			UserID int `json:"user_id"`
+			CarryOver *struct {
+				ListID *int   `json:"list_id"`
+				Name   string `json:"name"`
+			} `json:"carry_over"`
 		}
-		if err := c.ShouldBindJSON(&req); err != nil {
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		// Get the list details
-		var listName string
-		var userID int
-		err := db.QueryRow(
-			"SELECT name, user_id FROM shopping_lists WHERE id = $1",
-			id,
-		).Scan(&listName, &userID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve list"})
-			return
+		var carry *repository.CarryOver
+		if req.CarryOver != nil {
+			carry = &repository.CarryOver{ListID: req.CarryOver.ListID, Name: strings.TrimSpace(req.CarryOver.Name), UserID: user.ID, HouseholdID: user.ActiveHouseholdID}
+			if carry.ListID != nil {
+				if err := access.CheckList(user.ID, *carry.ListID, authz.RoleEditor); err != nil {
+					authz.Abort(c, err)
+					return
+				}
+			}
 		}
 
-		// Get all items for the list
-		rows, err := db.Query(
-			"SELECT id, name, quantity, unit, purchased FROM shopping_items WHERE list_id = $1 ORDER BY id",
-			id,
-		)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve items"})
+		entry, err := history.ArchiveList(id, user.ID, carry)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "List not found"})
 			return
 		}
-		defer rows.Close()
-
-		var items []map[string]interface{}
-		for rows.Next() {
-			var itemID int
-			var name string
-			var quantity float64
-			var unit *string
-			var purchased bool
-			if err := rows.Scan(&itemID, &name, &quantity, &unit, &purchased); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan items"})
-				return
-			}
-			items = append(items, map[string]interface{}{
-				"id":        itemID,
-				"name":      name,
-				"quantity":  quantity,
-				"unit":      unit,
-				"purchased": purchased,
-			})
-		}
-
-		// Create data JSON
-		data := map[string]interface{}{
-			"name":  listName,
-			"items": items,
+		if errors.Is(err, repository.ErrTemplate) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Templates cannot be marked done"})
+			return
 		}
-		dataJSON, err := json.Marshal(data)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to marshal data"})
+		if errors.Is(err, repository.ErrInvalidCarryOver) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Items can only be carried over to another active list"})
 			return
 		}
-
-		// Insert into list_history
-		_, err = db.Exec(
-			"INSERT INTO list_history (user_id, original_list_id, action, data) VALUES ($1, $2, $3, $4)",
-			userID, id, "created", string(dataJSON),
-		)
 		if err != nil {
+			log.Printf("Error archiving list: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save to history"})
 			return
 		}
 
-		// Delete the list (cascade will delete items)
-		_, err = db.Exec("DELETE FROM shopping_lists WHERE id = $1", id)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete list"})
-			return
+		hub.Publish(id, user.ID, events.ListDone, gin.H{"id": id})
+		resp := gin.H{"message": "List marked as done and saved to history", "history": entry}
+		if carry != nil {
+			// The snapshot says where the items went
+			payload, _ := entry.Payload()
+			if snapshot, ok := payload.(*models.ListSnapshot); ok && snapshot.CarriedOverTo != nil {
+				if list, err := lists.Get(*snapshot.CarriedOverTo); err == nil {
+					publishCarriedOver(hub, user.ID, list, *snapshot)
+					resp["carried_over_to"] = list
+				}
+			}
 		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
 
-		c.JSON(http.StatusOK, gin.H{"message": "List marked as done and saved to history"})
+// publishCarriedOver announces the items carried over to a list as new
+func publishCarriedOver(hub events.Publisher, userID int, list models.ShoppingList, snapshot models.ListSnapshot) {
+	carried := map[int]bool{}
+	for _, si := range snapshot.Items {
+		carried[si.ID] = si.CarriedOver
+	}
+	for _, item := range list.Items {
+		if carried[item.ID] {
+			hub.Publish(list.ID, userID, events.ItemCreated, item)
+		}
 	}
 }
 
 // CreateItem creates a new item in a shopping list
-func CreateItem(db *sql.DB) gin.HandlerFunc {
+func CreateItem(items repository.ItemRepository, access authz.Checker, hub events.Publisher) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var item models.ShoppingItem
 		if err := c.ShouldBindJSON(&item); err != nil {
@@ -284,197 +314,298 @@ func CreateItem(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		err := db.QueryRow(
-			"INSERT INTO shopping_items (list_id, name, quantity, unit) VALUES ($1, $2, $3, $4) RETURNING id, created_at",
-			item.ListID, item.Name, item.Quantity, item.Unit,
-		).Scan(&item.ID, &item.CreatedAt)
+		if msg := newItem(&item); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		if err := access.CheckList(auth.UserID(c), item.ListID, authz.RoleEditor); err != nil {
+			authz.Abort(c, err)
+			return
+		}
 
+		err := items.Create(&item, auth.UserID(c))
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "List not found"})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create item"})
 			return
 		}
 
+		hub.Publish(item.ListID, auth.UserID(c), events.ItemCreated, item)
+		c.Header("ETag", etag(item.Version))
 		c.JSON(http.StatusCreated, item)
 	}
 }
 
 // UpdateItem updates an item
-func UpdateItem(db *sql.DB) gin.HandlerFunc {
+func UpdateItem(items repository.ItemRepository, hub events.Publisher) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Param("id")
+		id, _ := strconv.Atoi(c.Param("id"))
 		var item models.ShoppingItem
 		if err := c.ShouldBindJSON(&item); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if msg := checkItem(&item); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		expected, ok := ifMatch(c)
+		if !ok {
+			return
+		}
 
-		_, err := db.Exec(
-			"UPDATE shopping_items SET name = $1, quantity = $2, unit = $3, purchased = $4 WHERE id = $5",
-			item.Name, item.Quantity, item.Unit, item.Purchased, id,
-		)
-
+		item.ID = id
+		err := items.Replace(&item, expected, auth.UserID(c))
+		if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrVersionMismatch) {
+			itemConflict(c, items, id)
+			return
+		}
 		if err != nil {
-			fmt.Printf("Error updating item: %v\n", err)
+			log.Printf("Error updating item: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update item"})
 			return
 		}
 
-		// Fetch and return the updated item
-		err = db.QueryRow(
-			"SELECT id, list_id, name, quantity, unit, purchased, created_at FROM shopping_items WHERE id = $1",
-			id,
-		).Scan(&item.ID, &item.ListID, &item.Name, &item.Quantity, &item.Unit, &item.Purchased, &item.CreatedAt)
+		hub.Publish(item.ListID, auth.UserID(c), events.ItemUpdated, item)
+		c.Header("ETag", etag(item.Version))
+		c.JSON(http.StatusOK, item)
+	}
+}
+
+// PatchItem changes only the fields present in the request, merging them
+// with concurrent edits to other fields using last-writer-wins per field.
+// If-Match is optional; when sent it must match the current version.
+func PatchItem(items repository.ItemRepository, hub events.Publisher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, _ := strconv.Atoi(c.Param("id"))
+		var patch repository.ItemPatch
+		if err := c.ShouldBindJSON(&patch); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if msg := checkPatch(&patch); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		var expected *int
+		if c.GetHeader("If-Match") != "" {
+			var ok bool
+			if expected, ok = ifMatch(c); !ok {
+				return
+			}
+		}
 
+		merged, err := items.Merge(id, patch, expected, auth.UserID(c))
+		if errors.Is(err, repository.ErrVersionMismatch) || errors.Is(err, repository.ErrNotFound) {
+			itemConflict(c, items, id)
+			return
+		}
+		if errors.Is(err, repository.ErrMergeContention) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
-			fmt.Printf("Error fetching updated item: %v\n", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch updated item"})
+			log.Printf("Error merging item: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update item"})
 			return
 		}
 
-		c.JSON(http.StatusOK, item)
+		if len(merged.Applied) > 0 {
+			hub.Publish(merged.Item.ListID, auth.UserID(c), events.ItemUpdated, merged.Item)
+		}
+		c.Header("ETag", etag(merged.Item.Version))
+		c.JSON(http.StatusOK, merged)
 	}
 }
 
-// DeleteItem deletes an item
-func DeleteItem(db *sql.DB) gin.HandlerFunc {
+// DeleteItem moves an item to the trash
+func DeleteItem(items repository.ItemRepository, hub events.Publisher) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Param("id")
+		id, _ := strconv.Atoi(c.Param("id"))
+		expected, ok := ifMatch(c)
+		if !ok {
+			return
+		}
 
-		_, err := db.Exec("DELETE FROM shopping_items WHERE id = $1", id)
+		err := items.Delete(id, expected, auth.UserID(c))
+		if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrVersionMismatch) {
+			itemConflict(c, items, id)
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete item"})
 			return
 		}
 
+		hub.Publish(authz.ListID(c), auth.UserID(c), events.ItemDeleted, gin.H{"id": id, "list_id": authz.ListID(c)})
 		c.JSON(http.StatusOK, gin.H{"message": "Item deleted successfully"})
 	}
 }
 
-// GetUserHistory retrieves the history of user actions
-func GetUserHistory(db *sql.DB) gin.HandlerFunc {
+// GetUserHistory retrieves a page of the history of the authenticated
+// user's active household, or their personal history when none is active.
+// Supports cursor, limit, sort (created_at or -created_at), action and
+// created_after/before filters.
+func GetUserHistory(history repository.HistoryRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID := c.Query("user_id")
-		if userID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "user_id query parameter required"})
+		user := auth.CurrentUser(c)
+
+		q := repository.HistoryQuery{Action: c.Query("action")}
+		var ok bool
+		if q.Page, ok = pageParams(c); !ok {
 			return
 		}
-
-		rows, err := db.Query(
-			"SELECT id, user_id, original_list_id, action, data, created_at FROM list_history WHERE user_id = $1 ORDER BY created_at DESC LIMIT 50",
-			userID,
-		)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve history"})
+		if !timeParams(c, map[string]**time.Time{
+			"created_after":  &q.CreatedAfter,
+			"created_before": &q.CreatedBefore,
+		}) {
 			return
 		}
-		defer rows.Close()
 
-		history := []models.ListHistory{}
-		for rows.Next() {
-			var h models.ListHistory
-			if err := rows.Scan(&h.ID, &h.UserID, &h.OriginalListID, &h.Action, &h.Data, &h.CreatedAt); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan history"})
-				return
-			}
-			history = append(history, h)
+		entries, next, err := history.Visible(user.ID, user.ActiveHouseholdID, q)
+		if err != nil {
+			respondPageError(c, err, "Failed to retrieve history")
+			return
 		}
 
-		c.JSON(http.StatusOK, history)
+		c.JSON(http.StatusOK, newPage(entries, next))
 	}
 }
 
-// ReuseList creates a new list from a past list
-func ReuseList(db *sql.DB) gin.HandlerFunc {
+// GetListActivity returns a page of the changes made to a list and its
+// items, newest first
+func GetListActivity(activity repository.ActivityRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Param("id")
-		userID := c.Query("user_id")
-		if userID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "user_id query parameter required"})
+		q := repository.ActivityQuery{Action: c.Query("action")}
+		var ok bool
+		if q.Page, ok = pageParams(c); !ok {
 			return
 		}
-
-		// Get the history entry
-		var historyData string
-		err := db.QueryRow(
-			"SELECT data FROM list_history WHERE id = $1 AND user_id = $2",
-			id, userID,
-		).Scan(&historyData)
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "History entry not found"})
+		if !timeParams(c, map[string]**time.Time{
+			"created_after":  &q.CreatedAfter,
+			"created_before": &q.CreatedBefore,
+		}) {
 			return
 		}
+
+		entries, next, err := activity.ForList(authz.ListID(c), q)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve history"})
+			respondPageError(c, err, "Failed to retrieve activity")
 			return
 		}
 
-		// Parse history data
-		var data map[string]interface{}
-		if err := json.Unmarshal([]byte(historyData), &data); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse history data"})
-			return
-		}
+		c.JSON(http.StatusOK, newPage(entries, next))
+	}
+}
 
-		listName, ok := data["name"].(string)
-		if !ok {
-			listName = "Reused List"
-		}
+// UndoChange reverts the latest change to a list that is not undone yet
+func UndoChange(activity repository.ActivityRepository, hub events.Publisher) gin.HandlerFunc {
+	return stepChange(activity.Undo, hub, events.ListUndone, "Nothing to undo")
+}
 
-		// Get items from history data
-		var items []map[string]interface{}
-		if itemsData, ok := data["items"].([]interface{}); ok {
-			for _, item := range itemsData {
-				if itemMap, ok := item.(map[string]interface{}); ok {
-					items = append(items, itemMap)
-				}
-			}
-		}
+// RedoChange reapplies the earliest change to a list that is undone
+func RedoChange(activity repository.ActivityRepository, hub events.Publisher) gin.HandlerFunc {
+	return stepChange(activity.Redo, hub, events.ListRedone, "Nothing to redo")
+}
 
-		// Create new list
-		var newListID int
-		err = db.QueryRow(
-			"INSERT INTO shopping_lists (user_id, name) VALUES ($1, $2) RETURNING id",
-			userID, listName,
-		).Scan(&newListID)
+func stepChange(step func(listID, actorID int) (models.ListActivity, error), hub events.Publisher, event, nothing string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := authz.ListID(c)
 
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create new list"})
+		entry, err := step(id, auth.UserID(c))
+		switch {
+		case errors.Is(err, repository.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "List not found"})
+			return
+		case errors.Is(err, repository.ErrNothingToUndo), errors.Is(err, repository.ErrNothingToRedo):
+			c.JSON(http.StatusConflict, gin.H{"error": nothing})
+			return
+		case errors.Is(err, repository.ErrUndoConflict):
+			c.JSON(http.StatusConflict, gin.H{"error": "The change conflicts with the current state of the list"})
+			return
+		case err != nil:
+			log.Printf("Error stepping list %d activity: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply change"})
 			return
 		}
 
-		// Copy items
-		for _, item := range items {
-			name, _ := item["name"].(string)
-			quantity, _ := item["quantity"].(float64)
-			unit, _ := item["unit"].(string)
+		hub.Publish(id, auth.UserID(c), event, entry)
+		c.JSON(http.StatusOK, entry)
+	}
+}
+
+// ReuseList creates a new list from a past list. The optional body reuses
+// into an existing list (list_id) instead and narrows the items down to
+// those not purchased (unpurchased_only) or picked by id (item_ids).
+func ReuseList(history repository.HistoryRepository, access authz.Checker, hub events.Publisher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, _ := strconv.Atoi(c.Param("id"))
+		user := auth.CurrentUser(c)
 
-			_, err := db.Exec(
-				"INSERT INTO shopping_items (list_id, name, quantity, unit) VALUES ($1, $2, $3, $4)",
-				newListID, name, quantity, unit,
-			)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to copy item"})
+		var req struct {
+			ListID          *int  `json:"list_id"`
+			UnpurchasedOnly bool  `json:"unpurchased_only"`
+			ItemIDs         []int `json:"item_ids"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.ListID != nil {
+			if err := access.CheckList(user.ID, *req.ListID, authz.RoleEditor); err != nil {
+				authz.Abort(c, err)
 				return
 			}
 		}
 
-		// Record in history
-		historyEntry, _ := json.Marshal(map[string]interface{}{
-			"original_history_id": id,
-			"new_list_id":         newListID,
-		})
-		_, err = db.Exec(
-			"INSERT INTO list_history (user_id, original_list_id, action, data) VALUES ($1, $2, $3, $4)",
-			userID, newListID, "reused", string(historyEntry),
-		)
-
+		opts := repository.ReuseOptions{ListID: req.ListID, Unpurchased: req.UnpurchasedOnly, ItemIDs: req.ItemIDs}
+		list, added, err := history.RestoreList(id, user.ID, user.ActiveHouseholdID, opts)
+		if errors.Is(err, repository.ErrNotFound) && req.ListID != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "List not found"})
+			return
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "History entry not found"})
+			return
+		}
+		if errors.Is(err, repository.ErrInvalidSnapshot) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "History entry is not a list that can be reused"})
+			return
+		}
+		if errors.Is(err, repository.ErrInvalidTarget) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Items can only be reused into an active list"})
+			return
+		}
 		if err != nil {
-			// Log but don't fail
-			println("Failed to record history:", err.Error())
+			log.Printf("Error reusing list: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create new list"})
+			return
+		}
+
+		if req.ListID != nil {
+			for _, item := range added.Created {
+				hub.Publish(list.ID, user.ID, events.ItemCreated, item)
+			}
+			for _, item := range added.Merged {
+				hub.Publish(list.ID, user.ID, events.ItemUpdated, item)
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"id":      list.ID,
+				"items":   list.Items,
+				"created": added.Created,
+				"merged":  added.Merged,
+				"message": "Items added to list from history successfully",
+			})
+			return
 		}
 
 		c.JSON(http.StatusCreated, gin.H{
-			"id":      newListID,
+			"id":      list.ID,
 			"message": "List created from history successfully",
 		})
 	}
@@ -0,0 +1,388 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/auth"
+	"github.com/shopping-list/backend/authz"
+	"github.com/shopping-list/backend/events"
+	"github.com/shopping-list/backend/models"
+)
+
+// CreateHousehold creates a household owned by the authenticated user and
+// makes it their active household if they have none
+func CreateHousehold(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var household models.Household
+		if err := c.ShouldBindJSON(&household); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ok bool
+		if household.Name, ok = requiredName(household.Name); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+		household.CreatedBy = auth.UserID(c)
+
+		err := withTx(db, func(tx *sql.Tx) error {
+			err := tx.QueryRow(
+				"INSERT INTO households (name, created_by) VALUES ($1, $2) RETURNING id, created_at",
+				household.Name, household.CreatedBy,
+			).Scan(&household.ID, &household.CreatedAt)
+			if err != nil {
+				return txFail("Failed to create household", err)
+			}
+
+			_, err = tx.Exec(
+				"INSERT INTO household_members (household_id, user_id, role) VALUES ($1, $2, $3)",
+				household.ID, household.CreatedBy, authz.RoleOwner,
+			)
+			if err != nil {
+				return txFail("Failed to add household owner", err)
+			}
+
+			_, err = tx.Exec(
+				"UPDATE users SET active_household_id = $1 WHERE id = $2 AND active_household_id IS NULL",
+				household.ID, household.CreatedBy,
+			)
+			if err != nil {
+				return txFail("Failed to activate household", err)
+			}
+			return nil
+		})
+		if err != nil {
+			respondTxError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusCreated, household)
+	}
+}
+
+// GetUserHouseholds retrieves all households the authenticated user belongs to
+func GetUserHouseholds(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rows, err := db.Query(
+			`SELECT h.id, h.name, h.created_by, h.created_at FROM households h
+			JOIN household_members hm ON hm.household_id = h.id
+			WHERE hm.user_id = $1
+			ORDER BY h.name`,
+			auth.UserID(c),
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve households"})
+			return
+		}
+		defer rows.Close()
+
+		households := []models.Household{}
+		for rows.Next() {
+			var h models.Household
+			var createdBy sql.NullInt64
+			if err := rows.Scan(&h.ID, &h.Name, &createdBy, &h.CreatedAt); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan household"})
+				return
+			}
+			h.CreatedBy = int(createdBy.Int64)
+			households = append(households, h)
+		}
+
+		c.JSON(http.StatusOK, households)
+	}
+}
+
+// GetHousehold retrieves a household with its members
+func GetHousehold(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := authz.HouseholdID(c)
+
+		var household models.Household
+		var createdBy sql.NullInt64
+		err := db.QueryRow(
+			"SELECT id, name, created_by, created_at FROM households WHERE id = $1",
+			id,
+		).Scan(&household.ID, &household.Name, &createdBy, &household.CreatedAt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve household"})
+			return
+		}
+		household.CreatedBy = int(createdBy.Int64)
+
+		rows, err := db.Query(
+			`SELECT hm.household_id, u.id, u.email, hm.role, hm.created_at FROM household_members hm
+			JOIN users u ON u.id = hm.user_id
+			WHERE hm.household_id = $1
+			ORDER BY hm.created_at`,
+			id,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve members"})
+			return
+		}
+		defer rows.Close()
+
+		household.Members = []models.HouseholdMember{}
+		for rows.Next() {
+			var m models.HouseholdMember
+			if err := rows.Scan(&m.HouseholdID, &m.UserID, &m.Email, &m.Role, &m.CreatedAt); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan member"})
+				return
+			}
+			household.Members = append(household.Members, m)
+		}
+
+		c.JSON(http.StatusOK, household)
+	}
+}
+
+// UpdateHousehold renames a household
+func UpdateHousehold(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var household models.Household
+		if err := c.ShouldBindJSON(&household); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		var ok bool
+		if household.Name, ok = requiredName(household.Name); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+			return
+		}
+
+		result, err := db.Exec("UPDATE households SET name = $1 WHERE id = $2", household.Name, authz.HouseholdID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update household"})
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Household not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Household updated successfully"})
+	}
+}
+
+// DeleteHousehold deletes a household. Its lists and history fall back to
+// the users who created them.
+func DeleteHousehold(db *sql.DB, hub events.Publisher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		householdID := authz.HouseholdID(c)
+		var lists []int
+		err := withTx(db, func(tx *sql.Tx) error {
+			var err error
+			if lists, err = householdLists(tx, householdID); err != nil {
+				return txFail("Failed to delete household", err)
+			}
+			if _, err := tx.Exec("DELETE FROM households WHERE id = $1", householdID); err != nil {
+				return txFail("Failed to delete household", err)
+			}
+			return nil
+		})
+		if err != nil {
+			respondTxError(c, err)
+			return
+		}
+
+		for _, listID := range lists {
+			hub.Publish(listID, auth.UserID(c), events.HouseholdDeleted, gin.H{"household_id": householdID})
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Household deleted successfully"})
+	}
+}
+
+// AddHouseholdMember adds an existing user to a household by email
+func AddHouseholdMember(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		householdID := authz.HouseholdID(c)
+		var req struct {
+			Email string     `json:"email" binding:"required"`
+			Role  authz.Role `json:"role"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Role == "" {
+			req.Role = authz.RoleMember
+		}
+		if !req.Role.ValidForHousehold() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+			return
+		}
+
+		member := models.HouseholdMember{HouseholdID: householdID, Role: string(req.Role)}
+		err := db.QueryRow(
+			"SELECT id, email FROM users WHERE email = $1",
+			strings.ToLower(strings.TrimSpace(req.Email)),
+		).Scan(&member.UserID, &member.Email)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+			return
+		}
+
+		err = db.QueryRow(
+			"INSERT INTO household_members (household_id, user_id, role) VALUES ($1, $2, $3) RETURNING created_at",
+			householdID, member.UserID, member.Role,
+		).Scan(&member.CreatedAt)
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "User is already a member"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add member"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, member)
+	}
+}
+
+// UpdateHouseholdMember changes the role of a household member
+func UpdateHouseholdMember(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.Atoi(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return
+		}
+		var req struct {
+			Role authz.Role `json:"role" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !req.Role.ValidForHousehold() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+			return
+		}
+
+		result, err := db.Exec(
+			"UPDATE household_members SET role = $1 WHERE household_id = $2 AND user_id = $3",
+			req.Role, authz.HouseholdID(c), userID,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member"})
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Member updated successfully"})
+	}
+}
+
+// RemoveHouseholdMember removes a member from a household. Owners can
+// remove anyone; other members can only leave themselves.
+func RemoveHouseholdMember(db *sql.DB, hub events.Publisher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		householdID := authz.HouseholdID(c)
+		userID, err := strconv.Atoi(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return
+		}
+		if userID != auth.UserID(c) && authz.CurrentRole(c) != authz.RoleOwner {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return
+		}
+
+		var lists []int
+		err = withTx(db, func(tx *sql.Tx) error {
+			result, err := tx.Exec(
+				"DELETE FROM household_members WHERE household_id = $1 AND user_id = $2",
+				householdID, userID,
+			)
+			if err != nil {
+				return txFail("Failed to remove member", err)
+			}
+			if n, _ := result.RowsAffected(); n == 0 {
+				return txReject(http.StatusNotFound, "Member not found")
+			}
+
+			_, err = tx.Exec(
+				"UPDATE users SET active_household_id = NULL WHERE id = $1 AND active_household_id = $2",
+				userID, householdID,
+			)
+			if err != nil {
+				return txFail("Failed to deactivate household", err)
+			}
+			if lists, err = householdLists(tx, householdID); err != nil {
+				return txFail("Failed to remove member", err)
+			}
+			return nil
+		})
+		if err != nil {
+			respondTxError(c, err)
+			return
+		}
+
+		for _, listID := range lists {
+			hub.Publish(listID, auth.UserID(c), events.MemberRemoved, gin.H{"household_id": householdID, "user_id": userID})
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+	}
+}
+
+// householdLists returns the ids of the lists in a household that are not
+// in the trash
+func householdLists(tx *sql.Tx, householdID int) ([]int, error) {
+	rows, err := tx.Query("SELECT id FROM shopping_lists WHERE household_id = $1 AND deleted_at IS NULL", householdID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lists []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		lists = append(lists, id)
+	}
+	return lists, rows.Err()
+}
+
+// SetActiveHousehold switches the household the authenticated user's lists
+// and history are scoped to. A null household_id switches back to personal
+// lists.
+func SetActiveHousehold(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := auth.UserID(c)
+		var req struct {
+			HouseholdID *int `json:"household_id"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if req.HouseholdID != nil {
+			if _, err := authz.HouseholdRole(db, userID, *req.HouseholdID); err != nil {
+				authz.Abort(c, err)
+				return
+			}
+		}
+
+		_, err := db.Exec("UPDATE users SET active_household_id = $1 WHERE id = $2", req.HouseholdID, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to switch household"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"active_household_id": req.HouseholdID})
+	}
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/authz"
+	"github.com/shopping-list/backend/db/dbtest"
+	"github.com/shopping-list/backend/models"
+)
+
+func TestHouseholdNames(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, conn *sql.DB) {
+		alice := dbtest.User(t, conn, "alice@example.com")
+		router := routerAs(models.User{ID: alice})
+		router.POST("/households", CreateHousehold(conn))
+		router.PUT("/households/:id", authz.RequireHousehold(conn, authz.RoleOwner), UpdateHousehold(conn))
+		// The household goes away between the access check and the update
+		router.PUT("/gone/:id", authz.RequireHousehold(conn, authz.RoleOwner), func(c *gin.Context) {
+			dbtest.Exec(t, conn, "DELETE FROM households WHERE id = $1", authz.HouseholdID(c))
+		}, UpdateHousehold(conn))
+
+		for _, name := range []string{"", "   "} {
+			if w := request(t, router, http.MethodPost, "/households", gin.H{"name": name}); w.Code != http.StatusBadRequest {
+				t.Errorf("creating a household named %q = %d, want 400", name, w.Code)
+			}
+		}
+		var household models.Household
+		w := request(t, router, http.MethodPost, "/households", gin.H{"name": "  Home "})
+		decode(t, w.Body.Bytes(), &household)
+		if w.Code != http.StatusCreated || household.Name != "Home" {
+			t.Fatalf("creating a household = %d %+v", w.Code, household)
+		}
+		path := "/households/" + strconv.Itoa(household.ID)
+
+		if w := request(t, router, http.MethodPut, path, gin.H{"name": " "}); w.Code != http.StatusBadRequest {
+			t.Errorf("renaming to a blank name = %d, want 400", w.Code)
+		}
+		if w := request(t, router, http.MethodPut, path, gin.H{"name": " Flat "}); w.Code != http.StatusOK {
+			t.Errorf("renaming = %d, want 200", w.Code)
+		}
+		var name string
+		if err := conn.QueryRow("SELECT name FROM households WHERE id = $1", household.ID).Scan(&name); err != nil || name != "Flat" {
+			t.Errorf("name = %q, %v; want Flat", name, err)
+		}
+
+		if w := request(t, router, http.MethodPut, "/gone/"+strconv.Itoa(household.ID), gin.H{"name": "Gone"}); w.Code != http.StatusNotFound {
+			t.Errorf("renaming a deleted household = %d, want 404", w.Code)
+		}
+	})
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/auth"
+	"github.com/shopping-list/backend/authz"
+	"github.com/shopping-list/backend/models"
+)
+
+const (
+	defaultInviteTTL = 7 * 24 * time.Hour
+	maxInviteTTL     = 30 * 24 * time.Hour
+)
+
+const inviteColumns = "id, list_id, household_id, role, created_by, expires_at, accepted_by, accepted_at, revoked_at, created_at"
+
+// CreateListInvite mints an invite link granting a role on a list
+func CreateListInvite(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		createInvite(c, db, "list_id", authz.ListID(c), authz.Role.ValidForList)
+	}
+}
+
+// GetListInvites retrieves all invites issued for a list
+func GetListInvites(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		getInvites(c, db, "list_id", authz.ListID(c))
+	}
+}
+
+// RevokeListInvite revokes a pending list invite
+func RevokeListInvite(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		revokeInvite(c, db, "list_id", authz.ListID(c))
+	}
+}
+
+// CreateHouseholdInvite mints an invite link granting a role in a household
+func CreateHouseholdInvite(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		createInvite(c, db, "household_id", authz.HouseholdID(c), authz.Role.ValidForHousehold)
+	}
+}
+
+// GetHouseholdInvites retrieves all invites issued for a household
+func GetHouseholdInvites(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		getInvites(c, db, "household_id", authz.HouseholdID(c))
+	}
+}
+
+// RevokeHouseholdInvite revokes a pending household invite
+func RevokeHouseholdInvite(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		revokeInvite(c, db, "household_id", authz.HouseholdID(c))
+	}
+}
+
+// AcceptInvite redeems an invite token for the authenticated user. Each
+// invite can be accepted once.
+func AcceptInvite(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := auth.CurrentUser(c)
+
+		inviteID, err := auth.ParseInvite(c.Param("token"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found or expired"})
+			return
+		}
+
+		// Claiming the invite, granting access and recording it in history
+		// succeed or fail together
+		var invite models.Invite
+		err = withTx(db, func(tx *sql.Tx) error {
+			// Claim the invite first so concurrent accepts cannot both succeed
+			err := tx.QueryRow(
+				`UPDATE invites SET accepted_by = $1, accepted_at = CURRENT_TIMESTAMP
+				WHERE id = $2 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+				RETURNING `+inviteColumns,
+				user.ID, inviteID,
+			).Scan(&invite.ID, &invite.ListID, &invite.HouseholdID, &invite.Role, &invite.CreatedBy,
+				&invite.ExpiresAt, &invite.AcceptedBy, &invite.AcceptedAt, &invite.RevokedAt, &invite.CreatedAt)
+			if err == sql.ErrNoRows {
+				return txReject(http.StatusGone, "Invite has already been used or revoked")
+			}
+			if err != nil {
+				return txFail("Failed to accept invite", err)
+			}
+
+			var ownerID int
+			var householdID *int
+			if invite.ListID != nil {
+				// Lists in the trash or marked done cannot be joined; the
+				// invite stays unused in case the list comes back
+				err = tx.QueryRow(
+					"SELECT user_id, household_id FROM shopping_lists WHERE id = $1 AND deleted_at IS NULL",
+					*invite.ListID,
+				).Scan(&ownerID, &householdID)
+				if err == sql.ErrNoRows {
+					return txReject(http.StatusNotFound, "List not found")
+				}
+				if err == nil && ownerID != user.ID {
+					_, err = tx.Exec(
+						"INSERT INTO list_members (list_id, user_id, role) VALUES ($1, $2, $3) ON CONFLICT (list_id, user_id) DO NOTHING",
+						*invite.ListID, user.ID, invite.Role,
+					)
+				}
+			} else {
+				ownerID = invite.CreatedBy
+				householdID = invite.HouseholdID
+				_, err = tx.Exec(
+					"INSERT INTO household_members (household_id, user_id, role) VALUES ($1, $2, $3) ON CONFLICT (household_id, user_id) DO NOTHING",
+					*invite.HouseholdID, user.ID, invite.Role,
+				)
+			}
+			if err != nil {
+				return txFail("Failed to grant access", err)
+			}
+
+			// Record the acceptance in the list's (or household's) history
+			data, err := models.EncodeHistory(models.InviteAcceptance{
+				InviteID: invite.ID,
+				UserID:   user.ID,
+				Email:    user.Email,
+				Role:     invite.Role,
+			})
+			if err != nil {
+				return txFail("Failed to record history", err)
+			}
+			_, err = tx.Exec(
+				"INSERT INTO list_history (user_id, household_id, original_list_id, action, data) VALUES ($1, $2, $3, $4, $5)",
+				ownerID, householdID, invite.ListID, models.ActionInviteAccepted, string(data),
+			)
+			if err != nil {
+				return txFail("Failed to record history", err)
+			}
+			return nil
+		})
+		if err != nil {
+			respondTxError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, invite)
+	}
+}
+
+func createInvite(c *gin.Context, db *sql.DB, column string, targetID int, validRole func(authz.Role) bool) {
+	var req struct {
+		Role           authz.Role `json:"role" binding:"required"`
+		ExpiresInHours int        `json:"expires_in_hours"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+
+	ttl := defaultInviteTTL
+	if req.ExpiresInHours > 0 {
+		ttl = time.Duration(req.ExpiresInHours) * time.Hour
+	}
+	if ttl > maxInviteTTL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invites can be valid for at most 30 days"})
+		return
+	}
+
+	var invite models.Invite
+	err := db.QueryRow(
+		"INSERT INTO invites ("+column+", role, created_by, expires_at) VALUES ($1, $2, $3, $4) RETURNING "+inviteColumns,
+		targetID, req.Role, auth.UserID(c), time.Now().Add(ttl),
+	).Scan(&invite.ID, &invite.ListID, &invite.HouseholdID, &invite.Role, &invite.CreatedBy,
+		&invite.ExpiresAt, &invite.AcceptedBy, &invite.AcceptedAt, &invite.RevokedAt, &invite.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invite"})
+		return
+	}
+	invite.Token = auth.SignInvite(invite.ID, invite.ExpiresAt)
+
+	c.JSON(http.StatusCreated, invite)
+}
+
+func getInvites(c *gin.Context, db *sql.DB, column string, targetID int) {
+	rows, err := db.Query(
+		"SELECT "+inviteColumns+" FROM invites WHERE "+column+" = $1 ORDER BY created_at DESC",
+		targetID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve invites"})
+		return
+	}
+	defer rows.Close()
+
+	invites := []models.Invite{}
+	for rows.Next() {
+		var invite models.Invite
+		if err := rows.Scan(&invite.ID, &invite.ListID, &invite.HouseholdID, &invite.Role, &invite.CreatedBy,
+			&invite.ExpiresAt, &invite.AcceptedBy, &invite.AcceptedAt, &invite.RevokedAt, &invite.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan invite"})
+			return
+		}
+		// Only pending invites still carry a usable link
+		if invite.AcceptedAt == nil && invite.RevokedAt == nil && invite.ExpiresAt.After(time.Now()) {
+			invite.Token = auth.SignInvite(invite.ID, invite.ExpiresAt)
+		}
+		invites = append(invites, invite)
+	}
+
+	c.JSON(http.StatusOK, invites)
+}
+
+func revokeInvite(c *gin.Context, db *sql.DB, column string, targetID int) {
+	inviteID, err := strconv.Atoi(c.Param("inviteId"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+		return
+	}
+
+	result, err := db.Exec(
+		"UPDATE invites SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND "+column+" = $2 AND accepted_at IS NULL AND revoked_at IS NULL",
+		inviteID, targetID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invite"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Invite revoked successfully"})
+}
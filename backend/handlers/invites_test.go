@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/shopping-list/backend/auth"
+	"github.com/shopping-list/backend/authz"
+	"github.com/shopping-list/backend/db/dbtest"
+	"github.com/shopping-list/backend/models"
+	"github.com/shopping-list/backend/repository"
+)
+
+func TestAcceptInviteToRemovedList(t *testing.T) {
+	auth.SetSecret([]byte("test secret"))
+	dbtest.Run(t, func(t *testing.T, conn *sql.DB) {
+		repos := repository.NewSQL(conn)
+		alice := dbtest.User(t, conn, "alice@example.com")
+		bob := models.User{ID: dbtest.User(t, conn, "bob@example.com"), Email: "bob@example.com"}
+		router := routerAs(bob)
+		router.POST("/invites/:token/accept", AcceptInvite(conn))
+
+		// invite creates a list with an invite to it and returns the path
+		// that accepts the invite
+		invite := func(name string) (models.ShoppingList, string) {
+			t.Helper()
+			list := models.ShoppingList{UserID: alice, Name: name}
+			if err := repos.Lists().Create(&list); err != nil {
+				t.Fatal(err)
+			}
+			expiresAt := time.Now().Add(time.Hour)
+			var inviteID int
+			err := conn.QueryRow(
+				"INSERT INTO invites (list_id, role, created_by, expires_at) VALUES ($1, 'editor', $2, $3) RETURNING id",
+				list.ID, alice, expiresAt,
+			).Scan(&inviteID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return list, "/invites/" + auth.SignInvite(inviteID, expiresAt) + "/accept"
+		}
+
+		trashed, trashedPath := invite("Groceries")
+		if err := repos.Lists().Delete(trashed.ID, nil); err != nil {
+			t.Fatal(err)
+		}
+		done, donePath := invite("Last week")
+		if _, err := repos.History().ArchiveList(done.ID, alice, nil); err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range []string{trashedPath, donePath} {
+			if w := request(t, router, http.MethodPost, path, nil); w.Code != http.StatusNotFound {
+				t.Fatalf("accepting an invite to a removed list = %d %s, want 404", w.Code, w.Body.String())
+			}
+		}
+		var members, accepted int
+		if err := conn.QueryRow("SELECT COUNT(*) FROM list_members WHERE user_id = $1", bob.ID).Scan(&members); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.QueryRow("SELECT COUNT(*) FROM invites WHERE accepted_at IS NOT NULL").Scan(&accepted); err != nil {
+			t.Fatal(err)
+		}
+		if members != 0 || accepted != 0 {
+			t.Fatalf("bob joined %d lists and used %d invites, want none", members, accepted)
+		}
+
+		// The invite still works once the list is back from the trash
+		if _, err := repos.Trash().RestoreList(trashed.ID); err != nil {
+			t.Fatal(err)
+		}
+		if w := request(t, router, http.MethodPost, trashedPath, nil); w.Code != http.StatusOK {
+			t.Fatalf("accepting the invite after a restore = %d %s, want 200", w.Code, w.Body.String())
+		}
+		if err := authz.CheckList(conn, bob.ID, trashed.ID, authz.RoleEditor); err != nil {
+			t.Errorf("bob on the restored list: %v", err)
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/authz"
+	"github.com/shopping-list/backend/models"
+	"github.com/shopping-list/backend/repository"
+)
+
+func TestUpdateItemValidation(t *testing.T) {
+	const alice = 101
+	repos := repository.NewMemory()
+	access := checker{lists: repos.Lists(), items: repos.Items(), roles: map[int]map[int]authz.Role{}}
+	router := gin.New()
+	router.Use(asUser)
+	router.PUT("/items/:id", requireItem(access, authz.RoleEditor), UpdateItem(repos.Items(), noEvents{}))
+
+	list := models.ShoppingList{UserID: alice, Name: "Groceries"}
+	if err := repos.Lists().Create(&list); err != nil {
+		t.Fatal(err)
+	}
+	milk := models.ShoppingItem{ListID: list.ID, Name: "Milk", Quantity: 1}
+	if err := repos.Items().Create(&milk, alice); err != nil {
+		t.Fatal(err)
+	}
+	path := "/items/" + strconv.Itoa(milk.ID)
+
+	for _, body := range []gin.H{
+		{"list_id": list.ID, "name": "", "quantity": 1},
+		{"list_id": list.ID, "name": "  ", "quantity": 1},
+		{"list_id": list.ID, "name": "Milk", "quantity": 0},
+		{"list_id": list.ID, "name": "Milk", "quantity": -2},
+	} {
+		if w := requestAs(t, router, alice, http.MethodPut, path, body); w.Code != http.StatusBadRequest {
+			t.Errorf("PUT %v = %d, want 400", body, w.Code)
+		}
+	}
+	if got, err := repos.Items().Get(milk.ID); err != nil || got.Name != "Milk" || got.Quantity != 1 {
+		t.Fatalf("milk after refused updates = %+v, %v", got, err)
+	}
+
+	w := requestAs(t, router, alice, http.MethodPut, path, gin.H{"list_id": list.ID, "name": " Oat milk ", "quantity": 2})
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT = %d %s", w.Code, w.Body.String())
+	}
+	if got, err := repos.Items().Get(milk.ID); err != nil || got.Name != "Oat milk" || got.Quantity != 2 {
+		t.Fatalf("milk after the update = %+v, %v", got, err)
+	}
+}
+
+func TestPatchItemValidation(t *testing.T) {
+	const alice = 101
+	repos := repository.NewMemory()
+	access := checker{lists: repos.Lists(), items: repos.Items(), roles: map[int]map[int]authz.Role{}}
+	router := gin.New()
+	router.Use(asUser)
+	router.PATCH("/items/:id", requireItem(access, authz.RoleEditor), PatchItem(repos.Items(), noEvents{}))
+
+	list := models.ShoppingList{UserID: alice, Name: "Groceries"}
+	if err := repos.Lists().Create(&list); err != nil {
+		t.Fatal(err)
+	}
+	milk := models.ShoppingItem{ListID: list.ID, Name: "Milk", Quantity: 1}
+	if err := repos.Items().Create(&milk, alice); err != nil {
+		t.Fatal(err)
+	}
+	path := "/items/" + strconv.Itoa(milk.ID)
+
+	for _, body := range []gin.H{
+		{"name": ""},
+		{"name": "  "},
+		{"quantity": 0},
+		{"quantity": -3},
+		{"name": "Oat milk", "quantity": -3},
+	} {
+		if w := requestAs(t, router, alice, http.MethodPatch, path, body); w.Code != http.StatusBadRequest {
+			t.Errorf("PATCH %v = %d, want 400", body, w.Code)
+		}
+	}
+	if got, err := repos.Items().Get(milk.ID); err != nil || got.Name != "Milk" || got.Quantity != 1 {
+		t.Fatalf("milk after refused patches = %+v, %v", got, err)
+	}
+
+	w := requestAs(t, router, alice, http.MethodPatch, path, gin.H{"name": " Oat milk "})
+	if w.Code != http.StatusOK {
+		t.Fatalf("PATCH = %d %s", w.Code, w.Body.String())
+	}
+	if got, err := repos.Items().Get(milk.ID); err != nil || got.Name != "Oat milk" || got.Quantity != 1 {
+		t.Fatalf("milk after the patch = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/models"
+	"github.com/shopping-list/backend/repository"
+)
+
+var jobStatuses = map[string]bool{
+	models.JobPending: true,
+	models.JobRunning: true,
+	models.JobDone:    true,
+	models.JobDead:    true,
+}
+
+// GetJobs returns a page of background jobs, newest first. Supports
+// cursor, limit, sort (created_at or -created_at), status and kind filters;
+// status=dead lists the dead letters.
+func GetJobs(jobs repository.JobRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q := repository.JobQuery{Status: c.Query("status"), Kind: c.Query("kind")}
+		if q.Status != "" && !jobStatuses[q.Status] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+			return
+		}
+		var ok bool
+		if q.Page, ok = pageParams(c); !ok {
+			return
+		}
+
+		list, next, err := jobs.List(q)
+		if err != nil {
+			respondPageError(c, err, "Failed to retrieve jobs")
+			return
+		}
+
+		c.JSON(http.StatusOK, newPage(list, next))
+	}
+}
+
+// GetJob returns a single background job, including its last error
+func GetJob(jobs repository.JobRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, _ := strconv.Atoi(c.Param("id"))
+
+		job, err := jobs.Get(id)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error loading job %d: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve job"})
+			return
+		}
+
+		c.JSON(http.StatusOK, job)
+	}
+}
+
+// RetryJob runs a dead-lettered job, or one waiting to be retried, right
+// away with a fresh set of attempts
+func RetryJob(jobs repository.JobRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, _ := strconv.Atoi(c.Param("id"))
+
+		job, err := jobs.Retry(id)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+			return
+		}
+		if errors.Is(err, repository.ErrJobState) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Only dead or pending jobs can be retried"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error retrying job %d: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry job"})
+			return
+		}
+
+		c.JSON(http.StatusOK, job)
+	}
+}
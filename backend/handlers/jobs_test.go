@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/models"
+	"github.com/shopping-list/backend/repository"
+)
+
+func TestRetryJob(t *testing.T) {
+	jobs := repository.NewMemory().Jobs()
+	router := gin.New()
+	router.POST("/jobs/:id/retry", RetryJob(jobs))
+
+	// Run a job into the ground, and another to completion
+	dead := models.Job{Kind: "email", MaxAttempts: 1}
+	done := models.Job{Kind: "email"}
+	for _, job := range []*models.Job{&dead, &done} {
+		if err := jobs.Enqueue(job); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := jobs.Claim(time.Now().Add(time.Second)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := jobs.Fail(dead.ID, "mail server down", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := jobs.Complete(done.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		id     int
+		status int
+	}{
+		{"dead", dead.ID, http.StatusOK},
+		{"pending", dead.ID, http.StatusOK},
+		{"done", done.ID, http.StatusConflict},
+		{"missing", 999, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := request(t, router, http.MethodPost, "/jobs/"+strconv.Itoa(tt.id)+"/retry", nil)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d %s, want %d", tt.name, w.Code, w.Body.String(), tt.status)
+		}
+	}
+
+	job, err := jobs.Get(dead.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != models.JobPending || job.Attempts != 0 {
+		t.Errorf("retried job = %+v, want pending with no attempts", job)
+	}
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/auth"
+	"github.com/shopping-list/backend/authz"
+	"github.com/shopping-list/backend/events"
+	"github.com/shopping-list/backend/models"
+)
+
+// GetListMembers retrieves the owner and members of a shopping list
+func GetListMembers(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+
+		rows, err := db.Query(
+			`SELECT l.id, u.id, u.email, 'owner', l.created_at FROM shopping_lists l
+			JOIN users u ON u.id = l.user_id
+			WHERE l.id = $1
+			UNION ALL
+			SELECT m.list_id, u.id, u.email, m.role, m.created_at FROM list_members m
+			JOIN users u ON u.id = m.user_id
+			WHERE m.list_id = $1
+			ORDER BY 5`,
+			id,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve members"})
+			return
+		}
+		defer rows.Close()
+
+		members := []models.ListMember{}
+		for rows.Next() {
+			var m models.ListMember
+			if err := rows.Scan(&m.ListID, &m.UserID, &m.Email, &m.Role, &m.CreatedAt); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan member"})
+				return
+			}
+			members = append(members, m)
+		}
+
+		c.JSON(http.StatusOK, members)
+	}
+}
+
+// AddListMember shares a shopping list with another user by email
+func AddListMember(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listID := authz.ListID(c)
+		var req struct {
+			Email string     `json:"email" binding:"required"`
+			Role  authz.Role `json:"role" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !req.Role.ValidForList() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+			return
+		}
+
+		member := models.ListMember{ListID: listID, Role: string(req.Role)}
+		var ownerID int
+		err := db.QueryRow(
+			`SELECT u.id, u.email, l.user_id FROM users u, shopping_lists l
+			WHERE u.email = $1 AND l.id = $2`,
+			strings.ToLower(strings.TrimSpace(req.Email)), listID,
+		).Scan(&member.UserID, &member.Email, &ownerID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+			return
+		}
+		if member.UserID == ownerID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "User already owns this list"})
+			return
+		}
+
+		err = db.QueryRow(
+			"INSERT INTO list_members (list_id, user_id, role) VALUES ($1, $2, $3) RETURNING created_at",
+			listID, member.UserID, member.Role,
+		).Scan(&member.CreatedAt)
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "User is already a member"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add member"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, member)
+	}
+}
+
+// UpdateListMember changes the role of a list member
+func UpdateListMember(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.Atoi(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return
+		}
+		var req struct {
+			Role authz.Role `json:"role" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !req.Role.ValidForList() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+			return
+		}
+
+		result, err := db.Exec(
+			"UPDATE list_members SET role = $1 WHERE list_id = $2 AND user_id = $3",
+			req.Role, authz.ListID(c), userID,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member"})
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Member updated successfully"})
+	}
+}
+
+// RemoveListMember removes a member from a list. Owners can remove anyone;
+// other members can only remove themselves.
+func RemoveListMember(db *sql.DB, hub events.Publisher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, err := strconv.Atoi(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return
+		}
+		if userID != auth.UserID(c) && authz.CurrentRole(c) != authz.RoleOwner {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+			return
+		}
+
+		listID := authz.ListID(c)
+		result, err := db.Exec(
+			"DELETE FROM list_members WHERE list_id = $1 AND user_id = $2",
+			listID, userID,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Member not found"})
+			return
+		}
+
+		hub.Publish(listID, auth.UserID(c), events.MemberRemoved, gin.H{"list_id": listID, "user_id": userID})
+		c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+	}
+}
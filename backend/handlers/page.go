@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/repository"
+)
+
+// page is the envelope of paginated responses. NextCursor is null on the
+// last page.
+type page struct {
+	Data       interface{} `json:"data"`
+	NextCursor *string     `json:"next_cursor"`
+}
+
+func newPage(data interface{}, next string) page {
+	p := page{Data: data}
+	if next != "" {
+		p.NextCursor = &next
+	}
+	return p
+}
+
+// pageParams reads the cursor, limit and sort query parameters. On invalid
+// input it responds with 400 and returns ok == false.
+func pageParams(c *gin.Context) (repository.Page, bool) {
+	p := repository.Page{Sort: c.Query("sort"), Cursor: c.Query("cursor")}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return p, false
+		}
+		p.Limit = n
+	}
+	return p, true
+}
+
+// timeParams reads RFC 3339 timestamp query parameters into the given
+// pointers, skipping missing ones. On invalid input it responds with 400
+// and returns false.
+func timeParams(c *gin.Context, params map[string]**time.Time) bool {
+	for name, dest := range params {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name + ", expected an RFC 3339 timestamp"})
+			return false
+		}
+		*dest = &t
+	}
+	return true
+}
+
+// respondPageError answers an error from a paginated repository query
+func respondPageError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, repository.ErrInvalidCursor):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+	case errors.Is(err, repository.ErrInvalidSort):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sort"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/auth"
+	"github.com/shopping-list/backend/authz"
+	"github.com/shopping-list/backend/models"
+	"github.com/shopping-list/backend/repository"
+)
+
+// GetRecurrences returns the recurring templates and items the user set up
+func GetRecurrences(recurrences repository.RecurrenceRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		recs, err := recurrences.ForUser(auth.UserID(c))
+		if err != nil {
+			log.Printf("Error loading recurrences: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve recurrences"})
+			return
+		}
+
+		c.JSON(http.StatusOK, recs)
+	}
+}
+
+// CreateTemplateRecurrence puts the items of a template on a list on a
+// schedule, see createRecurrence
+func CreateTemplateRecurrence(recurrences repository.RecurrenceRepository, access authz.Checker) gin.HandlerFunc {
+	return createRecurrence(recurrences, access, true)
+}
+
+// CreateItemRecurrence puts an item back on a list on a schedule, see
+// createRecurrence
+func CreateItemRecurrence(recurrences repository.RecurrenceRepository, access authz.Checker) gin.HandlerFunc {
+	return createRecurrence(recurrences, access, false)
+}
+
+// createRecurrence stores a recurrence rule (e.g. "FREQ=WEEKLY;BYDAY=SA")
+// for the template or item in the :id param. The body may set when the
+// rule starts and the active list the items go to; without one a template
+// becomes a new list each time and an item goes back to its own list.
+func createRecurrence(recurrences repository.RecurrenceRepository, access authz.Checker, template bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, _ := strconv.Atoi(c.Param("id"))
+		userID := auth.UserID(c)
+
+		var req struct {
+			Rule     string     `json:"rule" binding:"required"`
+			StartsAt *time.Time `json:"starts_at"`
+			ListID   *int       `json:"list_id"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.ListID != nil {
+			if err := access.CheckList(userID, *req.ListID, authz.RoleEditor); err != nil {
+				authz.Abort(c, err)
+				return
+			}
+		}
+
+		rec := models.Recurrence{UserID: userID, ListID: req.ListID, Rule: req.Rule}
+		if template {
+			rec.TemplateID = &id
+		} else {
+			rec.ItemID = &id
+		}
+		if req.StartsAt != nil {
+			rec.StartsAt = *req.StartsAt
+		}
+
+		err := recurrences.Create(&rec)
+		switch {
+		case errors.Is(err, models.ErrInvalidRule):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		case errors.Is(err, repository.ErrInvalidTarget):
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Recurring items need an active list to go to"})
+			return
+		case errors.Is(err, repository.ErrNotFound) && template:
+			c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+			return
+		case errors.Is(err, repository.ErrNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Item not found"})
+			return
+		case err != nil:
+			log.Printf("Error creating recurrence: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create recurrence"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, rec)
+	}
+}
+
+// DeleteRecurrence stops a recurrence. Only the user who set it up can.
+func DeleteRecurrence(recurrences repository.RecurrenceRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, _ := strconv.Atoi(c.Param("id"))
+
+		rec, err := recurrences.Get(id)
+		if err == nil && rec.UserID != auth.UserID(c) {
+			err = repository.ErrNotFound
+		}
+		if err == nil {
+			err = recurrences.Delete(id)
+		}
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Recurrence not found"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error deleting recurrence: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete recurrence"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Recurrence deleted successfully"})
+	}
+}
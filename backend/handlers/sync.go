@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/auth"
+	"github.com/shopping-list/backend/authz"
+	"github.com/shopping-list/backend/events"
+	"github.com/shopping-list/backend/models"
+	"github.com/shopping-list/backend/repository"
+)
+
+const maxSyncOperations = 500
+
+// Sync operation types accepted by ApplySync
+const (
+	opCreateList = "create_list"
+	opUpdateList = "update_list"
+	opDeleteList = "delete_list"
+	opCreateItem = "create_item"
+	opUpdateItem = "update_item"
+	opDeleteItem = "delete_item"
+)
+
+// syncOperation is a mutation queued by an offline client. Rows created
+// offline are given a client ref so later operations in the same batch can
+// point at them before they have a server id. Updates and deletes that
+// carry a version only apply if the row is still at that version.
+type syncOperation struct {
+	OpID      string   `json:"op_id"`
+	Type      string   `json:"type" binding:"required"`
+	Ref       string   `json:"ref"`
+	ListID    int      `json:"list_id"`
+	ListRef   string   `json:"list_ref"`
+	ItemID    int      `json:"item_id"`
+	ItemRef   string   `json:"item_ref"`
+	Name      *string  `json:"name"`
+	Quantity  *float64 `json:"quantity"`
+	Unit      *string  `json:"unit"`
+	Purchased *bool    `json:"purchased"`
+	Version   *int     `json:"version"`
+
+	// ChangedAt is when the client made the edit, used to merge item
+	// updates field by field
+	ChangedAt *time.Time `json:"changed_at"`
+}
+
+type syncResult struct {
+	OpID   string `json:"op_id"`
+	Status int    `json:"status"`
+	ID     int    `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// GetSyncChanges returns every visible list and item created or updated
+// after the since cursor, plus tombstones for deletions. Lists and items
+// moved to the trash are reported as deleted; restoring them bumps their
+// change_seq so they come back as changes. Templates are included, told
+// apart by their kind. A missing or zero cursor returns a full snapshot.
+func GetSyncChanges(sync repository.SyncRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := auth.CurrentUser(c)
+		since, err := strconv.ParseInt(c.DefaultQuery("since", "0"), 10, 64)
+		if err != nil || since < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+
+		changes, err := sync.Changes(user.ID, user.ActiveHouseholdID, since)
+		if err != nil {
+			log.Printf("Error retrieving sync changes: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve changes"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"cursor":  strconv.FormatInt(changes.Cursor, 10),
+			"lists":   changes.Lists,
+			"items":   changes.Items,
+			"deleted": changes.Deleted,
+		})
+	}
+}
+
+// ApplySync applies a batch of queued offline mutations in order. Each
+// operation succeeds or fails on its own and gets its own result.
+func ApplySync(lists repository.ListRepository, items repository.ItemRepository, access authz.Checker, hub events.Publisher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Operations []syncOperation `json:"operations" binding:"required,dive"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(req.Operations) > maxSyncOperations {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Too many operations in one batch"})
+			return
+		}
+
+		s := &syncBatch{
+			lists:    lists,
+			items:    items,
+			access:   access,
+			hub:      hub,
+			user:     auth.CurrentUser(c),
+			listRefs: map[string]int{},
+			itemRefs: map[string]int{},
+		}
+		results := make([]syncResult, 0, len(req.Operations))
+		for _, op := range req.Operations {
+			result := s.apply(op)
+			result.OpID = op.OpID
+			results = append(results, result)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"results": results})
+	}
+}
+
+type syncBatch struct {
+	lists    repository.ListRepository
+	items    repository.ItemRepository
+	access   authz.Checker
+	hub      events.Publisher
+	user     models.User
+	listRefs map[string]int
+	itemRefs map[string]int
+}
+
+var errUnknownRef = errors.New("unknown client reference")
+
+func (s *syncBatch) apply(op syncOperation) syncResult {
+	listID, err := resolveRef(op.ListID, op.ListRef, s.listRefs)
+	if err != nil {
+		return syncResult{Status: http.StatusBadRequest, Error: err.Error()}
+	}
+	itemID, err := resolveRef(op.ItemID, op.ItemRef, s.itemRefs)
+	if err != nil {
+		return syncResult{Status: http.StatusBadRequest, Error: err.Error()}
+	}
+
+	switch op.Type {
+	case opCreateList:
+		return s.createList(op)
+	case opUpdateList:
+		return s.updateList(listID, op)
+	case opDeleteList:
+		return s.deleteList(listID, op)
+	case opCreateItem:
+		return s.createItem(listID, op)
+	case opUpdateItem:
+		return s.updateItem(itemID, op)
+	case opDeleteItem:
+		return s.deleteItem(itemID, op)
+	default:
+		return syncResult{Status: http.StatusBadRequest, Error: "Unknown operation type"}
+	}
+}
+
+func (s *syncBatch) createList(op syncOperation) syncResult {
+	var name string
+	if op.Name != nil {
+		name = strings.TrimSpace(*op.Name)
+	}
+	if name == "" {
+		return syncResult{Status: http.StatusBadRequest, Error: "name is required"}
+	}
+
+	list := models.ShoppingList{UserID: s.user.ID, HouseholdID: s.user.ActiveHouseholdID, Name: name}
+	if err := s.lists.Create(&list); err != nil {
+		return syncResult{Status: http.StatusInternalServerError, Error: "Failed to create list"}
+	}
+
+	if op.Ref != "" {
+		s.listRefs[op.Ref] = list.ID
+	}
+	return syncResult{Status: http.StatusCreated, ID: list.ID}
+}
+
+func (s *syncBatch) updateList(listID int, op syncOperation) syncResult {
+	var name string
+	if op.Name != nil {
+		name = strings.TrimSpace(*op.Name)
+	}
+	if name == "" {
+		return syncResult{Status: http.StatusBadRequest, Error: "name is required"}
+	}
+	if err := s.access.CheckList(s.user.ID, listID, authz.RoleEditor); err != nil {
+		return authzResult(err)
+	}
+
+	_, err := s.lists.Rename(listID, name, op.Version, s.user.ID)
+	if errors.Is(err, repository.ErrVersionMismatch) || errors.Is(err, repository.ErrNotFound) {
+		return versionConflict()
+	}
+	if err != nil {
+		return syncResult{Status: http.StatusInternalServerError, Error: "Failed to update list"}
+	}
+
+	s.hub.Publish(listID, s.user.ID, events.ListRenamed, gin.H{"id": listID, "name": name})
+	return syncResult{Status: http.StatusOK, ID: listID}
+}
+
+func (s *syncBatch) deleteList(listID int, op syncOperation) syncResult {
+	if err := s.access.CheckList(s.user.ID, listID, authz.RoleOwner); err != nil {
+		return authzResult(err)
+	}
+
+	err := s.lists.Delete(listID, op.Version)
+	if errors.Is(err, repository.ErrVersionMismatch) || errors.Is(err, repository.ErrNotFound) {
+		return versionConflict()
+	}
+	if err != nil {
+		return syncResult{Status: http.StatusInternalServerError, Error: "Failed to delete list"}
+	}
+
+	s.hub.Publish(listID, s.user.ID, events.ListDeleted, gin.H{"id": listID})
+	return syncResult{Status: http.StatusOK, ID: listID}
+}
+
+func (s *syncBatch) createItem(listID int, op syncOperation) syncResult {
+	item := models.ShoppingItem{ListID: listID}
+	if op.Name != nil {
+		item.Name = *op.Name
+	}
+	if op.Quantity != nil {
+		item.Quantity = *op.Quantity
+	}
+	if op.Unit != nil {
+		item.Unit = *op.Unit
+	}
+	if msg := newItem(&item); msg != "" {
+		return syncResult{Status: http.StatusBadRequest, Error: msg}
+	}
+	if err := s.access.CheckList(s.user.ID, listID, authz.RoleEditor); err != nil {
+		return authzResult(err)
+	}
+
+	err := s.items.Create(&item, s.user.ID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return syncResult{Status: http.StatusNotFound, Error: "List not found"}
+	}
+	if err != nil {
+		return syncResult{Status: http.StatusInternalServerError, Error: "Failed to create item"}
+	}
+
+	if op.Ref != "" {
+		s.itemRefs[op.Ref] = item.ID
+	}
+	s.hub.Publish(item.ListID, s.user.ID, events.ItemCreated, item)
+	return syncResult{Status: http.StatusCreated, ID: item.ID}
+}
+
+// updateItem merges the fields present in the operation using the same
+// per-field last-writer-wins rules as PATCH, so queued offline edits never
+// undo newer changes to other fields
+func (s *syncBatch) updateItem(itemID int, op syncOperation) syncResult {
+	patch := repository.ItemPatch{
+		Name:      op.Name,
+		Quantity:  op.Quantity,
+		Unit:      op.Unit,
+		Purchased: op.Purchased,
+		ChangedAt: op.ChangedAt,
+	}
+	if msg := checkPatch(&patch); msg != "" {
+		return syncResult{Status: http.StatusBadRequest, Error: msg}
+	}
+	if _, err := s.access.CheckItem(s.user.ID, itemID, authz.RoleEditor); err != nil {
+		return authzResult(err)
+	}
+
+	merged, err := s.items.Merge(itemID, patch, op.Version, s.user.ID)
+	if errors.Is(err, repository.ErrVersionMismatch) || errors.Is(err, repository.ErrNotFound) {
+		return versionConflict()
+	}
+	if err != nil {
+		return syncResult{Status: http.StatusInternalServerError, Error: "Failed to update item"}
+	}
+
+	if len(merged.Applied) > 0 {
+		s.hub.Publish(merged.Item.ListID, s.user.ID, events.ItemUpdated, merged.Item)
+	}
+	return syncResult{Status: http.StatusOK, ID: merged.Item.ID}
+}
+
+func (s *syncBatch) deleteItem(itemID int, op syncOperation) syncResult {
+	listID, err := s.access.CheckItem(s.user.ID, itemID, authz.RoleEditor)
+	if err != nil {
+		return authzResult(err)
+	}
+
+	err = s.items.Delete(itemID, op.Version, s.user.ID)
+	if errors.Is(err, repository.ErrVersionMismatch) || errors.Is(err, repository.ErrNotFound) {
+		return versionConflict()
+	}
+	if err != nil {
+		return syncResult{Status: http.StatusInternalServerError, Error: "Failed to delete item"}
+	}
+
+	s.hub.Publish(listID, s.user.ID, events.ItemDeleted, gin.H{"id": itemID, "list_id": listID})
+	return syncResult{Status: http.StatusOK, ID: itemID}
+}
+
+func versionConflict() syncResult {
+	return syncResult{Status: http.StatusPreconditionFailed, Error: "Modified by someone else"}
+}
+
+func resolveRef(id int, ref string, refs map[string]int) (int, error) {
+	if ref == "" {
+		return id, nil
+	}
+	resolved, ok := refs[ref]
+	if !ok {
+		return 0, errUnknownRef
+	}
+	return resolved, nil
+}
+
+func authzResult(err error) syncResult {
+	switch {
+	case errors.Is(err, authz.ErrNotFound):
+		return syncResult{Status: http.StatusNotFound, Error: "Not found"}
+	case errors.Is(err, authz.ErrForbidden):
+		return syncResult{Status: http.StatusForbidden, Error: "Access denied"}
+	default:
+		return syncResult{Status: http.StatusInternalServerError, Error: "Failed to authorize operation"}
+	}
+}
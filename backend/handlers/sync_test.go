@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/authz"
+	"github.com/shopping-list/backend/models"
+	"github.com/shopping-list/backend/repository"
+)
+
+// syncResponse is the body of GET /sync
+type syncResponse struct {
+	Cursor  string                `json:"cursor"`
+	Lists   []models.ShoppingList `json:"lists"`
+	Items   []models.ShoppingItem `json:"items"`
+	Deleted []models.Tombstone    `json:"deleted"`
+}
+
+// syncRouter serves the sync endpoints from memory
+func syncRouter(repos *repository.Memory) *gin.Engine {
+	access := checker{lists: repos.Lists(), items: repos.Items(), roles: map[int]map[int]authz.Role{}}
+	router := gin.New()
+	router.Use(asUser)
+	router.GET("/sync", GetSyncChanges(repos.Sync()))
+	router.POST("/sync", ApplySync(repos.Lists(), repos.Items(), access, noEvents{}))
+	return router
+}
+
+func decode(t *testing.T, body []byte, out interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(body, out); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplySync(t *testing.T) {
+	const alice = 101
+	repos := repository.NewMemory()
+	router := syncRouter(repos)
+
+	// Operations can point at rows created earlier in the batch
+	ops := []gin.H{
+		{"op_id": "1", "type": opCreateList, "ref": "groceries", "name": "Groceries"},
+		{"op_id": "2", "type": opCreateItem, "ref": "milk", "list_ref": "groceries", "name": "Milk", "quantity": 2},
+		{"op_id": "3", "type": opUpdateItem, "item_ref": "milk", "purchased": true},
+		{"op_id": "4", "type": opUpdateList, "list_ref": "groceries", "version": 1, "name": "Weekly"},
+		{"op_id": "5", "type": opUpdateList, "list_ref": "groceries", "version": 1, "name": "Stale"},
+		{"op_id": "6", "type": opCreateItem, "list_ref": "unknown", "name": "Bread"},
+		{"op_id": "7", "type": "rename_everything"},
+	}
+	w := requestAs(t, router, alice, http.MethodPost, "/sync", gin.H{"operations": ops})
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /sync = %d %s", w.Code, w.Body.String())
+	}
+	var batch struct {
+		Results []syncResult `json:"results"`
+	}
+	decode(t, w.Body.Bytes(), &batch)
+	want := []int{http.StatusCreated, http.StatusCreated, http.StatusOK, http.StatusOK, http.StatusPreconditionFailed, http.StatusBadRequest, http.StatusBadRequest}
+	if len(batch.Results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(batch.Results), len(want))
+	}
+	for i, result := range batch.Results {
+		if result.OpID != ops[i]["op_id"] || result.Status != want[i] {
+			t.Errorf("op %s = %+v, want %d", ops[i]["op_id"], result, want[i])
+		}
+	}
+
+	list, err := repos.Lists().Get(batch.Results[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Name != "Weekly" || len(list.Items) != 1 || !list.Items[0].Purchased || list.Items[0].Quantity != 2 {
+		t.Errorf("list = %+v", list)
+	}
+
+	if w := requestAs(t, router, alice, http.MethodPost, "/sync", gin.H{}); w.Code != http.StatusBadRequest {
+		t.Errorf("POST /sync without operations = %d, want 400", w.Code)
+	}
+}
+
+func TestGetSyncChanges(t *testing.T) {
+	const alice, bob = 101, 102
+	repos := repository.NewMemory()
+	router := syncRouter(repos)
+	list := models.ShoppingList{UserID: alice, Name: "Groceries"}
+	if err := repos.Lists().Create(&list); err != nil {
+		t.Fatal(err)
+	}
+	milk := models.ShoppingItem{ListID: list.ID, Name: "Milk", Quantity: 1}
+	if err := repos.Items().Create(&milk, alice); err != nil {
+		t.Fatal(err)
+	}
+
+	var full syncResponse
+	w := requestAs(t, router, alice, http.MethodGet, "/sync", nil)
+	decode(t, w.Body.Bytes(), &full)
+	if w.Code != http.StatusOK || len(full.Lists) != 1 || len(full.Items) != 1 || len(full.Deleted) != 0 {
+		t.Fatalf("GET /sync = %d %+v", w.Code, full)
+	}
+	var other syncResponse
+	decode(t, requestAs(t, router, bob, http.MethodGet, "/sync", nil).Body.Bytes(), &other)
+	if len(other.Lists) != 0 || len(other.Items) != 0 {
+		t.Errorf("bob syncs %+v", other)
+	}
+
+	// The next sync picks up where the last one left off
+	if err := repos.Items().Delete(milk.ID, nil, alice); err != nil {
+		t.Fatal(err)
+	}
+	var delta syncResponse
+	decode(t, requestAs(t, router, alice, http.MethodGet, "/sync?since="+full.Cursor, nil).Body.Bytes(), &delta)
+	if len(delta.Lists) != 0 || len(delta.Items) != 0 || len(delta.Deleted) != 1 || delta.Deleted[0].ID != milk.ID {
+		t.Fatalf("delta = %+v, want milk deleted", delta)
+	}
+
+	for _, since := range []string{"soon", "-1"} {
+		if w := requestAs(t, router, alice, http.MethodGet, "/sync?since="+since, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("GET /sync?since=%s = %d, want 400", since, w.Code)
+		}
+	}
+}
+
+func TestCreateItemDefaults(t *testing.T) {
+	const alice = 101
+	repos := repository.NewMemory()
+	router := syncRouter(repos)
+	access := checker{lists: repos.Lists(), items: repos.Items(), roles: map[int]map[int]authz.Role{}}
+	router.POST("/items", CreateItem(repos.Items(), access, noEvents{}))
+	list := models.ShoppingList{UserID: alice, Name: "Groceries"}
+	if err := repos.Lists().Create(&list); err != nil {
+		t.Fatal(err)
+	}
+
+	// REST and sync create items the same way
+	create := map[string]func(body gin.H) (int, int){
+		"rest": func(body gin.H) (int, int) {
+			body["list_id"] = list.ID
+			w := requestAs(t, router, alice, http.MethodPost, "/items", body)
+			var item models.ShoppingItem
+			if w.Code == http.StatusCreated {
+				decode(t, w.Body.Bytes(), &item)
+			}
+			return w.Code, item.ID
+		},
+		"sync": func(body gin.H) (int, int) {
+			body["op_id"] = "1"
+			body["type"] = opCreateItem
+			body["list_id"] = list.ID
+			w := requestAs(t, router, alice, http.MethodPost, "/sync", gin.H{"operations": []gin.H{body}})
+			var batch struct {
+				Results []syncResult `json:"results"`
+			}
+			decode(t, w.Body.Bytes(), &batch)
+			if w.Code != http.StatusOK || len(batch.Results) != 1 {
+				t.Fatalf("POST /sync = %d %s", w.Code, w.Body.String())
+			}
+			return batch.Results[0].Status, batch.Results[0].ID
+		},
+	}
+	tests := []struct {
+		name     string
+		body     gin.H
+		status   int
+		quantity float64
+	}{
+		{"defaults", gin.H{"name": "Milk"}, http.StatusCreated, 1},
+		{"zero quantity", gin.H{"name": "Milk", "quantity": 0}, http.StatusCreated, 1},
+		{"quantity", gin.H{"name": "Milk", "quantity": 2.5}, http.StatusCreated, 2.5},
+		{"purchased", gin.H{"name": "Milk", "purchased": true}, http.StatusCreated, 1},
+		{"negative quantity", gin.H{"name": "Milk", "quantity": -1}, http.StatusBadRequest, 0},
+		{"no name", gin.H{"quantity": 1}, http.StatusBadRequest, 0},
+		{"blank name", gin.H{"name": "  "}, http.StatusBadRequest, 0},
+	}
+	for via, send := range create {
+		for _, tt := range tests {
+			status, id := send(copyBody(tt.body))
+			if status != tt.status {
+				t.Errorf("%s %s = %d, want %d", via, tt.name, status, tt.status)
+				continue
+			}
+			if status != http.StatusCreated {
+				continue
+			}
+			item, err := repos.Items().Get(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if item.Quantity != tt.quantity || item.Purchased || item.Name != "Milk" {
+				t.Errorf("%s %s created %+v, want %g unpurchased", via, tt.name, item, tt.quantity)
+			}
+		}
+	}
+}
+
+// copyBody copies a request body so each request can add its own fields
+func copyBody(body gin.H) gin.H {
+	c := gin.H{}
+	for k, v := range body {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/auth"
+	"github.com/shopping-list/backend/authz"
+	"github.com/shopping-list/backend/models"
+	"github.com/shopping-list/backend/repository"
+)
+
+// GetTemplates retrieves a page of the templates of the authenticated
+// user's active household (or their personal ones when none is active),
+// with the same parameters as GetUserLists. Templates come with their items,
+// so paging through this exports them the way GetUserLists does lists.
+func GetTemplates(lists repository.ListRepository) gin.HandlerFunc {
+	return listPage(lists, models.ListKindTemplate)
+}
+
+// SaveAsTemplate saves a copy of a list as a template
+func SaveAsTemplate(lists repository.ListRepository) gin.HandlerFunc {
+	return copyList(lists, models.ListKindList, models.ListKindTemplate, "List not found")
+}
+
+// InstantiateTemplate creates a new list from a template
+func InstantiateTemplate(lists repository.ListRepository) gin.HandlerFunc {
+	return copyList(lists, models.ListKindTemplate, models.ListKindList, "Template not found")
+}
+
+// DuplicateList creates a new list with the items of a list
+func DuplicateList(lists repository.ListRepository) gin.HandlerFunc {
+	return copyList(lists, models.ListKindList, models.ListKindList, "List not found")
+}
+
+// copyList copies a list of one kind into a new list of another for the
+// current user. The optional body names the copy.
+func copyList(lists repository.ListRepository, from, to, notFound string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := auth.CurrentUser(c)
+
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		list, err := lists.Copy(authz.ListID(c), repository.ListCopy{
+			From:        from,
+			To:          to,
+			Name:        strings.TrimSpace(req.Name),
+			UserID:      user.ID,
+			HouseholdID: user.ActiveHouseholdID,
+		})
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+			return
+		}
+		if err != nil {
+			log.Printf("Error copying list: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to copy list"})
+			return
+		}
+
+		c.Header("ETag", etag(list.Version))
+		c.JSON(http.StatusCreated, list)
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/auth"
+	"github.com/shopping-list/backend/authz"
+	"github.com/shopping-list/backend/events"
+	"github.com/shopping-list/backend/repository"
+)
+
+// GetTrash returns the deleted lists and items the user can restore
+func GetTrash(trash repository.TrashRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := auth.CurrentUser(c)
+
+		contents, err := trash.Visible(user.ID, user.ActiveHouseholdID)
+		if err != nil {
+			log.Printf("Error loading trash: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve trash"})
+			return
+		}
+
+		c.JSON(http.StatusOK, contents)
+	}
+}
+
+// RestoreTrashedList takes a list and its items out of the trash
+func RestoreTrashedList(trash repository.TrashRepository, hub events.Publisher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := authz.ListID(c)
+
+		list, err := trash.RestoreList(id)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "List not found in trash"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore list"})
+			return
+		}
+
+		hub.Publish(id, auth.UserID(c), events.ListRestored, list)
+		c.Header("ETag", etag(list.Version))
+		c.JSON(http.StatusOK, list)
+	}
+}
+
+// PurgeTrashedList permanently deletes a list in the trash
+func PurgeTrashedList(trash repository.TrashRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := trash.PurgeList(authz.ListID(c))
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "List not found in trash"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge list"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "List permanently deleted"})
+	}
+}
+
+// RestoreTrashedItem takes an item out of the trash
+func RestoreTrashedItem(trash repository.TrashRepository, hub events.Publisher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, _ := strconv.Atoi(c.Param("id"))
+
+		item, err := trash.RestoreItem(id, auth.UserID(c))
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Item not found in trash"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore item"})
+			return
+		}
+
+		hub.Publish(item.ListID, auth.UserID(c), events.ItemRestored, item)
+		c.Header("ETag", etag(item.Version))
+		c.JSON(http.StatusOK, item)
+	}
+}
+
+// PurgeTrashedItem permanently deletes an item in the trash
+func PurgeTrashedItem(trash repository.TrashRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, _ := strconv.Atoi(c.Param("id"))
+
+		err := trash.PurgeItem(id)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Item not found in trash"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge item"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "Item permanently deleted"})
+	}
+}
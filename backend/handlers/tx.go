@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/db"
+)
+
+// withTx runs fn inside a single transaction, see db.WithTx
+func withTx(conn *sql.DB, fn func(tx *sql.Tx) error) error {
+	err := db.WithTx(conn, fn)
+	var te *txError
+	if err != nil && !errors.As(err, &te) {
+		return txFail("Transaction failed", err)
+	}
+	return err
+}
+
+// txError tags a failed step inside a transaction with the message to
+// return to the client
+type txError struct {
+	status  int
+	message string
+	err     error
+}
+
+func (e *txError) Error() string {
+	if e.err == nil {
+		return e.message
+	}
+	return fmt.Sprintf("%s: %v", e.message, e.err)
+}
+
+func (e *txError) Unwrap() error {
+	return e.err
+}
+
+// txFail reports an internal failure of a transaction step
+func txFail(message string, err error) error {
+	return &txError{status: http.StatusInternalServerError, message: message, err: err}
+}
+
+// txReject aborts a transaction with a client-facing status
+func txReject(status int, message string) error {
+	return &txError{status: status, message: message}
+}
+
+// respondTxError writes the response for an error returned by withTx
+func respondTxError(c *gin.Context, err error) {
+	var te *txError
+	if errors.As(err, &te) {
+		if te.status == http.StatusInternalServerError {
+			log.Printf("Transaction failed: %v", err)
+		}
+		c.JSON(te.status, gin.H{"error": te.message})
+		return
+	}
+
+	log.Printf("Transaction failed: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+}
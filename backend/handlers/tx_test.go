@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/auth"
+	"github.com/shopping-list/backend/authz"
+	"github.com/shopping-list/backend/db/dbtest"
+	"github.com/shopping-list/backend/models"
+)
+
+// accessTables hold everything the household and invite flows write
+var accessTables = []string{"users", "households", "household_members", "invites", "list_members", "list_history"}
+
+// failEachStatement sends a request with each of the statements it runs
+// failing in turn, checking that a failure answers 500 and leaves no trace
+// behind, and finally sends it through and returns its status
+func failEachStatement(t *testing.T, conn *sql.DB, faults *dbtest.Faults, send func() int) int {
+	t.Helper()
+	faults.FailAt(0)
+	before := dbtest.Snapshot(t, conn, accessTables...)
+	for n := 1; ; n++ {
+		faults.FailAt(n)
+		status := send()
+		if !faults.Failed() {
+			faults.FailAt(0)
+			return status
+		}
+		faults.FailAt(0)
+		if status != http.StatusInternalServerError {
+			t.Fatalf("statement %d failed but the request got %d", n, status)
+		}
+		if after := dbtest.Snapshot(t, conn, accessTables...); !reflect.DeepEqual(before, after) {
+			t.Fatalf("statement %d failed and left\n%v\nbehind, had\n%v", n, after, before)
+		}
+	}
+}
+
+// routerAs serves requests as user
+func routerAs(user models.User) *gin.Engine {
+	router := gin.New()
+	router.Use(func(c *gin.Context) { auth.SetUser(c, user) })
+	return router
+}
+
+func TestCreateHouseholdFailures(t *testing.T) {
+	dbtest.RunFaulty(t, func(t *testing.T, conn *sql.DB, faults *dbtest.Faults) {
+		alice := models.User{ID: dbtest.User(t, conn, "alice@example.com"), Email: "alice@example.com"}
+		router := routerAs(alice)
+		router.POST("/households", CreateHousehold(conn))
+
+		status := failEachStatement(t, conn, faults, func() int {
+			return request(t, router, http.MethodPost, "/households", gin.H{"name": "Home"}).Code
+		})
+		if status != http.StatusCreated {
+			t.Fatalf("CreateHousehold = %d, want 201", status)
+		}
+		var active *int
+		if err := conn.QueryRow("SELECT active_household_id FROM users WHERE id = $1", alice.ID).Scan(&active); err != nil || active == nil {
+			t.Fatalf("active household = %v, %v", active, err)
+		}
+	})
+}
+
+func TestRemoveHouseholdMemberFailures(t *testing.T) {
+	dbtest.RunFaulty(t, func(t *testing.T, conn *sql.DB, faults *dbtest.Faults) {
+		alice := dbtest.User(t, conn, "alice@example.com")
+		bob := dbtest.User(t, conn, "bob@example.com")
+		household := dbtest.Household(t, conn, "Home", alice, bob)
+		dbtest.Exec(t, conn, "UPDATE users SET active_household_id = $1 WHERE id = $2", household, bob)
+
+		router := routerAs(models.User{ID: alice})
+		router.DELETE("/households/:id/members/:userId", authz.RequireHousehold(conn, authz.RoleOwner), RemoveHouseholdMember(conn, noEvents{}))
+		path := "/households/" + strconv.Itoa(household) + "/members/" + strconv.Itoa(bob)
+
+		status := failEachStatement(t, conn, faults, func() int {
+			return request(t, router, http.MethodDelete, path, nil).Code
+		})
+		if status != http.StatusOK {
+			t.Fatalf("RemoveHouseholdMember = %d, want 200", status)
+		}
+		var active *int
+		if err := conn.QueryRow("SELECT active_household_id FROM users WHERE id = $1", bob).Scan(&active); err != nil || active != nil {
+			t.Fatalf("bob's active household = %v, %v; want none", active, err)
+		}
+	})
+}
+
+func TestAcceptInviteFailures(t *testing.T) {
+	auth.SetSecret([]byte("test secret"))
+	dbtest.RunFaulty(t, func(t *testing.T, conn *sql.DB, faults *dbtest.Faults) {
+		alice := dbtest.User(t, conn, "alice@example.com")
+		bob := models.User{ID: dbtest.User(t, conn, "bob@example.com"), Email: "bob@example.com"}
+		household := dbtest.Household(t, conn, "Home", alice)
+		var listID int
+		if err := conn.QueryRow("INSERT INTO shopping_lists (user_id, name) VALUES ($1, 'Groceries') RETURNING id", alice).Scan(&listID); err != nil {
+			t.Fatal(err)
+		}
+
+		router := routerAs(bob)
+		router.POST("/invites/:token/accept", AcceptInvite(conn))
+		expiresAt := time.Now().Add(time.Hour)
+		for _, target := range []struct {
+			column, role string
+			id           int
+		}{
+			{"list_id", "editor", listID},
+			{"household_id", "member", household},
+		} {
+			var inviteID int
+			err := conn.QueryRow(
+				"INSERT INTO invites ("+target.column+", role, created_by, expires_at) VALUES ($1, $2, $3, $4) RETURNING id",
+				target.id, target.role, alice, expiresAt,
+			).Scan(&inviteID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := "/invites/" + auth.SignInvite(inviteID, expiresAt) + "/accept"
+
+			// Claiming the invite, granting access and recording it go
+			// through together or not at all
+			status := failEachStatement(t, conn, faults, func() int {
+				return request(t, router, http.MethodPost, path, nil).Code
+			})
+			if status != http.StatusOK {
+				t.Fatalf("accepting the %s invite = %d, want 200", target.column, status)
+			}
+		}
+
+		if err := authz.CheckList(conn, bob.ID, listID, authz.RoleEditor); err != nil {
+			t.Errorf("bob on the list: %v", err)
+		}
+		if role, err := authz.HouseholdRole(conn, bob.ID, household); err != nil || role != authz.RoleMember {
+			t.Errorf("bob in the household = %s, %v", role, err)
+		}
+		var entries int
+		if err := conn.QueryRow("SELECT COUNT(*) FROM list_history WHERE action = $1", models.ActionInviteAccepted).Scan(&entries); err != nil || entries != 2 {
+			t.Errorf("recorded %d acceptances, %v; want 2", entries, err)
+		}
+	})
+}
@@ -0,0 +1,243 @@
+// Package jobs runs background work from the job queue on a pool of
+// workers. Every instance can run a pool against the same database: each
+// job is claimed by one worker at a time.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/shopping-list/backend/models"
+	"github.com/shopping-list/backend/repository"
+)
+
+// Handler runs a job. Returning an error retries the job with exponential
+// backoff until it runs out of attempts and is dead-lettered.
+type Handler func(ctx context.Context, job models.Job) error
+
+const (
+	// Timeout bounds a single run of a job. Jobs claimed more than twice
+	// as long ago belong to a worker that died and are handed back.
+	Timeout = 5 * time.Minute
+
+	pollInterval    = time.Second
+	sweepInterval   = time.Minute
+	doneRetention   = 7 * 24 * time.Hour
+	baseRetryDelay  = 10 * time.Second
+	maxRetryDelay   = time.Hour
+	defaultPoolSize = 4
+	maxErrorLength  = 2000
+)
+
+// Pool claims due jobs and runs them on a fixed number of workers
+type Pool struct {
+	jobs        repository.JobRepository
+	concurrency int
+	handlers    map[string]Handler
+	periodic    []periodic
+	wake        chan struct{}
+}
+
+// periodic is a kind of job the pool enqueues once every interval
+type periodic struct {
+	kind     string
+	interval time.Duration
+}
+
+// intervalsStart is the midnight UTC the intervals of periodic jobs are
+// counted from
+var intervalsStart = time.Unix(0, 0).UTC()
+
+// NewPool creates a pool of concurrency workers, or a default number when
+// it is not positive
+func NewPool(jobs repository.JobRepository, concurrency int) *Pool {
+	if concurrency <= 0 {
+		concurrency = defaultPoolSize
+	}
+	return &Pool{
+		jobs:        jobs,
+		concurrency: concurrency,
+		handlers:    map[string]Handler{},
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Handle registers the handler for a kind of job. Register every handler
+// before calling Run.
+func (p *Pool) Handle(kind string, h Handler) {
+	p.handlers[kind] = h
+}
+
+// Every registers the handler for a kind of job that runs once every
+// interval, counted from midnight UTC on 1 January 1970: intervals that
+// divide a day start at midnight every day, others such as 7h do not.
+// Every instance enqueues the job of the current interval as it sweeps
+// and the first one to do so wins, so the job runs once however many
+// instances there are.
+func (p *Pool) Every(kind string, interval time.Duration, h Handler) {
+	p.Handle(kind, h)
+	p.periodic = append(p.periodic, periodic{kind: kind, interval: interval})
+}
+
+// Enqueue adds a job that runs at runAt, or right away when it is zero,
+// and wakes an idle worker of this pool. The payload is encoded as JSON.
+func (p *Pool) Enqueue(kind string, payload interface{}, runAt time.Time) (models.Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return models.Job{}, err
+	}
+	job := models.Job{Kind: kind, Payload: data, RunAt: runAt}
+	return job, p.enqueue(&job)
+}
+
+func (p *Pool) enqueue(job *models.Job) error {
+	if err := p.jobs.Enqueue(job); err != nil {
+		return err
+	}
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run starts the workers and blocks until ctx is done and the jobs they
+// are running have finished. Alongside them it hands back jobs abandoned
+// by dead workers and purges finished jobs.
+func (p *Pool) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < p.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+	p.sweep(ctx)
+	wg.Wait()
+}
+
+func (p *Pool) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, err := p.jobs.Claim(time.Now())
+		if err == nil {
+			p.run(ctx, job)
+			continue
+		}
+		if !errors.Is(err, repository.ErrNotFound) {
+			log.Printf("Failed to claim job: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-p.wake:
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// run runs a claimed job and records the outcome
+func (p *Pool) run(ctx context.Context, job models.Job) {
+	err := p.call(ctx, job)
+	if err == nil {
+		if err := p.jobs.Complete(job.ID); err != nil {
+			log.Printf("Failed to complete job %d: %v", job.ID, err)
+		}
+		return
+	}
+
+	reason := err.Error()
+	if len(reason) > maxErrorLength {
+		reason = reason[:maxErrorLength]
+	}
+	failed, ferr := p.jobs.Fail(job.ID, reason, time.Now().Add(retryDelay(job.Attempts)))
+	switch {
+	case ferr != nil:
+		log.Printf("Failed to record failure of job %d: %v (job error: %v)", job.ID, ferr, err)
+	case failed.Status == models.JobDead:
+		log.Printf("Job %d (%s) dead-lettered after %d attempts: %v", job.ID, job.Kind, failed.Attempts, err)
+	default:
+		log.Printf("Job %d (%s) failed, retrying at %s: %v", job.ID, job.Kind, failed.RunAt.Format(time.RFC3339), err)
+	}
+}
+
+// call runs the handler of a job within Timeout, turning a panic into an
+// error so one bad job cannot take the worker down
+func (p *Pool) call(ctx context.Context, job models.Job) (err error) {
+	handler, ok := p.handlers[job.Kind]
+	if !ok {
+		return fmt.Errorf("no handler for job kind %q", job.Kind)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+
+	err = handler(ctx, job)
+	if err == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("job timed out after %s", Timeout)
+	}
+	return err
+}
+
+// sweep enqueues periodic jobs, hands back abandoned jobs and purges
+// finished ones, at startup and then every sweepInterval until ctx is done
+func (p *Pool) sweep(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		p.schedule(time.Now())
+		n, err := p.jobs.Requeue(time.Now().Add(-2 * Timeout))
+		if err != nil {
+			log.Printf("Failed to requeue abandoned jobs: %v", err)
+		} else if n > 0 {
+			log.Printf("Requeued %d abandoned jobs", n)
+		}
+		if _, err := p.jobs.PurgeDone(time.Now().Add(-doneRetention)); err != nil {
+			log.Printf("Failed to purge finished jobs: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// schedule enqueues the periodic jobs of the interval now falls in, unless
+// this or another instance already has. The job's unique key names its
+// kind and interval.
+func (p *Pool) schedule(now time.Time) {
+	for _, job := range p.periodic {
+		runAt := intervalsStart.Add(now.Sub(intervalsStart).Truncate(job.interval))
+		key := job.kind + "@" + runAt.Format(time.RFC3339)
+		err := p.enqueue(&models.Job{Kind: job.kind, UniqueKey: &key, RunAt: runAt})
+		if err != nil && !errors.Is(err, repository.ErrJobExists) {
+			log.Printf("Failed to schedule %s job: %v", job.kind, err)
+		}
+	}
+}
+
+// retryDelay is how long to wait before running a job again after its nth
+// attempt failed: baseRetryDelay doubling with each attempt up to
+// maxRetryDelay, plus up to a fifth more so jobs that failed together do
+// not all retry at once
+func retryDelay(attempts int) time.Duration {
+	delay := maxRetryDelay
+	if attempts < 20 {
+		delay = min(baseRetryDelay<<max(attempts-1, 0), maxRetryDelay)
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay/5)+1))
+}
@@ -0,0 +1,221 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopping-list/backend/models"
+	"github.com/shopping-list/backend/repository"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		base     time.Duration
+	}{
+		{0, baseRetryDelay},
+		{1, baseRetryDelay},
+		{2, 2 * baseRetryDelay},
+		{3, 4 * baseRetryDelay},
+		{6, 32 * baseRetryDelay},
+		{9, 256 * baseRetryDelay},
+		{10, maxRetryDelay},
+		{20, maxRetryDelay},
+		{1000, maxRetryDelay},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 50; i++ {
+			delay := retryDelay(tt.attempts)
+			if delay < tt.base || delay > tt.base+tt.base/5 {
+				t.Fatalf("retryDelay(%d) = %s, want %s plus up to a fifth", tt.attempts, delay, tt.base)
+			}
+		}
+	}
+}
+
+// runNext claims the next due job and runs it on the pool
+func runNext(t *testing.T, p *Pool, jobs repository.JobRepository) models.Job {
+	t.Helper()
+	job, err := jobs.Claim(time.Now().Add(2 * maxRetryDelay))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.run(context.Background(), job)
+	job, err = jobs.Get(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestPoolRun(t *testing.T) {
+	jobs := repository.NewMemory().Jobs()
+	p := NewPool(jobs, 1)
+
+	var got []string
+	p.Handle("greet", func(ctx context.Context, job models.Job) error {
+		got = append(got, string(job.Payload))
+		return nil
+	})
+	if _, err := p.Enqueue("greet", map[string]string{"name": "Alice"}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	job := runNext(t, p, jobs)
+	if job.Status != models.JobDone {
+		t.Errorf("job is %s, want done", job.Status)
+	}
+	if len(got) != 1 || got[0] != `{"name":"Alice"}` {
+		t.Errorf("handler got %v", got)
+	}
+}
+
+func TestPoolFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler Handler
+		err     string
+	}{
+		{"error", func(ctx context.Context, job models.Job) error { return errors.New("mail server down") }, "mail server down"},
+		{"panic", func(ctx context.Context, job models.Job) error { panic("nil map") }, "handler panicked: nil map"},
+		{"no handler", nil, `no handler for job kind "work"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs := repository.NewMemory().Jobs()
+			p := NewPool(jobs, 1)
+			if tt.handler != nil {
+				p.Handle("work", tt.handler)
+			}
+			if err := jobs.Enqueue(&models.Job{Kind: "work", MaxAttempts: 2}); err != nil {
+				t.Fatal(err)
+			}
+
+			// The first failure schedules a retry with backoff
+			before := time.Now()
+			job := runNext(t, p, jobs)
+			if job.Status != models.JobPending || job.LastError == nil || *job.LastError != tt.err {
+				t.Fatalf("after one failure job = %+v, want pending with %q", job, tt.err)
+			}
+			if wait := job.RunAt.Sub(before); wait < baseRetryDelay || wait > 2*baseRetryDelay {
+				t.Errorf("retry in %s, want about %s", wait, baseRetryDelay)
+			}
+
+			// The last one dead-letters it
+			if job := runNext(t, p, jobs); job.Status != models.JobDead || job.Attempts != 2 {
+				t.Fatalf("after two failures job = %+v, want dead", job)
+			}
+		})
+	}
+}
+
+func TestPoolSchedulesPeriodicJobsOnce(t *testing.T) {
+	jobs := repository.NewMemory().Jobs()
+	noop := func(ctx context.Context, job models.Job) error { return nil }
+
+	// Two instances sweeping in the same hour enqueue one job between them
+	now := time.Date(2026, 3, 14, 9, 26, 53, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		p := NewPool(jobs, 1)
+		p.Every("purge", time.Hour, noop)
+		p.schedule(now)
+		p.schedule(now.Add(time.Minute))
+	}
+
+	scheduled, _, err := jobs.List(repository.JobQuery{Kind: "purge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scheduled) != 1 {
+		t.Fatalf("scheduled %d jobs, want 1", len(scheduled))
+	}
+	job := scheduled[0]
+	if want := time.Date(2026, 3, 14, 9, 0, 0, 0, time.UTC); !job.RunAt.Equal(want) || *job.UniqueKey != "purge@2026-03-14T09:00:00Z" {
+		t.Errorf("scheduled %s at %s, want %s", *job.UniqueKey, job.RunAt, want)
+	}
+
+	// The next hour gets a job of its own
+	p := NewPool(jobs, 1)
+	p.Every("purge", time.Hour, noop)
+	p.schedule(now.Add(time.Hour))
+	if scheduled, _, _ := jobs.List(repository.JobQuery{Kind: "purge"}); len(scheduled) != 2 {
+		t.Errorf("scheduled %d jobs over two hours, want 2", len(scheduled))
+	}
+}
+
+func TestPoolSchedulesIntervalsFromMidnight(t *testing.T) {
+	jobs := repository.NewMemory().Jobs()
+	noop := func(ctx context.Context, job models.Job) error { return nil }
+	p := NewPool(jobs, 1)
+	p.Every("refresh", 7*time.Hour, noop)
+
+	// 2026-03-14 is 20526 days after the epoch, which make 70374 intervals
+	// and 6h, so the day's intervals start at 1am, 8am, 3pm and 10pm and
+	// the one before at 6pm the day before
+	for _, now := range []time.Time{
+		time.Date(2026, 3, 14, 0, 30, 0, 0, time.UTC),
+		time.Date(2026, 3, 14, 1, 0, 0, 0, time.UTC),
+		time.Date(2026, 3, 14, 7, 59, 0, 0, time.UTC),
+		time.Date(2026, 3, 14, 22, 15, 0, 0, time.UTC),
+		time.Date(2026, 3, 15, 4, 59, 0, 0, time.UTC),
+	} {
+		p.schedule(now)
+	}
+
+	scheduled, _, err := jobs.List(repository.JobQuery{Kind: "refresh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[time.Time]bool{
+		time.Date(2026, 3, 13, 18, 0, 0, 0, time.UTC): true,
+		time.Date(2026, 3, 14, 1, 0, 0, 0, time.UTC):  true,
+		time.Date(2026, 3, 14, 22, 0, 0, 0, time.UTC): true,
+	}
+	if len(scheduled) != len(want) {
+		t.Fatalf("scheduled %d jobs, want %d", len(scheduled), len(want))
+	}
+	for _, job := range scheduled {
+		if !want[job.RunAt.UTC()] {
+			t.Errorf("scheduled a job at %s", job.RunAt)
+		}
+	}
+}
+
+func TestPoolRunStopsWithContext(t *testing.T) {
+	jobs := repository.NewMemory().Jobs()
+	p := NewPool(jobs, 2)
+	ran := make(chan int, 1)
+	p.Handle("work", func(ctx context.Context, job models.Job) error {
+		ran <- job.ID
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	job, err := p.Enqueue("work", nil, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case id := <-ran:
+		if id != job.ID {
+			t.Errorf("ran job %d, want %d", id, job.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not run")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"github.com/shopping-list/backend/auth"
+	"github.com/shopping-list/backend/authz"
 	"github.com/shopping-list/backend/db"
+	"github.com/shopping-list/backend/events"
+	"github.com/shopping-list/backend/jobs"
+	"github.com/shopping-list/backend/repository"
 	"github.com/shopping-list/backend/routes"
 )
 
@@ -30,6 +37,27 @@ func main() {
 	}
 	defer database.Close()
 
+	// backend migrate up|down|status manages the schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(database, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Configure the secret used to sign invite links
+	if secret := os.Getenv("AUTH_SECRET"); secret != "" {
+		auth.SetSecret([]byte(secret))
+	} else {
+		log.Println("AUTH_SECRET not set, invite links will not survive a restart")
+		auth.SetSecret(auth.RandomSecret())
+	}
+
+	// Users allowed to use the admin endpoints
+	if emails := os.Getenv("ADMIN_EMAILS"); emails != "" {
+		authz.SetAdmins(strings.Split(emails, ","))
+	}
+
 	// Run migrations
 	if err := db.RunMigrations(database); err != nil {
 		log.Fatalf("Failed to run migrations: %v", err)
@@ -38,8 +66,31 @@ func main() {
 	// Initialize Gin router
 	router := gin.Default()
 
-	// Setup routes
-	routes.SetupRoutes(router, database)
+	// Setup routes; the hub pushes list changes to live subscribers on
+	// every instance via Postgres LISTEN/NOTIFY (in-process on SQLite)
+	hub := events.NewHub(database)
+	go func() {
+		if err := hub.Listen(context.Background(), dbURL); err != nil {
+			log.Fatalf("Event listener stopped: %v", err)
+		}
+	}()
+	routes.SetupRoutes(router, database, hub)
+
+	// Run background jobs: purging the trash and adding recurring templates
+	// and items to their lists as they come due
+	retention, err := trashRetention()
+	if err != nil {
+		log.Fatal(err)
+	}
+	workers, err := jobWorkers()
+	if err != nil {
+		log.Fatal(err)
+	}
+	repos := repository.NewSQL(database)
+	pool := jobs.NewPool(repos.Jobs(), workers)
+	pool.Every(purgeTrashJob, trashPurgeInterval, purgeTrash(repos.Trash(), retention))
+	pool.Every(runRecurrencesJob, recurrenceInterval, runRecurrences(repos.Recurrences(), hub))
+	go pool.Run(context.Background())
 
 	// Start server
 	port := os.Getenv("PORT")
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/shopping-list/backend/db"
+)
+
+const migrateUsage = "usage: backend migrate up|down [steps]|status"
+
+// runMigrate implements the migrate subcommand
+func runMigrate(database *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		if err := db.RunMigrations(database); err != nil {
+			return err
+		}
+		fmt.Println("Database is up to date")
+		return nil
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return errors.New(migrateUsage)
+			}
+			steps = n
+		}
+		reverted, err := db.MigrateDown(database, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Reverted %d migration(s)\n", reverted)
+		return nil
+
+	case "status":
+		states, err := db.MigrationStatus(database)
+		if err != nil {
+			return err
+		}
+		for _, s := range states {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%4d  %-32s %s\n", s.Version, s.Name, applied)
+		}
+		return nil
+
+	default:
+		return errors.New(migrateUsage)
+	}
+}
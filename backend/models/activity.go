@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// Activity actions
+const (
+	ActivityItemCreated  = "item_created"
+	ActivityItemUpdated  = "item_updated"
+	ActivityItemDeleted  = "item_deleted"
+	ActivityItemRestored = "item_restored"
+	ActivityListRenamed  = "list_renamed"
+	ActivityListDone     = "list_done"
+)
+
+// ActivityFields maps item or list field names to their values
+type ActivityFields map[string]interface{}
+
+// ListActivity is a single change made to a list or one of its items.
+// Before and After hold only the fields that changed: created items have no
+// Before and deleted items no After. A list marked done records the history
+// entry it was saved to in After. UndoneAt is set while the change is
+// undone.
+type ListActivity struct {
+	ID        int            `json:"id"`
+	ListID    int            `json:"list_id"`
+	ItemID    *int           `json:"item_id"`
+	UserID    int            `json:"user_id"`
+	Action    string         `json:"action"`
+	Before    ActivityFields `json:"before"`
+	After     ActivityFields `json:"after"`
+	CreatedAt time.Time      `json:"created_at"`
+	UndoneAt  *time.Time     `json:"undone_at,omitempty"`
+	UndoneBy  *int           `json:"undone_by,omitempty"`
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// History actions
+const (
+	// ActionCreated records a list that was marked done
+	ActionCreated        = "created"
+	ActionReused         = "reused"
+	ActionInviteAccepted = "invite_accepted"
+)
+
+// ErrInvalidHistory is returned for history data that does not match its
+// action's payload
+var ErrInvalidHistory = errors.New("invalid history payload")
+
+// HistoryPayload is the typed data of a history entry
+type HistoryPayload interface {
+	Action() string
+	Validate() error
+}
+
+// ListSnapshot is the payload of a "created" entry: the list as it was
+// when marked done. CarriedOverTo is the list its unpurchased items moved
+// on to, if any.
+type ListSnapshot struct {
+	Name          string         `json:"name"`
+	Items         []SnapshotItem `json:"items"`
+	CarriedOverTo *int           `json:"carried_over_to,omitempty"`
+}
+
+// SnapshotItem is an item in a ListSnapshot. CarriedOver marks items that
+// were not bought and moved on to another list.
+type SnapshotItem struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Quantity    float64 `json:"quantity"`
+	Unit        string  `json:"unit"`
+	Purchased   bool    `json:"purchased"`
+	CarriedOver bool    `json:"carried_over,omitempty"`
+}
+
+// ListReuse is the payload of a "reused" entry. Existing is set when the
+// items went into a list that was already there rather than a new one.
+type ListReuse struct {
+	OriginalHistoryID int  `json:"original_history_id"`
+	NewListID         int  `json:"new_list_id"`
+	Existing          bool `json:"existing,omitempty"`
+}
+
+// InviteAcceptance is the payload of an "invite_accepted" entry
+type InviteAcceptance struct {
+	InviteID int    `json:"invite_id"`
+	UserID   int    `json:"user_id"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+}
+
+func (ListSnapshot) Action() string     { return ActionCreated }
+func (ListReuse) Action() string        { return ActionReused }
+func (InviteAcceptance) Action() string { return ActionInviteAccepted }
+
+// Validate checks that the snapshot can be reused
+func (s ListSnapshot) Validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("%w: list name is required", ErrInvalidHistory)
+	}
+	for _, item := range s.Items {
+		if item.Name == "" {
+			return fmt.Errorf("%w: item name is required", ErrInvalidHistory)
+		}
+		if item.Quantity < 0 {
+			return fmt.Errorf("%w: item quantity cannot be negative", ErrInvalidHistory)
+		}
+	}
+	return nil
+}
+
+// Validate checks that both ids are set
+func (r ListReuse) Validate() error {
+	if r.OriginalHistoryID <= 0 || r.NewListID <= 0 {
+		return fmt.Errorf("%w: history and list ids are required", ErrInvalidHistory)
+	}
+	return nil
+}
+
+// Validate checks that the invite and the accepting user are set
+func (a InviteAcceptance) Validate() error {
+	if a.InviteID <= 0 || a.UserID <= 0 || a.Email == "" || a.Role == "" {
+		return fmt.Errorf("%w: invite, user and role are required", ErrInvalidHistory)
+	}
+	return nil
+}
+
+// EncodeHistory validates a payload and serializes it for storage
+func EncodeHistory(p HistoryPayload) (json.RawMessage, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(p)
+}
+
+// DecodeHistory parses history data into a pointer to the payload type
+// of its action
+func DecodeHistory(action string, data json.RawMessage) (HistoryPayload, error) {
+	var p HistoryPayload
+	switch action {
+	case ActionCreated:
+		p = &ListSnapshot{}
+	case ActionReused:
+		p = &ListReuse{}
+	case ActionInviteAccepted:
+		p = &InviteAcceptance{}
+	default:
+		return nil, fmt.Errorf("%w: unknown action %q", ErrInvalidHistory, action)
+	}
+
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidHistory, err)
+	}
+	return p, p.Validate()
+}
+
+// Payload returns the typed data of a history entry
+func (h ListHistory) Payload() (HistoryPayload, error) {
+	return DecodeHistory(h.Action, h.Data)
+}
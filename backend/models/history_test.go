@@ -0,0 +1,72 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestListSnapshotValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		snapshot ListSnapshot
+		valid    bool
+	}{
+		{"empty list", ListSnapshot{Name: "Groceries"}, true},
+		{"items", ListSnapshot{Name: "Groceries", Items: []SnapshotItem{{ID: 1, Name: "Milk", Quantity: 2}}}, true},
+		{"no name", ListSnapshot{Items: []SnapshotItem{{ID: 1, Name: "Milk", Quantity: 2}}}, false},
+		{"unnamed item", ListSnapshot{Name: "Groceries", Items: []SnapshotItem{{ID: 1, Quantity: 2}}}, false},
+		{"negative quantity", ListSnapshot{Name: "Groceries", Items: []SnapshotItem{{ID: 1, Name: "Milk", Quantity: -1}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.snapshot.Validate()
+			if tt.valid && err != nil {
+				t.Errorf("Validate = %v, want nil", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidHistory) {
+				t.Errorf("Validate = %v, want ErrInvalidHistory", err)
+			}
+			if _, encErr := EncodeHistory(tt.snapshot); (encErr == nil) != tt.valid {
+				t.Errorf("EncodeHistory = %v, want it to validate like Validate", encErr)
+			}
+		})
+	}
+}
+
+func TestListSnapshotRoundTrip(t *testing.T) {
+	carriedOverTo := 7
+	snapshot := ListSnapshot{
+		Name: "Groceries",
+		Items: []SnapshotItem{
+			{ID: 1, Name: "Milk", Quantity: 2, Unit: "l", Purchased: true},
+			{ID: 2, Name: "Bread", Quantity: 1, CarriedOver: true},
+		},
+		CarriedOverTo: &carriedOverTo,
+	}
+	data, err := EncodeHistory(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := ListHistory{Action: ActionCreated, Data: data}.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, ok := payload.(*ListSnapshot)
+	if !ok {
+		t.Fatalf("Payload = %T, want *ListSnapshot", payload)
+	}
+	if got.Name != snapshot.Name || got.CarriedOverTo == nil || *got.CarriedOverTo != carriedOverTo || len(got.Items) != len(snapshot.Items) {
+		t.Fatalf("decoded %+v, want %+v", got, snapshot)
+	}
+	for i, item := range got.Items {
+		if item != snapshot.Items[i] {
+			t.Errorf("item %d = %+v, want %+v", i, item, snapshot.Items[i])
+		}
+	}
+
+	// Stored data that does not validate is rejected on the way out too
+	if _, err := DecodeHistory(ActionCreated, []byte(`{"name":"","items":[]}`)); !errors.Is(err, ErrInvalidHistory) {
+		t.Errorf("DecodeHistory of an unnamed snapshot = %v, want ErrInvalidHistory", err)
+	}
+}
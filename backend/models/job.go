@@ -0,0 +1,36 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Job statuses. Failed jobs go back to pending until they run out of
+// attempts and become dead letters.
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobDead    = "dead"
+)
+
+// DefaultJobAttempts is how many times a job runs before it is dead-lettered
+const DefaultJobAttempts = 5
+
+// Job is a unit of background work. Kind picks the handler that runs it and
+// Payload is handed to that handler as-is. A job with a UniqueKey is only
+// ever enqueued once.
+type Job struct {
+	ID          int             `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	UniqueKey   *string         `json:"unique_key"`
+	Status      string          `json:"status"` // one of the Job constants
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LockedAt    *time.Time      `json:"locked_at"`
+	LastError   *string         `json:"last_error"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
@@ -1,42 +1,120 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // User represents a user in the system
 type User struct {
-	ID        int       `json:"id"`
-	Email     string    `json:"email"`
-	Password  string    `json:"-"`
-	CreatedAt time.Time `json:"created_at"`
+	ID                int       `json:"id"`
+	Email             string    `json:"email"`
+	Password          string    `json:"-"`
+	ActiveHouseholdID *int      `json:"active_household_id"`
+	CreatedAt         time.Time `json:"created_at"`
+}
+
+// Household represents a group of users sharing lists and history
+type Household struct {
+	ID        int               `json:"id"`
+	Name      string            `json:"name"`
+	CreatedBy int               `json:"created_by"`
+	Members   []HouseholdMember `json:"members,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
 }
 
+// HouseholdMember represents a user belonging to a household
+type HouseholdMember struct {
+	HouseholdID int       `json:"household_id"`
+	UserID      int       `json:"user_id"`
+	Email       string    `json:"email"`
+	Role        string    `json:"role"` // "owner", "member"
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// List kinds. Templates are kept to be copied into new lists and never
+// show up among the active lists.
+const (
+	ListKindList     = "list"
+	ListKindTemplate = "template"
+)
+
 // ShoppingList represents a shopping list
 type ShoppingList struct {
-	ID        int          `json:"id"`
-	UserID    int          `json:"user_id"`
-	Name      string       `json:"name"`
-	Items     []ShoppingItem `json:"items"`
-	CreatedAt time.Time    `json:"created_at"`
-	UpdatedAt time.Time    `json:"updated_at"`
+	ID          int            `json:"id"`
+	UserID      int            `json:"user_id"`
+	HouseholdID *int           `json:"household_id"`
+	Name        string         `json:"name"`
+	Kind        string         `json:"kind"`
+	Items       []ShoppingItem `json:"items"`
+	Version     int            `json:"version"`
+	ChangeSeq   int64          `json:"change_seq,omitempty"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   *time.Time     `json:"deleted_at,omitempty"`
+}
+
+// ListMember represents a user the list has been shared with
+type ListMember struct {
+	ListID    int       `json:"list_id"`
+	UserID    int       `json:"user_id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"` // "owner", "editor", "viewer"
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Invite represents an invitation link granting a role on a list or household
+type Invite struct {
+	ID          int        `json:"id"`
+	ListID      *int       `json:"list_id"`
+	HouseholdID *int       `json:"household_id"`
+	Role        string     `json:"role"`
+	Token       string     `json:"token,omitempty"`
+	CreatedBy   int        `json:"created_by"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	AcceptedBy  *int       `json:"accepted_by"`
+	AcceptedAt  *time.Time `json:"accepted_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+	CreatedAt   time.Time  `json:"created_at"`
 }
 
 // ShoppingItem represents an item in a shopping list
 type ShoppingItem struct {
+	ID        int        `json:"id"`
+	ListID    int        `json:"list_id"`
+	Name      string     `json:"name"`
+	Quantity  float64    `json:"quantity"`
+	Unit      string     `json:"unit"`
+	Purchased bool       `json:"purchased"`
+	Version   int        `json:"version"`
+	ChangeSeq int64      `json:"change_seq,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// Trash holds the deleted lists and items a user can restore. Items of a
+// deleted list are restored with it and are not listed separately.
+type Trash struct {
+	Lists []ShoppingList `json:"lists"`
+	Items []ShoppingItem `json:"items"`
+}
+
+// Tombstone records a deleted list or item for delta sync
+type Tombstone struct {
+	Type      string    `json:"type"` // "list", "item"
 	ID        int       `json:"id"`
 	ListID    int       `json:"list_id"`
-	Name      string    `json:"name"`
-	Quantity  float64   `json:"quantity"`
-	Unit      string    `json:"unit"`
-	Purchased bool      `json:"purchased"`
-	CreatedAt time.Time `json:"created_at"`
+	ChangeSeq int64     `json:"change_seq"`
+	DeletedAt time.Time `json:"deleted_at"`
 }
 
 // ListHistory represents the history of a shopping list action
 type ListHistory struct {
-	ID             int       `json:"id"`
-	UserID         int       `json:"user_id"`
-	OriginalListID *int      `json:"original_list_id"`
-	Action         string    `json:"action"` // "created", "updated", "reused"
-	Data           string    `json:"data"`   // JSON data of the action
-	CreatedAt      time.Time `json:"created_at"`
+	ID             int             `json:"id"`
+	UserID         int             `json:"user_id"`
+	HouseholdID    *int            `json:"household_id"`
+	OriginalListID *int            `json:"original_list_id"`
+	Action         string          `json:"action"` // one of the Action constants
+	Data           json.RawMessage `json:"data"`   // payload of the action, see Payload
+	CreatedAt      time.Time       `json:"created_at"`
 }
@@ -0,0 +1,181 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recurrence frequencies
+const (
+	FreqDaily   = "DAILY"
+	FreqWeekly  = "WEEKLY"
+	FreqMonthly = "MONTHLY"
+)
+
+// ErrInvalidRule is returned for recurrence rules that cannot be parsed
+var ErrInvalidRule = errors.New("invalid recurrence rule")
+
+// Recurrence puts the items of a template, or a single item, back on a list
+// on a schedule. ListID is the active list they go to; without one an
+// item goes back to its own list and a template becomes a new list each
+// time.
+type Recurrence struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	TemplateID *int       `json:"template_id,omitempty"`
+	ItemID     *int       `json:"item_id,omitempty"`
+	ListID     *int       `json:"list_id"`
+	Rule       string     `json:"rule"`
+	StartsAt   time.Time  `json:"starts_at"`
+	NextRunAt  time.Time  `json:"next_run_at"`
+	LastRunAt  *time.Time `json:"last_run_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Rule is a parsed recurrence rule. It takes a subset of the iCalendar
+// RRULE syntax: FREQ=DAILY, WEEKLY or MONTHLY with an optional INTERVAL,
+// BYDAY (weekly, e.g. MO,TH) and BYMONTHDAY (monthly, 1 to 31). Weekly and
+// monthly rules fall on the weekday or day of the month they start on
+// unless told otherwise; days past the end of a month fall on its last
+// day.
+type Rule struct {
+	Freq     string
+	Interval int
+	ByDay    []time.Weekday
+	MonthDay int
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// ParseRule parses a rule such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO"
+func ParseRule(s string) (Rule, error) {
+	r := Rule{Interval: 1}
+	for _, part := range strings.Split(strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "RRULE:"), ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return r, fmt.Errorf("%w: %q is not KEY=VALUE", ErrInvalidRule, part)
+		}
+		switch key {
+		case "FREQ":
+			if value != FreqDaily && value != FreqWeekly && value != FreqMonthly {
+				return r, fmt.Errorf("%w: FREQ must be DAILY, WEEKLY or MONTHLY", ErrInvalidRule)
+			}
+			r.Freq = value
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > 366 {
+				return r, fmt.Errorf("%w: INTERVAL must be between 1 and 366", ErrInvalidRule)
+			}
+			r.Interval = n
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				wd, ok := weekdays[day]
+				if !ok {
+					return r, fmt.Errorf("%w: unknown day %q", ErrInvalidRule, day)
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > 31 {
+				return r, fmt.Errorf("%w: BYMONTHDAY must be between 1 and 31", ErrInvalidRule)
+			}
+			r.MonthDay = n
+		default:
+			return r, fmt.Errorf("%w: unsupported part %q", ErrInvalidRule, key)
+		}
+	}
+
+	if r.Freq == "" {
+		return r, fmt.Errorf("%w: FREQ is required", ErrInvalidRule)
+	}
+	if r.ByDay != nil && r.Freq != FreqWeekly {
+		return r, fmt.Errorf("%w: BYDAY only applies to WEEKLY rules", ErrInvalidRule)
+	}
+	if r.MonthDay != 0 && r.Freq != FreqMonthly {
+		return r, fmt.Errorf("%w: BYMONTHDAY only applies to MONTHLY rules", ErrInvalidRule)
+	}
+	return r, nil
+}
+
+// Next returns the first occurrence of a rule that started at start which
+// falls strictly after t. Occurrences keep the time of day of start.
+func (r Rule) Next(start, t time.Time) time.Time {
+	if t.Before(start) {
+		t = start.Add(-time.Nanosecond)
+	}
+
+	switch r.Freq {
+	case FreqDaily:
+		days := int(t.Sub(start).Hours()/24) / r.Interval * r.Interval
+		for {
+			if next := start.AddDate(0, 0, days); next.After(t) {
+				return next
+			}
+			days += r.Interval
+		}
+
+	case FreqWeekly:
+		byDay := r.ByDay
+		if byDay == nil {
+			byDay = []time.Weekday{start.Weekday()}
+		}
+		// Weeks start on Monday, counted from the week start falls in
+		firstWeek := start.AddDate(0, 0, -mondayOffset(start))
+		day := int(t.Sub(start).Hours() / 24)
+		for {
+			next := start.AddDate(0, 0, day)
+			weeks := int(next.AddDate(0, 0, -mondayOffset(next)).Sub(firstWeek).Hours()/24+0.5) / 7
+			if next.After(t) && weeks%r.Interval == 0 && hasWeekday(byDay, next.Weekday()) {
+				return next
+			}
+			day++
+		}
+
+	default:
+		monthDay := r.MonthDay
+		if monthDay == 0 {
+			monthDay = start.Day()
+		}
+		months := ((t.Year()-start.Year())*12 + int(t.Month()-start.Month()) - 1) / r.Interval * r.Interval
+		if months < 0 {
+			months = 0
+		}
+		for {
+			next := onMonthDay(start, months, monthDay)
+			if next.After(t) && !next.Before(start) {
+				return next
+			}
+			months += r.Interval
+		}
+	}
+}
+
+func mondayOffset(t time.Time) int {
+	return (int(t.Weekday()) + 6) % 7
+}
+
+func hasWeekday(days []time.Weekday, day time.Weekday) bool {
+	for _, d := range days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// onMonthDay returns start moved the given number of months on and onto
+// day, or the last day of that month if it is shorter
+func onMonthDay(start time.Time, months, day int) time.Time {
+	first := time.Date(start.Year(), start.Month()+time.Month(months), 1, start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
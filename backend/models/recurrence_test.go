@@ -0,0 +1,92 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		rule  string
+		valid bool
+	}{
+		{"FREQ=DAILY", true},
+		{"RRULE:freq=weekly;interval=2;byday=mo,th", true},
+		{"FREQ=MONTHLY;BYMONTHDAY=31", true},
+		{"", false},
+		{"INTERVAL=2", false},
+		{"FREQ=YEARLY", false},
+		{"FREQ=DAILY;INTERVAL=0", false},
+		{"FREQ=WEEKLY;BYDAY=XX", false},
+		{"FREQ=DAILY;BYDAY=MO", false},
+		{"FREQ=WEEKLY;BYMONTHDAY=1", false},
+		{"FREQ=MONTHLY;BYMONTHDAY=32", false},
+		{"FREQ=DAILY;COUNT=3", false},
+	}
+	for _, tt := range tests {
+		_, err := ParseRule(tt.rule)
+		if tt.valid && err != nil {
+			t.Errorf("ParseRule(%q) = %v, want nil", tt.rule, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("ParseRule(%q) = %v, want ErrInvalidRule", tt.rule, err)
+		}
+	}
+}
+
+func TestRuleNext(t *testing.T) {
+	at := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2026, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	// March 2026 starts on a Sunday: the 2nd, 9th, 16th and so on are
+	// Mondays
+	tests := []struct {
+		name  string
+		rule  string
+		start time.Time
+		after time.Time
+		want  time.Time
+	}{
+		{"daily before the start", "FREQ=DAILY", at(3, 1, 9), at(2, 20, 9), at(3, 1, 9)},
+		{"daily at an occurrence", "FREQ=DAILY", at(3, 1, 9), at(3, 1, 9), at(3, 2, 9)},
+		{"every three days", "FREQ=DAILY;INTERVAL=3", at(3, 1, 9), at(3, 5, 12), at(3, 7, 9)},
+		{"weekly on the start weekday", "FREQ=WEEKLY", at(3, 2, 9), at(3, 2, 9), at(3, 9, 9)},
+		{"weekly on several days", "FREQ=WEEKLY;BYDAY=MO,TH", at(3, 2, 9), at(3, 2, 9), at(3, 5, 9)},
+
+		// Starting on a Wednesday, the Monday of the first week is before
+		// the start and the next one is in a week that is skipped
+		{"fortnightly from the start", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE", at(3, 4, 9), at(3, 1, 0), at(3, 4, 9)},
+		{"fortnightly skips the odd week", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE", at(3, 4, 9), at(3, 4, 9), at(3, 16, 9)},
+		{"fortnightly within a week", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE", at(3, 4, 9), at(3, 16, 9), at(3, 18, 9)},
+		{"fortnightly on a day before the start weekday", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO", at(3, 4, 9), at(3, 1, 0), at(3, 16, 9)},
+		{"three weekly on a day before the start weekday", "FREQ=WEEKLY;INTERVAL=3;BYDAY=SU,MO", at(3, 4, 9), at(3, 4, 9), at(3, 8, 9)},
+
+		{"monthly on the start day", "FREQ=MONTHLY", at(1, 15, 9), at(1, 15, 9), at(2, 15, 9)},
+		{"monthly on a day before the start day", "FREQ=MONTHLY;BYMONTHDAY=15", at(1, 20, 9), at(1, 1, 0), at(2, 15, 9)},
+		{"31st in February", "FREQ=MONTHLY;BYMONTHDAY=31", at(1, 31, 9), at(1, 31, 9), at(2, 28, 9)},
+		{"31st after February", "FREQ=MONTHLY;BYMONTHDAY=31", at(1, 31, 9), at(2, 28, 9), at(3, 31, 9)},
+		{"31st in April", "FREQ=MONTHLY;BYMONTHDAY=31", at(1, 31, 9), at(3, 31, 9), at(4, 30, 9)},
+		{"31st in a leap February", "FREQ=MONTHLY;BYMONTHDAY=31", time.Date(2028, 1, 31, 9, 0, 0, 0, time.UTC), time.Date(2028, 1, 31, 9, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 9, 0, 0, 0, time.UTC)},
+		{"start day past the end of the month", "FREQ=MONTHLY", at(1, 31, 9), at(2, 28, 9), at(3, 31, 9)},
+		{"every other month on the 31st", "FREQ=MONTHLY;INTERVAL=2;BYMONTHDAY=31", at(1, 31, 9), at(3, 31, 9), at(5, 31, 9)},
+		{"31st at the turn of the year", "FREQ=MONTHLY;BYMONTHDAY=31", at(11, 30, 9), at(12, 31, 9), time.Date(2027, 1, 31, 9, 0, 0, 0, time.UTC)},
+
+		// After downtime only the next occurrence counts, not the missed
+		// ones
+		{"daily after downtime", "FREQ=DAILY", at(3, 1, 9), at(3, 10, 15), at(3, 11, 9)},
+		{"fortnightly after downtime", "FREQ=WEEKLY;INTERVAL=2", at(3, 2, 9), at(4, 20, 10), at(4, 27, 9)},
+		{"monthly after downtime", "FREQ=MONTHLY;BYMONTHDAY=31", at(1, 31, 9), at(6, 15, 9), at(6, 30, 9)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ParseRule(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rule.Next(tt.start, tt.after); !got.Equal(tt.want) {
+				t.Errorf("Next(%s, %s) = %s, want %s", tt.start.Format(time.DateTime), tt.after.Format(time.DateTime), got.Format(time.DateTime), tt.want.Format(time.DateTime))
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/shopping-list/backend/events"
+	"github.com/shopping-list/backend/jobs"
+	"github.com/shopping-list/backend/models"
+	"github.com/shopping-list/backend/repository"
+)
+
+const (
+	// runRecurrencesJob adds recurring templates and items to their lists
+	// as they come due, every recurrenceInterval
+	runRecurrencesJob  = "run_recurrences"
+	recurrenceInterval = time.Minute
+)
+
+// runRecurrences returns the handler of runRecurrencesJob. Each rule is
+// rescheduled along with the items it adds, so a retry or a run that
+// overlaps another never adds an occurrence twice.
+func runRecurrences(recurrences repository.RecurrenceRepository, hub events.Publisher) jobs.Handler {
+	return func(ctx context.Context, job models.Job) error {
+		runs, err := recurrences.RunDue(time.Now())
+		for _, run := range runs {
+			for _, item := range run.Added.Created {
+				hub.Publish(run.List.ID, run.Recurrence.UserID, events.ItemCreated, item)
+			}
+			for _, item := range run.Added.Merged {
+				hub.Publish(run.List.ID, run.Recurrence.UserID, events.ItemUpdated, item)
+			}
+		}
+		if len(runs) > 0 {
+			log.Printf("Ran %d recurrences", len(runs))
+		}
+		return err
+	}
+}
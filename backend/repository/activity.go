@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"github.com/shopping-list/backend/models"
+)
+
+// itemFields returns the tracked fields of an item
+func itemFields(item models.ShoppingItem) models.ActivityFields {
+	return models.ActivityFields{
+		FieldName:      item.Name,
+		FieldQuantity:  item.Quantity,
+		FieldUnit:      item.Unit,
+		FieldPurchased: item.Purchased,
+	}
+}
+
+// diffItem returns the fields that differ between two versions of an item,
+// as they were and as they became. Both are nil if nothing changed.
+func diffItem(old, cur models.ShoppingItem) (before, after models.ActivityFields) {
+	oldFields, curFields := itemFields(old), itemFields(cur)
+	for field, value := range curFields {
+		if oldFields[field] == value {
+			continue
+		}
+		if before == nil {
+			before, after = models.ActivityFields{}, models.ActivityFields{}
+		}
+		before[field] = oldFields[field]
+		after[field] = value
+	}
+	return before, after
+}
+
+func itemCreated(item models.ShoppingItem, actorID int) models.ListActivity {
+	return models.ListActivity{ListID: item.ListID, ItemID: &item.ID, UserID: actorID, Action: models.ActivityItemCreated, After: itemFields(item)}
+}
+
+func itemDeleted(item models.ShoppingItem, actorID int) models.ListActivity {
+	return models.ListActivity{ListID: item.ListID, ItemID: &item.ID, UserID: actorID, Action: models.ActivityItemDeleted, Before: itemFields(item)}
+}
+
+func itemRestored(item models.ShoppingItem, actorID int) models.ListActivity {
+	return models.ListActivity{ListID: item.ListID, ItemID: &item.ID, UserID: actorID, Action: models.ActivityItemRestored, After: itemFields(item)}
+}
+
+// itemUpdated returns the activity of an item update, or false if no field
+// changed
+func itemUpdated(old, cur models.ShoppingItem, actorID int) (models.ListActivity, bool) {
+	before, after := diffItem(old, cur)
+	if before == nil {
+		return models.ListActivity{}, false
+	}
+	return models.ListActivity{ListID: cur.ListID, ItemID: &cur.ID, UserID: actorID, Action: models.ActivityItemUpdated, Before: before, After: after}, true
+}
+
+// listRenamed returns the activity of a list rename, or false if the name
+// did not change
+func listRenamed(listID int, oldName, newName string, actorID int) (models.ListActivity, bool) {
+	if oldName == newName {
+		return models.ListActivity{}, false
+	}
+	return models.ListActivity{
+		ListID: listID,
+		UserID: actorID,
+		Action: models.ActivityListRenamed,
+		Before: models.ActivityFields{"name": oldName},
+		After:  models.ActivityFields{"name": newName},
+	}, true
+}
+
+// listDone returns the activity of a list marked done, which remembers the
+// history entry it was saved to and the list its items were carried over to
+func listDone(listID int, entry models.ListHistory, actorID int) models.ListActivity {
+	after := models.ActivityFields{"history_id": entry.ID}
+	if to, _ := carriedOver(entry); to != nil {
+		after["carried_over_to"] = *to
+	}
+	return models.ListActivity{
+		ListID: listID,
+		UserID: actorID,
+		Action: models.ActivityListDone,
+		After:  after,
+	}
+}
+
+// afterID returns an id recorded in the After fields of an entry, or 0.
+// Entries read back from the database hold JSON numbers.
+func afterID(a models.ListActivity, key string) int {
+	switch id := a.After[key].(type) {
+	case int:
+		return id
+	case float64:
+		return int(id)
+	}
+	return 0
+}
+
+// redoCarryOver returns where redoing a list marked done carries its items
+// over to: the same list as the first time
+func redoCarryOver(a models.ListActivity) *CarryOver {
+	if to := afterID(a, "carried_over_to"); to != 0 {
+		return &CarryOver{ListID: &to}
+	}
+	return nil
+}
+
+// applyFields writes recorded fields onto an item and returns the names of
+// those that changed
+func applyFields(item *models.ShoppingItem, fields models.ActivityFields) []string {
+	var changed []string
+	for field, value := range fields {
+		switch v := value.(type) {
+		case string:
+			if field == FieldName && item.Name != v {
+				item.Name = v
+			} else if field == FieldUnit && item.Unit != v {
+				item.Unit = v
+			} else {
+				continue
+			}
+		case float64:
+			if field != FieldQuantity || item.Quantity == v {
+				continue
+			}
+			item.Quantity = v
+		case bool:
+			if field != FieldPurchased || item.Purchased == v {
+				continue
+			}
+			item.Purchased = v
+		default:
+			continue
+		}
+		changed = append(changed, field)
+	}
+	return changed
+}
+
+// holdsFields reports whether an item still has the recorded fields, so
+// writing other values over them loses no later edit
+func holdsFields(item models.ShoppingItem, fields models.ActivityFields) bool {
+	current := itemFields(item)
+	for field, value := range fields {
+		if current[field] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,269 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopping-list/backend/db/dbtest"
+	"github.com/shopping-list/backend/models"
+)
+
+// eachJobQueue runs test against the job queue on every database backend
+// and in memory
+func eachJobQueue(t *testing.T, test func(t *testing.T, jobs JobRepository)) {
+	dbtest.Run(t, func(t *testing.T, conn *sql.DB) {
+		test(t, NewSQL(conn).Jobs())
+	})
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemory().Jobs())
+	})
+}
+
+func enqueue(t *testing.T, jobs JobRepository, job models.Job) models.Job {
+	t.Helper()
+	if err := jobs.Enqueue(&job); err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func claim(t *testing.T, jobs JobRepository, now time.Time) models.Job {
+	t.Helper()
+	job, err := jobs.Claim(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestJobEnqueue(t *testing.T) {
+	eachJobQueue(t, func(t *testing.T, jobs JobRepository) {
+		job := enqueue(t, jobs, models.Job{Kind: "email"})
+		if job.ID == 0 || job.Status != models.JobPending || job.MaxAttempts != models.DefaultJobAttempts || string(job.Payload) != "{}" {
+			t.Fatalf("Enqueue = %+v", job)
+		}
+
+		key := "digest@2026-01-01"
+		enqueue(t, jobs, models.Job{Kind: "digest", UniqueKey: &key})
+		if err := jobs.Enqueue(&models.Job{Kind: "digest", UniqueKey: &key}); !errors.Is(err, ErrJobExists) {
+			t.Fatalf("Enqueue of a used key = %v, want ErrJobExists", err)
+		}
+		got, _, err := jobs.List(JobQuery{Kind: "digest"})
+		if err != nil || len(got) != 1 || got[0].UniqueKey == nil || *got[0].UniqueKey != key {
+			t.Fatalf("List = %+v, %v; want the one digest job", got, err)
+		}
+	})
+}
+
+func TestJobClaim(t *testing.T) {
+	eachJobQueue(t, func(t *testing.T, jobs JobRepository) {
+		now := time.Now()
+		if _, err := jobs.Claim(now); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Claim of an empty queue = %v, want ErrNotFound", err)
+		}
+
+		later := enqueue(t, jobs, models.Job{Kind: "later", RunAt: now.Add(time.Hour)})
+		second := enqueue(t, jobs, models.Job{Kind: "second", RunAt: now.Add(-time.Minute)})
+		first := enqueue(t, jobs, models.Job{Kind: "first", RunAt: now.Add(-time.Hour)})
+
+		// Due jobs come out oldest first and only once
+		for _, want := range []models.Job{first, second} {
+			job := claim(t, jobs, now)
+			if job.ID != want.ID || job.Status != models.JobRunning || job.Attempts != 1 || job.LockedAt == nil {
+				t.Fatalf("Claim = %+v, want %s running", job, want.Kind)
+			}
+		}
+		if _, err := jobs.Claim(now); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Claim with nothing due = %v, want ErrNotFound", err)
+		}
+		if job := claim(t, jobs, now.Add(2*time.Hour)); job.ID != later.ID {
+			t.Fatalf("Claim once due = %s, want later", job.Kind)
+		}
+
+		if err := jobs.Complete(first.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := jobs.Complete(first.ID); !errors.Is(err, ErrJobState) {
+			t.Fatalf("Complete twice = %v, want ErrJobState", err)
+		}
+		if err := jobs.Complete(first.ID + 1000); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Complete of a missing job = %v, want ErrNotFound", err)
+		}
+		if job, err := jobs.Get(first.ID); err != nil || job.Status != models.JobDone || job.LockedAt != nil {
+			t.Fatalf("completed job = %+v, %v", job, err)
+		}
+	})
+}
+
+func TestJobFailure(t *testing.T) {
+	eachJobQueue(t, func(t *testing.T, jobs JobRepository) {
+		job := enqueue(t, jobs, models.Job{Kind: "flaky", MaxAttempts: 2})
+		now := time.Now()
+
+		claim(t, jobs, now)
+		retryAt := now.Add(time.Minute)
+		failed, err := jobs.Fail(job.ID, "timeout", retryAt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if failed.Status != models.JobPending || failed.LastError == nil || *failed.LastError != "timeout" || failed.LockedAt != nil {
+			t.Fatalf("Fail = %+v, want pending with the error", failed)
+		}
+		if failed.RunAt.Sub(retryAt).Abs() > time.Millisecond {
+			t.Errorf("retry at %s, want %s", failed.RunAt, retryAt.UTC())
+		}
+		if _, err := jobs.Claim(now); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Claim before the retry is due = %v, want ErrNotFound", err)
+		}
+
+		// The last attempt dead-letters the job
+		claim(t, jobs, retryAt)
+		dead, err := jobs.Fail(job.ID, "timeout again", retryAt.Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dead.Status != models.JobDead || dead.Attempts != 2 {
+			t.Fatalf("Fail of the last attempt = %+v, want dead", dead)
+		}
+		if _, err := jobs.Claim(retryAt.Add(time.Hour)); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Claim of a dead job = %v, want ErrNotFound", err)
+		}
+		if _, err := jobs.Fail(job.ID, "again", now); !errors.Is(err, ErrJobState) {
+			t.Fatalf("Fail of a dead job = %v, want ErrJobState", err)
+		}
+		letters, _, err := jobs.List(JobQuery{Status: models.JobDead})
+		if err != nil || len(letters) != 1 || letters[0].ID != job.ID {
+			t.Fatalf("dead letters = %+v, %v", letters, err)
+		}
+
+		// Retrying starts over with a fresh set of attempts
+		retried, err := jobs.Retry(job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if retried.Status != models.JobPending || retried.Attempts != 0 {
+			t.Fatalf("Retry = %+v", retried)
+		}
+		if job := claim(t, jobs, time.Now().Add(time.Second)); job.Attempts != 1 {
+			t.Fatalf("Claim after retry = %+v", job)
+		}
+		if _, err := jobs.Retry(job.ID); !errors.Is(err, ErrJobState) {
+			t.Fatalf("Retry of a running job = %v, want ErrJobState", err)
+		}
+		if _, err := jobs.Retry(job.ID + 1000); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Retry of a missing job = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestJobRequeue(t *testing.T) {
+	eachJobQueue(t, func(t *testing.T, jobs JobRepository) {
+		now := time.Now()
+		due := now.Add(-2 * time.Hour)
+		stale := enqueue(t, jobs, models.Job{Kind: "stale", RunAt: due})
+		last := enqueue(t, jobs, models.Job{Kind: "last", MaxAttempts: 1, RunAt: due})
+		fresh := enqueue(t, jobs, models.Job{Kind: "fresh", RunAt: due})
+		claim(t, jobs, now.Add(-time.Hour))
+		claim(t, jobs, now.Add(-time.Hour))
+		claim(t, jobs, now)
+
+		// Jobs claimed before the cutoff go back, or die on their last attempt
+		n, err := jobs.Requeue(now.Add(-time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 {
+			t.Errorf("Requeue released %d jobs, want 2", n)
+		}
+		want := map[int]string{stale.ID: models.JobPending, last.ID: models.JobDead, fresh.ID: models.JobRunning}
+		for id, status := range want {
+			job, err := jobs.Get(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if job.Status != status {
+				t.Errorf("%s job is %s, want %s", job.Kind, job.Status, status)
+			}
+			if status != models.JobRunning && (job.LastError == nil || *job.LastError != jobAbandoned) {
+				t.Errorf("%s job has error %v", job.Kind, job.LastError)
+			}
+		}
+		if job := claim(t, jobs, now); job.ID != stale.ID || job.Attempts != 2 {
+			t.Fatalf("Claim after requeue = %+v, want the stale job again", job)
+		}
+	})
+}
+
+func TestJobClaimSkipsLockedJobs(t *testing.T) {
+	eachJobQueue(t, func(t *testing.T, jobs JobRepository) {
+		const total, workers = 40, 8
+		for i := 0; i < total; i++ {
+			enqueue(t, jobs, models.Job{Kind: "work"})
+		}
+
+		// Workers racing for the queue each get different jobs
+		now := time.Now().Add(time.Second)
+		var mu sync.Mutex
+		claimed := map[int]int{}
+		var wg sync.WaitGroup
+		errs := make(chan error, workers)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					job, err := jobs.Claim(now)
+					if errors.Is(err, ErrNotFound) {
+						return
+					}
+					if err != nil {
+						errs <- err
+						return
+					}
+					mu.Lock()
+					claimed[job.ID]++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatal(err)
+		}
+
+		if len(claimed) != total {
+			t.Errorf("claimed %d jobs, want %d", len(claimed), total)
+		}
+		for id, n := range claimed {
+			if n != 1 {
+				t.Errorf("job %d claimed %d times", id, n)
+			}
+		}
+	})
+}
+
+func TestJobPurgeDone(t *testing.T) {
+	eachJobQueue(t, func(t *testing.T, jobs JobRepository) {
+		done := enqueue(t, jobs, models.Job{Kind: "done"})
+		pending := enqueue(t, jobs, models.Job{Kind: "pending", RunAt: time.Now().Add(time.Hour)})
+		claim(t, jobs, time.Now())
+		if err := jobs.Complete(done.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := jobs.PurgeDone(time.Now().Add(time.Minute))
+		if err != nil || n != 1 {
+			t.Fatalf("PurgeDone = %d, %v; want 1", n, err)
+		}
+		if _, err := jobs.Get(done.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("purged job still there: %v", err)
+		}
+		if _, err := jobs.Get(pending.ID); err != nil {
+			t.Errorf("pending job purged: %v", err)
+		}
+	})
+}
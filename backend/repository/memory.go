@@ -0,0 +1,1484 @@
+package repository
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopping-list/backend/models"
+)
+
+// Memory implements the repositories in memory. It is meant for tests and
+// local experiments; nothing is persisted.
+type Memory struct {
+	mu       sync.Mutex
+	nextID   int
+	lists    map[int]*models.ShoppingList
+	items    map[int]*memoryItem
+	history  map[int]*models.ListHistory
+	members  map[int]map[int]bool
+	archived map[int]bool
+	activity []models.ListActivity
+	redoable map[int]bool
+	recurs   map[int]*models.Recurrence
+	jobs     map[int]*models.Job
+
+	// The change feed numbers changes as it finds them, see memorySync
+	changeSeq int64
+	changes   map[syncKey]*syncedRow
+}
+
+type memoryItem struct {
+	item   models.ShoppingItem
+	stamps map[string]time.Time
+}
+
+// NewMemory creates empty in-memory repositories
+func NewMemory() *Memory {
+	return &Memory{
+		lists:    map[int]*models.ShoppingList{},
+		items:    map[int]*memoryItem{},
+		history:  map[int]*models.ListHistory{},
+		members:  map[int]map[int]bool{},
+		archived: map[int]bool{},
+		redoable: map[int]bool{},
+		recurs:   map[int]*models.Recurrence{},
+		jobs:     map[int]*models.Job{},
+		changes:  map[syncKey]*syncedRow{},
+	}
+}
+
+// Lists returns the list repository
+func (m *Memory) Lists() ListRepository { return memoryLists{m} }
+
+// Items returns the item repository
+func (m *Memory) Items() ItemRepository { return memoryItems{m} }
+
+// History returns the history repository
+func (m *Memory) History() HistoryRepository { return memoryHistory{m} }
+
+// Trash returns the trash repository
+func (m *Memory) Trash() TrashRepository { return memoryTrash{m} }
+
+// Sync returns the sync change feed
+func (m *Memory) Sync() SyncRepository { return memorySync{m} }
+
+// Activity returns the activity repository
+func (m *Memory) Activity() ActivityRepository { return memoryActivity{m} }
+
+// Recurrences returns the recurrence repository
+func (m *Memory) Recurrences() RecurrenceRepository { return memoryRecurrences{m} }
+
+// Jobs returns the job queue
+func (m *Memory) Jobs() JobRepository { return memoryJobs{m} }
+
+// Share makes a list visible to a user, like a list_members row
+func (m *Memory) Share(listID, userID int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.members[listID] == nil {
+		m.members[listID] = map[int]bool{}
+	}
+	m.members[listID][userID] = true
+}
+
+// visibleTo reports whether a user sees a list within a household, or among
+// their personal lists when householdID is nil
+func (m *Memory) visibleTo(l *models.ShoppingList, userID int, householdID *int) bool {
+	mine := householdID == nil && l.UserID == userID && l.HouseholdID == nil
+	return mine || (householdID != nil && sameHousehold(l.HouseholdID, householdID)) || m.members[l.ID][userID]
+}
+
+func (m *Memory) id() int {
+	m.nextID++
+	return m.nextID
+}
+
+// liveList returns a list that is not in the trash
+func (m *Memory) liveList(id int) (*models.ShoppingList, bool) {
+	l, ok := m.lists[id]
+	return l, ok && l.DeletedAt == nil
+}
+
+// liveItem returns an item that is not in the trash
+func (m *Memory) liveItem(id int) (*memoryItem, bool) {
+	mi, ok := m.items[id]
+	return mi, ok && mi.item.DeletedAt == nil
+}
+
+// listItems returns copies of the items of a list ordered by id, leaving
+// out those in the trash
+func (m *Memory) listItems(listID int) []models.ShoppingItem {
+	items := []models.ShoppingItem{}
+	for _, mi := range m.items {
+		if mi.item.ListID == listID && mi.item.DeletedAt == nil {
+			items = append(items, mi.item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items
+}
+
+func (m *Memory) recordActivity(a models.ListActivity) {
+	a.ID = m.id()
+	a.CreatedAt = time.Now()
+	m.activity = append(m.activity, a)
+	// A new change discards whatever could be redone
+	for _, e := range m.activity {
+		if e.ListID == a.ListID {
+			delete(m.redoable, e.ID)
+		}
+	}
+}
+
+// trashedList returns a list that is in the trash, not marked done
+func (m *Memory) trashedList(id int) (*models.ShoppingList, bool) {
+	l, ok := m.lists[id]
+	return l, ok && l.DeletedAt != nil && !m.archived[id]
+}
+
+func (m *Memory) deleteList(id int) {
+	delete(m.lists, id)
+	delete(m.members, id)
+	delete(m.archived, id)
+	activity := m.activity[:0]
+	for _, a := range m.activity {
+		if a.ListID != id {
+			activity = append(activity, a)
+		}
+	}
+	m.activity = activity
+	for itemID, mi := range m.items {
+		if mi.item.ListID == id {
+			delete(m.items, itemID)
+		}
+	}
+}
+
+func inRange(t time.Time, after, before *time.Time) bool {
+	return (after == nil || t.After(*after)) && (before == nil || t.Before(*before))
+}
+
+// sortSlice sorts rows with a three-way comparison
+func sortSlice[T any](rows []T, compare func(a, b *T) int) {
+	sort.Slice(rows, func(i, j int) bool { return compare(&rows[i], &rows[j]) < 0 })
+}
+
+func sameHousehold(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+type memoryLists struct {
+	m *Memory
+}
+
+func (r memoryLists) Create(list *models.ShoppingList) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	now := time.Now()
+	list.ID = r.m.id()
+	list.Kind = listKind(list.Kind)
+	list.Version = 1
+	list.CreatedAt = now
+	list.UpdatedAt = now
+	stored := *list
+	stored.Items = nil
+	r.m.lists[list.ID] = &stored
+	return nil
+}
+
+func (r memoryLists) Visible(userID int, householdID *int, q ListQuery) ([]models.ShoppingList, string, error) {
+	o, sort, err := parseOrder(q.Sort, defaultListSort, listSorts)
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := decodeCursor(q.Cursor, sort)
+	if err != nil {
+		return nil, "", err
+	}
+
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	lists := []models.ShoppingList{}
+	for _, l := range r.m.lists {
+		if l.DeletedAt != nil || l.Kind != listKind(q.Kind) {
+			continue
+		}
+		if !r.m.visibleTo(l, userID, householdID) {
+			continue
+		}
+		if q.Name != "" && !strings.Contains(strings.ToLower(l.Name), strings.ToLower(q.Name)) {
+			continue
+		}
+		if !inRange(l.CreatedAt, q.CreatedAfter, q.CreatedBefore) || !inRange(l.UpdatedAt, q.UpdatedAfter, q.UpdatedBefore) {
+			continue
+		}
+		if c != nil && o.compare(listSortValue(l, o.column), l.ID, c.Value, c.ID) <= 0 {
+			continue
+		}
+		lists = append(lists, *l)
+	}
+	sortSlice(lists, func(a, b *models.ShoppingList) int {
+		return o.compare(listSortValue(a, o.column), a.ID, listSortValue(b, o.column), b.ID)
+	})
+
+	limit := pageLimit(q.Limit)
+	if len(lists) > limit+1 {
+		lists = lists[:limit+1]
+	}
+	lists, next := nextListCursor(lists, o, sort, limit)
+	for i := range lists {
+		lists[i].Items = r.m.listItems(lists[i].ID)
+	}
+	return lists, next, nil
+}
+
+func (r memoryLists) Get(id int) (models.ShoppingList, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	l, ok := r.m.liveList(id)
+	if !ok {
+		return models.ShoppingList{}, ErrNotFound
+	}
+	list := *l
+	list.Items = r.m.listItems(id)
+	return list, nil
+}
+
+func (r memoryLists) Rename(id int, name string, version *int, actorID int) (int, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	l, ok := r.m.liveList(id)
+	if !ok {
+		return 0, ErrNotFound
+	}
+	if version != nil && *version != l.Version {
+		return 0, ErrVersionMismatch
+	}
+	if a, ok := listRenamed(id, l.Name, name, actorID); ok {
+		r.m.recordActivity(a)
+	}
+	l.Name = name
+	l.Version++
+	l.UpdatedAt = time.Now()
+	return l.Version, nil
+}
+
+func (r memoryLists) Delete(id int, version *int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	l, ok := r.m.liveList(id)
+	if !ok {
+		return ErrNotFound
+	}
+	if version != nil && *version != l.Version {
+		return ErrVersionMismatch
+	}
+	now := time.Now()
+	l.DeletedAt = &now
+	l.Version++
+	return nil
+}
+
+func (r memoryLists) Copy(id int, c ListCopy) (models.ShoppingList, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	l, ok := r.m.liveList(id)
+	if !ok || l.Kind != c.From {
+		return models.ShoppingList{}, ErrNotFound
+	}
+	now := time.Now()
+	list := models.ShoppingList{ID: r.m.id(), UserID: c.UserID, HouseholdID: c.HouseholdID, Name: c.Name, Kind: c.To, Version: 1, CreatedAt: now, UpdatedAt: now}
+	if list.Name == "" {
+		list.Name = l.Name
+	}
+	stored := list
+	r.m.lists[list.ID] = &stored
+	for _, item := range r.m.listItems(id) {
+		copied := models.ShoppingItem{ID: r.m.id(), ListID: list.ID, Name: item.Name, Quantity: item.Quantity, Unit: item.Unit, Version: 1, CreatedAt: now}
+		r.m.items[copied.ID] = &memoryItem{item: copied, stamps: map[string]time.Time{}}
+	}
+	list.Items = r.m.listItems(list.ID)
+	return list, nil
+}
+
+type memoryItems struct {
+	m *Memory
+}
+
+func (r memoryItems) Create(item *models.ShoppingItem, actorID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if _, ok := r.m.liveList(item.ListID); !ok {
+		return ErrNotFound
+	}
+	item.ID = r.m.id()
+	item.Version = 1
+	item.CreatedAt = time.Now()
+	r.m.items[item.ID] = &memoryItem{item: *item, stamps: map[string]time.Time{}}
+	r.m.recordActivity(itemCreated(*item, actorID))
+	return nil
+}
+
+func (r memoryItems) Get(id int) (models.ShoppingItem, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	mi, ok := r.m.liveItem(id)
+	if !ok {
+		return models.ShoppingItem{}, ErrNotFound
+	}
+	return mi.item, nil
+}
+
+func (r memoryItems) Replace(item *models.ShoppingItem, version *int, actorID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	mi, ok := r.m.liveItem(item.ID)
+	if !ok {
+		return ErrNotFound
+	}
+	if version != nil && *version != mi.item.Version {
+		return ErrVersionMismatch
+	}
+	old := mi.item
+	mi.item.Name = item.Name
+	mi.item.Quantity = item.Quantity
+	mi.item.Unit = item.Unit
+	mi.item.Purchased = item.Purchased
+	mi.item.Version++
+	mi.stamps = allFieldsStamp(time.Now().UTC())
+	*item = mi.item
+	if a, ok := itemUpdated(old, mi.item, actorID); ok {
+		r.m.recordActivity(a)
+	}
+	return nil
+}
+
+func (r memoryItems) Merge(id int, patch ItemPatch, version *int, actorID int) (ItemMerge, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	mi, ok := r.m.liveItem(id)
+	if !ok {
+		return ItemMerge{}, ErrNotFound
+	}
+	if version != nil && *version != mi.item.Version {
+		return ItemMerge{Item: mi.item}, ErrVersionMismatch
+	}
+
+	item := mi.item
+	result := applyPatch(&item, mi.stamps, patch, patchTime(patch))
+	if len(result.Applied) > 0 {
+		item.Version++
+		if a, ok := itemUpdated(mi.item, item, actorID); ok {
+			r.m.recordActivity(a)
+		}
+		mi.item = item
+		result.Item = item
+	}
+	return result, nil
+}
+
+func (r memoryItems) Delete(id int, version *int, actorID int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	mi, ok := r.m.liveItem(id)
+	if !ok {
+		return ErrNotFound
+	}
+	if version != nil && *version != mi.item.Version {
+		return ErrVersionMismatch
+	}
+	now := time.Now()
+	mi.item.DeletedAt = &now
+	mi.item.Version++
+	r.m.recordActivity(itemDeleted(mi.item, actorID))
+	return nil
+}
+
+type memoryHistory struct {
+	m *Memory
+}
+
+func (r memoryHistory) Visible(userID int, householdID *int, q HistoryQuery) ([]models.ListHistory, string, error) {
+	o, sort, err := parseOrder(q.Sort, defaultHistorySort, historySorts)
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := decodeCursor(q.Cursor, sort)
+	if err != nil {
+		return nil, "", err
+	}
+
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	history := []models.ListHistory{}
+	for _, h := range r.m.history {
+		mine := householdID == nil && h.UserID == userID && h.HouseholdID == nil
+		if !mine && !(householdID != nil && sameHousehold(h.HouseholdID, householdID)) {
+			continue
+		}
+		if q.Action != "" && h.Action != q.Action {
+			continue
+		}
+		if !inRange(h.CreatedAt, q.CreatedAfter, q.CreatedBefore) {
+			continue
+		}
+		if c != nil && o.compare(timeKey(h.CreatedAt), h.ID, c.Value, c.ID) <= 0 {
+			continue
+		}
+		history = append(history, *h)
+	}
+	sortSlice(history, func(a, b *models.ListHistory) int {
+		return o.compare(timeKey(a.CreatedAt), a.ID, timeKey(b.CreatedAt), b.ID)
+	})
+
+	limit := pageLimit(q.Limit)
+	if len(history) > limit+1 {
+		history = history[:limit+1]
+	}
+	history, next := nextHistoryCursor(history, sort, limit)
+	return history, next, nil
+}
+
+func (r memoryHistory) Get(id int) (models.ListHistory, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	h, ok := r.m.history[id]
+	if !ok {
+		return models.ListHistory{}, ErrNotFound
+	}
+	return *h, nil
+}
+
+func (r memoryHistory) record(entry models.ListHistory) models.ListHistory {
+	entry.ID = r.m.id()
+	entry.CreatedAt = time.Now()
+	r.m.history[entry.ID] = &entry
+	return entry
+}
+
+func (r memoryHistory) ArchiveList(listID int, actorID int, carry *CarryOver) (models.ListHistory, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	entry, err := r.archive(listID, carry)
+	if err != nil {
+		return entry, err
+	}
+	r.m.recordActivity(listDone(listID, entry, actorID))
+	return entry, nil
+}
+
+// archive snapshots a list into history and hides it, carrying its
+// unpurchased items over first when asked to
+func (r memoryHistory) archive(listID int, carry *CarryOver) (models.ListHistory, error) {
+	l, ok := r.m.liveList(listID)
+	if !ok {
+		return models.ListHistory{}, ErrNotFound
+	}
+	if l.Kind == models.ListKindTemplate {
+		return models.ListHistory{}, ErrTemplate
+	}
+
+	var carriedOverTo *int
+	if carry != nil {
+		to, err := r.carryOverList(l, carry)
+		if err != nil {
+			return models.ListHistory{}, err
+		}
+		carriedOverTo = &to
+	}
+
+	var items []models.SnapshotItem
+	for _, item := range r.m.listItems(listID) {
+		items = append(items, models.SnapshotItem{
+			ID:          item.ID,
+			Name:        item.Name,
+			Quantity:    item.Quantity,
+			Unit:        item.Unit,
+			Purchased:   item.Purchased,
+			CarriedOver: carriedOverTo != nil && !item.Purchased,
+		})
+	}
+	data, err := encodeSnapshot(l.Name, items, carriedOverTo)
+	if err != nil {
+		return models.ListHistory{}, err
+	}
+	for _, si := range items {
+		if si.CarriedOver {
+			mi := r.m.items[si.ID]
+			mi.item.ListID = *carriedOverTo
+			mi.item.Version++
+		}
+	}
+
+	entry := r.record(models.ListHistory{
+		UserID:         l.UserID,
+		HouseholdID:    l.HouseholdID,
+		OriginalListID: &listID,
+		Action:         models.ActionCreated,
+		Data:           json.RawMessage(data),
+	})
+	now := time.Now()
+	l.DeletedAt = &now
+	l.Version++
+	r.m.archived[listID] = true
+	return entry, nil
+}
+
+// carryOverList is carryOverList for the in-memory repositories
+func (r memoryHistory) carryOverList(l *models.ShoppingList, carry *CarryOver) (int, error) {
+	if carry.ListID != nil {
+		target, ok := r.m.liveList(*carry.ListID)
+		if !ok || target.ID == l.ID || target.Kind != models.ListKindList {
+			return 0, ErrInvalidCarryOver
+		}
+		return *carry.ListID, nil
+	}
+	now := time.Now()
+	list := models.ShoppingList{ID: r.m.id(), UserID: carry.UserID, HouseholdID: carry.HouseholdID, Name: carry.Name, Kind: models.ListKindList, Version: 1, CreatedAt: now, UpdatedAt: now}
+	if list.Name == "" {
+		list.Name = l.Name
+	}
+	r.m.lists[list.ID] = &list
+	return list.ID, nil
+}
+
+func (r memoryHistory) RestoreList(historyID, userID int, householdID *int, opts ReuseOptions) (models.ShoppingList, AddedItems, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	h, ok := r.m.history[historyID]
+	if !ok {
+		return models.ShoppingList{}, AddedItems{}, ErrNotFound
+	}
+	name, items, err := decodeSnapshot(*h, opts)
+	if err != nil {
+		return models.ShoppingList{}, AddedItems{}, err
+	}
+
+	var list models.ShoppingList
+	if opts.ListID != nil {
+		l, ok := r.m.liveList(*opts.ListID)
+		if !ok {
+			return models.ShoppingList{}, AddedItems{}, ErrNotFound
+		}
+		list = *l
+	} else {
+		now := time.Now()
+		list = models.ShoppingList{ID: r.m.id(), UserID: userID, HouseholdID: householdID, Name: name, Kind: models.ListKindList, Version: 1, CreatedAt: now, UpdatedAt: now}
+		stored := list
+		r.m.lists[list.ID] = &stored
+	}
+	data, err := encodeReuse(historyID, list.ID, opts.ListID != nil)
+	if err != nil {
+		return models.ShoppingList{}, AddedItems{}, err
+	}
+	var merge mergeFunc
+	if opts.ListID != nil {
+		merge = mergeReused
+	}
+	var added AddedItems
+	if list.Items, added, err = r.m.addItems(list.ID, items, userID, merge); err != nil {
+		return models.ShoppingList{}, AddedItems{}, err
+	}
+
+	newListID := list.ID
+	r.record(models.ListHistory{
+		UserID:         userID,
+		HouseholdID:    householdID,
+		OriginalListID: &newListID,
+		Action:         models.ActionReused,
+		Data:           json.RawMessage(data),
+	})
+	return list, added, nil
+}
+
+// addItems adds items to a list, merging them into its existing items with
+// merge when set, and returns the items added or changed, in order and
+// apart. Only changes to a list that was merged into show up in its
+// activity.
+func (m *Memory) addItems(listID int, items []models.ShoppingItem, actorID int, merge mergeFunc) ([]models.ShoppingItem, AddedItems, error) {
+	var split AddedItems
+	l, ok := m.liveList(listID)
+	if !ok {
+		return nil, split, ErrNotFound
+	}
+	if l.Kind != models.ListKindList {
+		return nil, split, ErrInvalidTarget
+	}
+
+	existing := map[string]*memoryItem{}
+	if merge != nil {
+		for _, mi := range m.items {
+			if mi.item.ListID != listID || mi.item.DeletedAt != nil {
+				continue
+			}
+			key := itemKey(mi.item.Name, mi.item.Unit)
+			if first, ok := existing[key]; !ok || mi.item.ID < first.item.ID {
+				existing[key] = mi
+			}
+		}
+	}
+
+	added := []models.ShoppingItem{}
+	split = AddedItems{Created: []models.ShoppingItem{}, Merged: []models.ShoppingItem{}}
+	now := time.Now()
+	for _, item := range items {
+		mi, ok := existing[itemKey(item.Name, item.Unit)]
+		if !ok {
+			item.ID = m.id()
+			item.ListID = listID
+			item.Version = 1
+			item.CreatedAt = now
+			m.items[item.ID] = &memoryItem{item: item, stamps: map[string]time.Time{}}
+			if merge != nil {
+				m.recordActivity(itemCreated(item, actorID))
+			}
+			added = append(added, item)
+			split.Created = append(split.Created, item)
+			continue
+		}
+
+		fields := merge(mi.item, item)
+		if fields == nil {
+			continue
+		}
+		old := mi.item
+		for _, field := range applyFields(&mi.item, fields) {
+			mi.stamps[field] = now.UTC()
+		}
+		mi.item.Version++
+		if a, ok := itemUpdated(old, mi.item, actorID); ok {
+			m.recordActivity(a)
+		}
+		added = append(added, mi.item)
+		split.Merged = append(split.Merged, mi.item)
+	}
+	return added, split, nil
+}
+
+type memoryTrash struct {
+	m *Memory
+}
+
+// deletedFirst orders rows in the trash by deletion time then id, newest
+// first
+func deletedFirst(at time.Time, id int, otherAt time.Time, otherID int) bool {
+	if !at.Equal(otherAt) {
+		return at.After(otherAt)
+	}
+	return id > otherID
+}
+
+func (r memoryTrash) Visible(userID int, householdID *int) (models.Trash, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	visible := func(l *models.ShoppingList) bool { return r.m.visibleTo(l, userID, householdID) }
+
+	trash := models.Trash{Lists: []models.ShoppingList{}, Items: []models.ShoppingItem{}}
+	for _, l := range r.m.lists {
+		if _, ok := r.m.trashedList(l.ID); ok && visible(l) {
+			list := *l
+			list.Items = r.m.listItems(l.ID)
+			trash.Lists = append(trash.Lists, list)
+		}
+	}
+	for _, mi := range r.m.items {
+		if mi.item.DeletedAt == nil {
+			continue
+		}
+		if l, ok := r.m.liveList(mi.item.ListID); ok && visible(l) {
+			trash.Items = append(trash.Items, mi.item)
+		}
+	}
+	sort.Slice(trash.Lists, func(i, j int) bool {
+		a, b := trash.Lists[i], trash.Lists[j]
+		return deletedFirst(*a.DeletedAt, a.ID, *b.DeletedAt, b.ID)
+	})
+	sort.Slice(trash.Items, func(i, j int) bool {
+		a, b := trash.Items[i], trash.Items[j]
+		return deletedFirst(*a.DeletedAt, a.ID, *b.DeletedAt, b.ID)
+	})
+	return trash, nil
+}
+
+func (r memoryTrash) RestoreList(id int) (models.ShoppingList, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	l, ok := r.m.trashedList(id)
+	if !ok {
+		return models.ShoppingList{}, ErrNotFound
+	}
+	l.DeletedAt = nil
+	l.Version++
+	l.UpdatedAt = time.Now()
+	list := *l
+	list.Items = r.m.listItems(id)
+	return list, nil
+}
+
+func (r memoryTrash) RestoreItem(id int, actorID int) (models.ShoppingItem, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	mi, ok := r.m.items[id]
+	if !ok || mi.item.DeletedAt == nil {
+		return models.ShoppingItem{}, ErrNotFound
+	}
+	if _, ok := r.m.liveList(mi.item.ListID); !ok {
+		return models.ShoppingItem{}, ErrNotFound
+	}
+	mi.item.DeletedAt = nil
+	mi.item.Version++
+	r.m.recordActivity(itemRestored(mi.item, actorID))
+	return mi.item, nil
+}
+
+func (r memoryTrash) PurgeList(id int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if _, ok := r.m.trashedList(id); !ok {
+		return ErrNotFound
+	}
+	r.m.deleteList(id)
+	return nil
+}
+
+func (r memoryTrash) PurgeItem(id int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	mi, ok := r.m.items[id]
+	if !ok || mi.item.DeletedAt == nil {
+		return ErrNotFound
+	}
+	delete(r.m.items, id)
+	return nil
+}
+
+func (r memoryTrash) PurgeExpired(before time.Time) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var purged int64
+	for id, mi := range r.m.items {
+		if mi.item.DeletedAt != nil && mi.item.DeletedAt.Before(before) {
+			delete(r.m.items, id)
+			purged++
+		}
+	}
+	for id, l := range r.m.lists {
+		if l.DeletedAt != nil && l.DeletedAt.Before(before) {
+			r.m.deleteList(id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// memorySync numbers changes when the feed is read rather than on every
+// write: any list or item whose version moved since the last read gets the
+// next change_seq, and any that disappeared gets a tombstone. That hands out
+// the same cursors the database triggers do, as far as readers can tell.
+type memorySync struct {
+	m *Memory
+}
+
+type syncKey struct {
+	typ string
+	id  int
+}
+
+// syncedRow is what the feed last saw of a list or item. Lists keep who
+// could see them for their tombstone.
+type syncedRow struct {
+	version     int
+	changeSeq   int64
+	listID      int
+	userIDs     []int
+	householdID *int
+	deleted     *models.Tombstone
+}
+
+// stampChanges numbers the changes made since the feed was last read
+func (m *Memory) stampChanges() {
+	seen := func(key syncKey, version, listID int) *syncedRow {
+		row, ok := m.changes[key]
+		if !ok || row.version != version || row.deleted != nil {
+			m.changeSeq++
+			row = &syncedRow{version: version, changeSeq: m.changeSeq, listID: listID}
+			m.changes[key] = row
+		}
+		return row
+	}
+	for _, l := range m.lists {
+		row := seen(syncKey{"list", l.ID}, l.Version, l.ID)
+		row.userIDs = []int{l.UserID}
+		for userID := range m.members[l.ID] {
+			row.userIDs = append(row.userIDs, userID)
+		}
+		row.householdID = l.HouseholdID
+	}
+	for _, mi := range m.items {
+		seen(syncKey{"item", mi.item.ID}, mi.item.Version, mi.item.ListID)
+	}
+
+	// Rows that are gone were purged. Items purged with their list are
+	// covered by the list's tombstone.
+	now := time.Now()
+	for key, row := range m.changes {
+		if row.deleted != nil {
+			continue
+		}
+		_, list := m.lists[key.id]
+		_, item := m.items[key.id]
+		if (key.typ == "list" && list) || (key.typ == "item" && item) {
+			continue
+		}
+		if _, ok := m.lists[row.listID]; key.typ == "item" && !ok {
+			delete(m.changes, key)
+			continue
+		}
+		m.changeSeq++
+		row.deleted = &models.Tombstone{Type: key.typ, ID: key.id, ListID: row.listID, ChangeSeq: m.changeSeq, DeletedAt: now}
+	}
+}
+
+func (r memorySync) Changes(userID int, householdID *int, since int64) (SyncChanges, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	r.m.stampChanges()
+
+	changes := SyncChanges{Cursor: since, Lists: []models.ShoppingList{}, Items: []models.ShoppingItem{}, Deleted: []models.Tombstone{}}
+	trashed := func(typ string, id, listID int, seq int64, at time.Time) {
+		// A full snapshot has nothing to delete on the client
+		if since > 0 {
+			changes.Deleted = append(changes.Deleted, models.Tombstone{Type: typ, ID: id, ListID: listID, ChangeSeq: seq, DeletedAt: at})
+		}
+	}
+	for _, l := range r.m.lists {
+		seq := r.m.changes[syncKey{"list", l.ID}].changeSeq
+		if seq <= since || !r.m.visibleTo(l, userID, householdID) {
+			continue
+		}
+		changes.Cursor = max(changes.Cursor, seq)
+		if l.DeletedAt != nil {
+			trashed("list", l.ID, l.ID, seq, *l.DeletedAt)
+			continue
+		}
+		list := *l
+		list.ChangeSeq = seq
+		changes.Lists = append(changes.Lists, list)
+	}
+	for _, mi := range r.m.items {
+		seq := r.m.changes[syncKey{"item", mi.item.ID}].changeSeq
+		l, ok := r.m.liveList(mi.item.ListID)
+		if seq <= since || !ok || !r.m.visibleTo(l, userID, householdID) {
+			continue
+		}
+		changes.Cursor = max(changes.Cursor, seq)
+		if mi.item.DeletedAt != nil {
+			trashed("item", mi.item.ID, mi.item.ListID, seq, *mi.item.DeletedAt)
+			continue
+		}
+		item := mi.item
+		item.ChangeSeq = seq
+		changes.Items = append(changes.Items, item)
+	}
+	if since > 0 {
+		for _, row := range r.m.changes {
+			t := row.deleted
+			if t == nil || t.ChangeSeq <= since {
+				continue
+			}
+			var visible bool
+			if t.Type == "list" {
+				visible = householdID != nil && sameHousehold(row.householdID, householdID)
+				for _, id := range row.userIDs {
+					visible = visible || id == userID
+				}
+			} else if l, ok := r.m.lists[t.ListID]; ok {
+				visible = r.m.visibleTo(l, userID, householdID)
+			}
+			if visible {
+				changes.Cursor = max(changes.Cursor, t.ChangeSeq)
+				changes.Deleted = append(changes.Deleted, *t)
+			}
+		}
+	}
+
+	sort.Slice(changes.Lists, func(i, j int) bool { return changes.Lists[i].ChangeSeq < changes.Lists[j].ChangeSeq })
+	sort.Slice(changes.Items, func(i, j int) bool { return changes.Items[i].ChangeSeq < changes.Items[j].ChangeSeq })
+	sort.Slice(changes.Deleted, func(i, j int) bool { return changes.Deleted[i].ChangeSeq < changes.Deleted[j].ChangeSeq })
+	return changes, nil
+}
+
+type memoryActivity struct {
+	m *Memory
+}
+
+func (r memoryActivity) ForList(listID int, q ActivityQuery) ([]models.ListActivity, string, error) {
+	o, sort, err := parseOrder(q.Sort, defaultActivitySort, activitySorts)
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := decodeCursor(q.Cursor, sort)
+	if err != nil {
+		return nil, "", err
+	}
+
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	activity := []models.ListActivity{}
+	for _, a := range r.m.activity {
+		if a.ListID != listID || (q.Action != "" && a.Action != q.Action) {
+			continue
+		}
+		if !inRange(a.CreatedAt, q.CreatedAfter, q.CreatedBefore) {
+			continue
+		}
+		if c != nil && o.compare(timeKey(a.CreatedAt), a.ID, c.Value, c.ID) <= 0 {
+			continue
+		}
+		activity = append(activity, a)
+	}
+	sortSlice(activity, func(a, b *models.ListActivity) int {
+		return o.compare(timeKey(a.CreatedAt), a.ID, timeKey(b.CreatedAt), b.ID)
+	})
+
+	limit := pageLimit(q.Limit)
+	if len(activity) > limit+1 {
+		activity = activity[:limit+1]
+	}
+	activity, next := nextActivityCursor(activity, sort, limit)
+	return activity, next, nil
+}
+
+func (r memoryActivity) Undo(listID, actorID int) (models.ListActivity, error) {
+	return r.step(listID, actorID, true)
+}
+
+func (r memoryActivity) Redo(listID, actorID int) (models.ListActivity, error) {
+	return r.step(listID, actorID, false)
+}
+
+// step undoes or redoes one change to a list, see ActivityRepository
+func (r memoryActivity) step(listID, actorID int, undo bool) (models.ListActivity, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if _, ok := r.m.lists[listID]; !ok {
+		return models.ListActivity{}, ErrNotFound
+	}
+
+	undoable := func(a *models.ListActivity) bool {
+		if a.ListID != listID {
+			return false
+		}
+		if a.ItemID == nil {
+			return true
+		}
+		mi, ok := r.m.items[*a.ItemID]
+		return ok && mi.item.ListID == listID
+	}
+
+	// Activity is kept in id order
+	var target *models.ListActivity
+	if undo {
+		for i := len(r.m.activity) - 1; i >= 0 && target == nil; i-- {
+			if a := &r.m.activity[i]; a.UndoneAt == nil && undoable(a) {
+				target = a
+			}
+		}
+		if target == nil {
+			return models.ListActivity{}, ErrNothingToUndo
+		}
+	} else {
+		for i := range r.m.activity {
+			if a := &r.m.activity[i]; r.m.redoable[a.ID] && undoable(a) {
+				target = a
+				break
+			}
+		}
+		if target == nil {
+			return models.ListActivity{}, ErrNothingToRedo
+		}
+	}
+
+	if err := r.revert(target, undo); err != nil {
+		return models.ListActivity{}, err
+	}
+	if undo {
+		now := time.Now()
+		target.UndoneAt, target.UndoneBy = &now, &actorID
+		r.m.redoable[target.ID] = true
+	} else {
+		target.UndoneAt, target.UndoneBy = nil, nil
+		delete(r.m.redoable, target.ID)
+	}
+	return *target, nil
+}
+
+// revert is revertActivity for the in-memory repositories
+func (r memoryActivity) revert(a *models.ListActivity, undo bool) error {
+	fields, current := a.After, a.Before
+	if undo {
+		fields, current = a.Before, a.After
+	}
+
+	trash := func(trashed bool) error {
+		mi := r.m.items[*a.ItemID]
+		if (mi.item.DeletedAt != nil) == trashed {
+			return ErrUndoConflict
+		}
+		mi.item.DeletedAt = nil
+		if trashed {
+			now := time.Now()
+			mi.item.DeletedAt = &now
+		}
+		mi.item.Version++
+		return nil
+	}
+
+	switch a.Action {
+	case models.ActivityItemCreated, models.ActivityItemRestored:
+		return trash(undo)
+	case models.ActivityItemDeleted:
+		return trash(!undo)
+	case models.ActivityItemUpdated:
+		mi, ok := r.m.liveItem(*a.ItemID)
+		if !ok || !holdsFields(mi.item, current) {
+			return ErrUndoConflict
+		}
+		now := time.Now().UTC()
+		for _, field := range applyFields(&mi.item, fields) {
+			mi.stamps[field] = now
+		}
+		mi.item.Version++
+		return nil
+	case models.ActivityListRenamed:
+		l, ok := r.m.liveList(a.ListID)
+		if !ok {
+			return ErrUndoConflict
+		}
+		l.Name, _ = fields["name"].(string)
+		l.Version++
+		l.UpdatedAt = time.Now()
+		return nil
+	case models.ActivityListDone:
+		if !undo {
+			entry, err := memoryHistory{r.m}.archive(a.ListID, redoCarryOver(*a))
+			if err != nil {
+				return ErrUndoConflict
+			}
+			a.After = listDone(a.ListID, entry, a.UserID).After
+			return nil
+		}
+		l := r.m.lists[a.ListID]
+		entry, ok := r.m.history[afterID(*a, "history_id")]
+		if !r.m.archived[a.ListID] || !ok {
+			return ErrUndoConflict
+		}
+		delete(r.m.archived, a.ListID)
+		l.DeletedAt = nil
+		l.Version++
+		l.UpdatedAt = time.Now()
+		if to, ids := carriedOver(*entry); to != nil {
+			for _, id := range ids {
+				if mi, ok := r.m.items[id]; ok && mi.item.ListID == *to {
+					mi.item.ListID = a.ListID
+					mi.item.Version++
+				}
+			}
+		}
+		delete(r.m.history, entry.ID)
+		return nil
+	}
+	return ErrUndoConflict
+}
+
+type memoryRecurrences struct {
+	m *Memory
+}
+
+// activeList returns a list that items can be added to
+func (m *Memory) activeList(id int) (*models.ShoppingList, bool) {
+	l, ok := m.liveList(id)
+	return l, ok && l.Kind == models.ListKindList
+}
+
+// pruneRecurrences drops rules whose template or item was purged, like the
+// foreign keys do
+func (m *Memory) pruneRecurrences() {
+	for id, rec := range m.recurs {
+		if rec.TemplateID != nil && m.lists[*rec.TemplateID] == nil || rec.ItemID != nil && m.items[*rec.ItemID] == nil {
+			delete(m.recurs, id)
+		}
+	}
+}
+
+func (r memoryRecurrences) Create(rec *models.Recurrence) error {
+	if err := scheduleRecurrence(rec); err != nil {
+		return err
+	}
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if rec.TemplateID != nil {
+		if l, ok := r.m.liveList(*rec.TemplateID); !ok || l.Kind != models.ListKindTemplate {
+			return ErrNotFound
+		}
+	} else {
+		mi, ok := r.m.liveItem(*rec.ItemID)
+		if !ok {
+			return ErrNotFound
+		}
+		l, ok := r.m.liveList(mi.item.ListID)
+		if !ok {
+			return ErrNotFound
+		}
+		if l.Kind != models.ListKindList && rec.ListID == nil {
+			return ErrInvalidTarget
+		}
+	}
+	if rec.ListID != nil {
+		if _, ok := r.m.activeList(*rec.ListID); !ok {
+			return ErrInvalidTarget
+		}
+	}
+
+	rec.ID = r.m.id()
+	rec.CreatedAt = time.Now()
+	stored := *rec
+	r.m.recurs[rec.ID] = &stored
+	return nil
+}
+
+func (r memoryRecurrences) ForUser(userID int) ([]models.Recurrence, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	r.m.pruneRecurrences()
+	recs := []models.Recurrence{}
+	for _, rec := range r.m.recurs {
+		if rec.UserID == userID {
+			recs = append(recs, *rec)
+		}
+	}
+	sortSlice(recs, func(a, b *models.Recurrence) int {
+		if c := a.NextRunAt.Compare(b.NextRunAt); c != 0 {
+			return c
+		}
+		return a.ID - b.ID
+	})
+	return recs, nil
+}
+
+func (r memoryRecurrences) Get(id int) (models.Recurrence, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	r.m.pruneRecurrences()
+	rec, ok := r.m.recurs[id]
+	if !ok {
+		return models.Recurrence{}, ErrNotFound
+	}
+	return *rec, nil
+}
+
+func (r memoryRecurrences) Delete(id int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if _, ok := r.m.recurs[id]; !ok {
+		return ErrNotFound
+	}
+	delete(r.m.recurs, id)
+	return nil
+}
+
+func (r memoryRecurrences) RunDue(now time.Time) ([]RecurrenceRun, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	now = now.UTC()
+	r.m.pruneRecurrences()
+	runs := []RecurrenceRun{}
+	for _, rec := range r.m.recurs {
+		if rec.NextRunAt.After(now) {
+			continue
+		}
+		rule, err := models.ParseRule(rec.Rule)
+		if err != nil {
+			return runs, err
+		}
+		run := RecurrenceRun{Recurrence: *rec}
+		ok := r.run(&run)
+		rec.NextRunAt = rule.Next(rec.StartsAt, now)
+		rec.LastRunAt = &now
+		if ok {
+			run.Recurrence = *rec
+			runs = append(runs, run)
+		}
+	}
+	return runs, nil
+}
+
+// run adds the items of a rule to the list they go to, see runRecurrence
+func (r memoryRecurrences) run(run *RecurrenceRun) bool {
+	rec := run.Recurrence
+	var target *models.ShoppingList
+	if rec.ListID != nil {
+		target, _ = r.m.activeList(*rec.ListID)
+	}
+
+	var items []models.ShoppingItem
+	if rec.TemplateID != nil {
+		tmpl, ok := r.m.liveList(*rec.TemplateID)
+		if !ok || tmpl.Kind != models.ListKindTemplate {
+			return false
+		}
+		items = r.m.listItems(tmpl.ID)
+		if target == nil {
+			now := time.Now()
+			list := models.ShoppingList{ID: r.m.id(), UserID: rec.UserID, HouseholdID: tmpl.HouseholdID, Name: tmpl.Name, Kind: models.ListKindList, Version: 1, CreatedAt: now, UpdatedAt: now}
+			stored := list
+			r.m.lists[list.ID] = &stored
+			list.Items, run.Added, _ = r.m.addItems(list.ID, recurringItems(items), rec.UserID, nil)
+			run.List = list
+			return true
+		}
+	} else {
+		mi, ok := r.m.liveItem(*rec.ItemID)
+		if !ok {
+			return false
+		}
+		if target == nil {
+			if target, ok = r.m.activeList(mi.item.ListID); !ok {
+				return false
+			}
+		}
+		items = []models.ShoppingItem{mi.item}
+	}
+
+	run.List = *target
+	var err error
+	if run.List.Items, run.Added, err = r.m.addItems(target.ID, recurringItems(items), rec.UserID, mergeRecurring); err != nil {
+		return false
+	}
+	return len(run.List.Items) > 0
+}
+
+type memoryJobs struct {
+	m *Memory
+}
+
+func (r memoryJobs) Enqueue(job *models.Job) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if job.UniqueKey != nil {
+		for _, other := range r.m.jobs {
+			if other.UniqueKey != nil && *other.UniqueKey == *job.UniqueKey {
+				return ErrJobExists
+			}
+		}
+	}
+	prepareJob(job)
+	job.ID = r.m.id()
+	job.CreatedAt = time.Now()
+	job.UpdatedAt = job.CreatedAt
+	stored := *job
+	r.m.jobs[job.ID] = &stored
+	return nil
+}
+
+func (r memoryJobs) Claim(now time.Time) (models.Job, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var next *models.Job
+	for _, job := range r.m.jobs {
+		if job.Status != models.JobPending || job.RunAt.After(now) {
+			continue
+		}
+		if next == nil || job.RunAt.Before(next.RunAt) || job.RunAt.Equal(next.RunAt) && job.ID < next.ID {
+			next = job
+		}
+	}
+	if next == nil {
+		return models.Job{}, ErrNotFound
+	}
+	now = now.UTC()
+	next.Status = models.JobRunning
+	next.Attempts++
+	next.LockedAt = &now
+	next.UpdatedAt = time.Now()
+	return *next, nil
+}
+
+// running returns a job that a worker claimed
+func (r memoryJobs) running(id int) (*models.Job, error) {
+	job, ok := r.m.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if job.Status != models.JobRunning {
+		return nil, ErrJobState
+	}
+	return job, nil
+}
+
+// release takes a job away from its worker, dead-lettering it once it has
+// used up its attempts
+func (r memoryJobs) release(job *models.Job, reason string) {
+	job.Status = models.JobPending
+	if job.Attempts >= job.MaxAttempts {
+		job.Status = models.JobDead
+	}
+	job.LockedAt = nil
+	job.LastError = &reason
+	job.UpdatedAt = time.Now()
+}
+
+func (r memoryJobs) Complete(id int) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	job, err := r.running(id)
+	if err != nil {
+		return err
+	}
+	job.Status = models.JobDone
+	job.LockedAt = nil
+	job.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r memoryJobs) Fail(id int, reason string, retryAt time.Time) (models.Job, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	job, err := r.running(id)
+	if err != nil {
+		return models.Job{}, err
+	}
+	job.RunAt = retryAt.UTC()
+	r.release(job, reason)
+	return *job, nil
+}
+
+func (r memoryJobs) Requeue(claimedBefore time.Time) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var released int64
+	for _, job := range r.m.jobs {
+		if job.Status == models.JobRunning && job.LockedAt.Before(claimedBefore) {
+			r.release(job, jobAbandoned)
+			released++
+		}
+	}
+	return released, nil
+}
+
+func (r memoryJobs) List(q JobQuery) ([]models.Job, string, error) {
+	o, sort, err := parseOrder(q.Sort, defaultJobSort, jobSorts)
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := decodeCursor(q.Cursor, sort)
+	if err != nil {
+		return nil, "", err
+	}
+
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	jobs := []models.Job{}
+	for _, job := range r.m.jobs {
+		if (q.Status != "" && job.Status != q.Status) || (q.Kind != "" && job.Kind != q.Kind) {
+			continue
+		}
+		if c != nil && o.compare(timeKey(job.CreatedAt), job.ID, c.Value, c.ID) <= 0 {
+			continue
+		}
+		jobs = append(jobs, *job)
+	}
+	sortSlice(jobs, func(a, b *models.Job) int {
+		return o.compare(timeKey(a.CreatedAt), a.ID, timeKey(b.CreatedAt), b.ID)
+	})
+
+	limit := pageLimit(q.Limit)
+	if len(jobs) > limit+1 {
+		jobs = jobs[:limit+1]
+	}
+	jobs, next := nextJobCursor(jobs, sort, limit)
+	return jobs, next, nil
+}
+
+func (r memoryJobs) Get(id int) (models.Job, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	job, ok := r.m.jobs[id]
+	if !ok {
+		return models.Job{}, ErrNotFound
+	}
+	return *job, nil
+}
+
+func (r memoryJobs) Retry(id int) (models.Job, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	job, ok := r.m.jobs[id]
+	if !ok {
+		return models.Job{}, ErrNotFound
+	}
+	if job.Status != models.JobDead && job.Status != models.JobPending {
+		return models.Job{}, ErrJobState
+	}
+	job.Status = models.JobPending
+	job.Attempts = 0
+	job.RunAt = time.Now().UTC()
+	job.UpdatedAt = time.Now()
+	return *job, nil
+}
+
+func (r memoryJobs) PurgeDone(before time.Time) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var purged int64
+	for id, job := range r.m.jobs {
+		if job.Status == models.JobDone && job.UpdatedAt.Before(before) {
+			delete(r.m.jobs, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
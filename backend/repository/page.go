@@ -0,0 +1,311 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopping-list/backend/models"
+)
+
+var (
+	// ErrInvalidCursor is returned for a cursor that is malformed or was
+	// issued for a different sort order
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrInvalidSort is returned for an unsupported sort parameter
+	ErrInvalidSort = errors.New("invalid sort")
+)
+
+// Page sizes
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 100
+)
+
+// Page selects one page of results in a sort order. Sort is a column name,
+// prefixed with "-" for descending order; Cursor is the NextCursor of the
+// previous page.
+type Page struct {
+	Sort   string
+	Cursor string
+	Limit  int
+}
+
+// ListQuery filters the lists returned by ListRepository.Visible
+type ListQuery struct {
+	Page
+	// Kind selects lists or templates, lists when empty
+	Kind string
+	// Name matches lists whose name contains it, ignoring case
+	Name          string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	UpdatedAfter  *time.Time
+	UpdatedBefore *time.Time
+}
+
+// HistoryQuery filters the entries returned by HistoryRepository.Visible
+type HistoryQuery struct {
+	Page
+	Action        string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+// ActivityQuery filters the entries returned by ActivityRepository.ForList
+type ActivityQuery struct {
+	Page
+	Action        string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+// JobQuery filters the jobs returned by JobRepository.List
+type JobQuery struct {
+	Page
+	Status string
+	Kind   string
+}
+
+type sortKind int
+
+const (
+	sortTime sortKind = iota
+	sortText
+)
+
+// Sortable columns
+var (
+	listSorts     = map[string]sortKind{"updated_at": sortTime, "created_at": sortTime, "name": sortText}
+	historySorts  = map[string]sortKind{"created_at": sortTime}
+	activitySorts = map[string]sortKind{"created_at": sortTime}
+	jobSorts      = map[string]sortKind{"created_at": sortTime}
+)
+
+const (
+	defaultListSort     = "-updated_at"
+	defaultHistorySort  = "-created_at"
+	defaultActivitySort = "-created_at"
+	defaultJobSort      = "-created_at"
+)
+
+// order is a parsed sort parameter
+type order struct {
+	column string
+	desc   bool
+	kind   sortKind
+}
+
+func parseOrder(sort, def string, allowed map[string]sortKind) (order, string, error) {
+	if sort == "" {
+		sort = def
+	}
+	column := strings.TrimPrefix(sort, "-")
+	kind, ok := allowed[column]
+	if !ok {
+		return order{}, "", ErrInvalidSort
+	}
+	return order{column: column, desc: strings.HasPrefix(sort, "-"), kind: kind}, sort, nil
+}
+
+// cursor is the position after the last row of a page: its sort value and
+// id, which breaks ties. Times are stored in a fixed-width UTC format so
+// they compare correctly as strings.
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+const cursorTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+func timeKey(t time.Time) string {
+	return t.UTC().Format(cursorTimeFormat)
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor; an empty string means the first page
+func decodeCursor(s, sort string) (*cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// value converts a cursor value back into a query argument
+func (o order) value(c *cursor) (interface{}, error) {
+	if o.kind == sortText {
+		return c.Value, nil
+	}
+	t, err := time.Parse(cursorTimeFormat, c.Value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return t, nil
+}
+
+func pageLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return limit
+}
+
+// sqlWhere builds a WHERE clause, numbering placeholders after the ones
+// already taken by args
+type sqlWhere struct {
+	conds []string
+	args  []interface{}
+}
+
+// arg adds an argument and returns its placeholder
+func (w *sqlWhere) arg(v interface{}) string {
+	w.args = append(w.args, v)
+	return "$" + strconv.Itoa(len(w.args))
+}
+
+// add appends a condition; each ? in it becomes the next $N placeholder
+func (w *sqlWhere) add(cond string, args ...interface{}) {
+	for _, arg := range args {
+		cond = strings.Replace(cond, "?", w.arg(arg), 1)
+	}
+	w.conds = append(w.conds, cond)
+}
+
+// String joins the conditions, matching every row when there are none
+func (w *sqlWhere) String() string {
+	if len(w.conds) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(w.conds, " AND ")
+}
+
+// addRange adds the optional bounds of a timestamp filter
+func (w *sqlWhere) addRange(column string, after, before *time.Time) {
+	if after != nil {
+		w.add(column+" > ?", *after)
+	}
+	if before != nil {
+		w.add(column+" < ?", *before)
+	}
+}
+
+// addCursor resumes after the cursor's row in the given order
+func (w *sqlWhere) addCursor(o order, c *cursor) error {
+	if c == nil {
+		return nil
+	}
+	v, err := o.value(c)
+	if err != nil {
+		return err
+	}
+	op := ">"
+	if o.desc {
+		op = "<"
+	}
+	w.add("("+o.column+" "+op+" ? OR ("+o.column+" = ? AND id "+op+" ?))", v, v, c.ID)
+	return nil
+}
+
+// orderBy is the ORDER BY clause matching o, with id as a tie breaker
+func (o order) orderBy() string {
+	if o.desc {
+		return o.column + " DESC, id DESC"
+	}
+	return o.column + ", id"
+}
+
+// compare orders two rows by sort value then id, following o's direction
+func (o order) compare(av string, aid int, bv string, bid int) int {
+	cmp := strings.Compare(av, bv)
+	if cmp == 0 {
+		switch {
+		case aid < bid:
+			cmp = -1
+		case aid > bid:
+			cmp = 1
+		}
+	}
+	if o.desc {
+		return -cmp
+	}
+	return cmp
+}
+
+// listSortValue is the value of a list in a sort column, as stored in
+// cursors
+func listSortValue(list *models.ShoppingList, column string) string {
+	switch column {
+	case "created_at":
+		return timeKey(list.CreatedAt)
+	case "name":
+		return list.Name
+	default:
+		return timeKey(list.UpdatedAt)
+	}
+}
+
+// nextListCursor trims the extra row fetched past the page and returns the
+// cursor of the following page, if there is one
+func nextListCursor(lists []models.ShoppingList, o order, sort string, limit int) ([]models.ShoppingList, string) {
+	if len(lists) <= limit {
+		return lists, ""
+	}
+	lists = lists[:limit]
+	last := &lists[limit-1]
+	return lists, encodeCursor(cursor{Sort: sort, Value: listSortValue(last, o.column), ID: last.ID})
+}
+
+// nextHistoryCursor is nextListCursor for history entries
+func nextHistoryCursor(entries []models.ListHistory, sort string, limit int) ([]models.ListHistory, string) {
+	if len(entries) <= limit {
+		return entries, ""
+	}
+	entries = entries[:limit]
+	last := &entries[limit-1]
+	return entries, encodeCursor(cursor{Sort: sort, Value: timeKey(last.CreatedAt), ID: last.ID})
+}
+
+// nextActivityCursor is nextListCursor for activity entries
+func nextActivityCursor(entries []models.ListActivity, sort string, limit int) ([]models.ListActivity, string) {
+	if len(entries) <= limit {
+		return entries, ""
+	}
+	entries = entries[:limit]
+	last := &entries[limit-1]
+	return entries, encodeCursor(cursor{Sort: sort, Value: timeKey(last.CreatedAt), ID: last.ID})
+}
+
+// nextJobCursor is nextListCursor for jobs
+func nextJobCursor(jobs []models.Job, sort string, limit int) ([]models.Job, string) {
+	if len(jobs) <= limit {
+		return jobs, ""
+	}
+	jobs = jobs[:limit]
+	last := &jobs[limit-1]
+	return jobs, encodeCursor(cursor{Sort: sort, Value: timeKey(last.CreatedAt), ID: last.ID})
+}
+
+// likePattern matches values containing s, escaping LIKE wildcards
+func likePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(s))
+	return "%" + s + "%"
+}
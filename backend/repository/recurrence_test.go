@@ -0,0 +1,234 @@
+package repository
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopping-list/backend/db/dbtest"
+	"github.com/shopping-list/backend/models"
+)
+
+// recurrenceRepos are the repositories the recurrence tests write through
+type recurrenceRepos interface {
+	Lists() ListRepository
+	Items() ItemRepository
+	Recurrences() RecurrenceRepository
+}
+
+// eachRecurrenceBackend runs test on every database backend and in
+// memory, with the id of a user. servers returns the repositories of as
+// many servers sharing the same data.
+func eachRecurrenceBackend(t *testing.T, test func(t *testing.T, servers func(n int) []recurrenceRepos, alice int)) {
+	dbtest.Run(t, func(t *testing.T, conn *sql.DB) {
+		servers := func(n int) []recurrenceRepos {
+			repos := make([]recurrenceRepos, n)
+			for i := range repos {
+				repos[i] = NewSQL(conn)
+			}
+			return repos
+		}
+		test(t, servers, dbtest.User(t, conn, "alice@example.com"))
+	})
+	t.Run("memory", func(t *testing.T) {
+		m := NewMemory()
+		servers := func(n int) []recurrenceRepos {
+			repos := make([]recurrenceRepos, n)
+			for i := range repos {
+				repos[i] = m
+			}
+			return repos
+		}
+		test(t, servers, 1001)
+	})
+}
+
+// recurringTemplate creates a template with one item that becomes a new
+// list on rule, starting at start
+func recurringTemplate(t *testing.T, repos recurrenceRepos, userID int, rule string, start time.Time) models.Recurrence {
+	t.Helper()
+	template := models.ShoppingList{UserID: userID, Name: "Coffee run", Kind: models.ListKindTemplate}
+	if err := repos.Lists().Create(&template); err != nil {
+		t.Fatal(err)
+	}
+	coffee := models.ShoppingItem{ListID: template.ID, Name: "Coffee", Quantity: 1}
+	if err := repos.Items().Create(&coffee, userID); err != nil {
+		t.Fatal(err)
+	}
+	rec := models.Recurrence{UserID: userID, TemplateID: &template.ID, Rule: rule, StartsAt: start}
+	if err := repos.Recurrences().Create(&rec); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+// activeLists returns the number of active lists of a user
+func activeLists(t *testing.T, repos recurrenceRepos, userID int) int {
+	t.Helper()
+	lists, _, err := repos.Lists().Visible(userID, nil, ListQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(lists)
+}
+
+func runDue(t *testing.T, repos recurrenceRepos, now time.Time) []RecurrenceRun {
+	t.Helper()
+	runs, err := repos.Recurrences().RunDue(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runs
+}
+
+func TestRunDueIsIdempotent(t *testing.T) {
+	eachRecurrenceBackend(t, func(t *testing.T, servers func(int) []recurrenceRepos, alice int) {
+		repos := servers(1)[0]
+		now := time.Now().UTC().Truncate(time.Second)
+		rec := recurringTemplate(t, repos, alice, "FREQ=DAILY", now)
+
+		if runs := runDue(t, repos, now.Add(-time.Second)); len(runs) != 0 {
+			t.Fatalf("ran %d rules before they were due", len(runs))
+		}
+		runs := runDue(t, repos, now)
+		if len(runs) != 1 || runs[0].Recurrence.ID != rec.ID || len(runs[0].List.Items) != 1 {
+			t.Fatalf("RunDue = %+v, want a list with the coffee", runs)
+		}
+		if next := runs[0].Recurrence.NextRunAt; !next.Equal(now.AddDate(0, 0, 1)) {
+			t.Errorf("next run at %s, want a day later", next)
+		}
+
+		// Running again, as a retried job would, adds nothing until the
+		// next occurrence
+		for _, at := range []time.Time{now, now.Add(time.Hour)} {
+			if runs := runDue(t, repos, at); len(runs) != 0 {
+				t.Fatalf("RunDue at %s ran again: %+v", at, runs)
+			}
+		}
+		if n := activeLists(t, repos, alice); n != 1 {
+			t.Fatalf("%d lists after running twice, want 1", n)
+		}
+		if runs := runDue(t, repos, now.AddDate(0, 0, 1)); len(runs) != 1 {
+			t.Fatalf("next day ran %d rules, want 1", len(runs))
+		}
+		if n := activeLists(t, repos, alice); n != 2 {
+			t.Fatalf("%d lists after the next day, want 2", n)
+		}
+	})
+}
+
+func TestRunDueCatchesUp(t *testing.T) {
+	eachRecurrenceBackend(t, func(t *testing.T, servers func(int) []recurrenceRepos, alice int) {
+		repos := servers(1)[0]
+		now := time.Now().UTC().Truncate(time.Second)
+		start := now.AddDate(0, 0, -10).Add(-time.Hour)
+		recurringTemplate(t, repos, alice, "FREQ=DAILY", start)
+
+		// Ten days of downtime make up a single occurrence, and the rule
+		// carries on from the next one
+		runs := runDue(t, repos, now)
+		if len(runs) != 1 {
+			t.Fatalf("catching up ran %d times, want once", len(runs))
+		}
+		if next := runs[0].Recurrence.NextRunAt; !next.Equal(start.AddDate(0, 0, 11)) {
+			t.Errorf("next run at %s, want %s", next, start.AddDate(0, 0, 11))
+		}
+		if runs := runDue(t, repos, now); len(runs) != 0 {
+			t.Fatalf("ran %d more times after catching up", len(runs))
+		}
+		if n := activeLists(t, repos, alice); n != 1 {
+			t.Fatalf("%d lists after catching up, want 1", n)
+		}
+	})
+}
+
+func TestRunDueRace(t *testing.T) {
+	eachRecurrenceBackend(t, func(t *testing.T, servers func(int) []recurrenceRepos, alice int) {
+		const rules, workers = 10, 4
+		repos := servers(workers)
+		now := time.Now().UTC().Truncate(time.Second)
+		for i := 0; i < rules; i++ {
+			recurringTemplate(t, repos[0], alice, "FREQ=WEEKLY", now.Add(-time.Duration(i)*time.Minute))
+		}
+
+		// Servers running the same rules at once each take different ones
+		var mu sync.Mutex
+		ran := map[int]int{}
+		var wg sync.WaitGroup
+		errs := make(chan error, workers)
+		for _, server := range repos {
+			wg.Add(1)
+			go func(server recurrenceRepos) {
+				defer wg.Done()
+				runs, err := server.Recurrences().RunDue(now)
+				if err != nil {
+					errs <- err
+					return
+				}
+				mu.Lock()
+				for _, run := range runs {
+					ran[run.Recurrence.ID]++
+				}
+				mu.Unlock()
+			}(server)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatal(err)
+		}
+
+		if len(ran) != rules {
+			t.Errorf("ran %d rules, want %d", len(ran), rules)
+		}
+		for id, n := range ran {
+			if n != 1 {
+				t.Errorf("rule %d ran %d times", id, n)
+			}
+		}
+		if n := activeLists(t, repos[0], alice); n != rules {
+			t.Errorf("%d lists, want one for each of the %d rules", n, rules)
+		}
+	})
+}
+
+func TestRunDueTellsCreatedFromMerged(t *testing.T) {
+	eachRecurrenceBackend(t, func(t *testing.T, servers func(int) []recurrenceRepos, alice int) {
+		repos := servers(1)[0]
+		now := time.Now().UTC().Truncate(time.Second)
+		template := models.ShoppingList{UserID: alice, Name: "Breakfast", Kind: models.ListKindTemplate}
+		list := models.ShoppingList{UserID: alice, Name: "Groceries"}
+		for _, l := range []*models.ShoppingList{&template, &list} {
+			if err := repos.Lists().Create(l); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, item := range []models.ShoppingItem{{ListID: template.ID, Name: "Coffee", Quantity: 1}, {ListID: template.ID, Name: "Milk", Quantity: 2}} {
+			if err := repos.Items().Create(&item, alice); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Coffee is on the list already, bought and never edited since
+		coffee := models.ShoppingItem{ListID: list.ID, Name: "Coffee", Quantity: 3, Purchased: true}
+		if err := repos.Items().Create(&coffee, alice); err != nil {
+			t.Fatal(err)
+		}
+		rec := models.Recurrence{UserID: alice, TemplateID: &template.ID, ListID: &list.ID, Rule: "FREQ=DAILY", StartsAt: now}
+		if err := repos.Recurrences().Create(&rec); err != nil {
+			t.Fatal(err)
+		}
+
+		runs := runDue(t, repos, now)
+		if len(runs) != 1 {
+			t.Fatalf("RunDue ran %d rules, want 1", len(runs))
+		}
+		added := runs[0].Added
+		if len(added.Created) != 1 || added.Created[0].Name != "Milk" {
+			t.Errorf("created %+v, want milk", added.Created)
+		}
+		if len(added.Merged) != 1 || added.Merged[0].ID != coffee.ID || added.Merged[0].Purchased {
+			t.Errorf("merged %+v, want coffee needed again", added.Merged)
+		}
+	})
+}
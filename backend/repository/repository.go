@@ -0,0 +1,371 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/shopping-list/backend/models"
+)
+
+var (
+	// ErrNotFound is returned when the requested row does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrVersionMismatch is returned when a conditional write targets a
+	// version that is no longer current
+	ErrVersionMismatch = errors.New("version mismatch")
+	// ErrMergeContention is returned when an item keeps changing while a
+	// patch is being merged
+	ErrMergeContention = errors.New("item changed too often to merge")
+	// ErrInvalidSnapshot is returned when a history entry cannot be reused
+	ErrInvalidSnapshot = errors.New("invalid history snapshot")
+	// ErrNothingToUndo is returned when a list has no change left to undo
+	ErrNothingToUndo = errors.New("nothing to undo")
+	// ErrNothingToRedo is returned when a list has no undone change to redo
+	ErrNothingToRedo = errors.New("nothing to redo")
+	// ErrUndoConflict is returned when the rows a change touched are no
+	// longer in a state it can be reverted from
+	ErrUndoConflict = errors.New("change cannot be reverted")
+	// ErrTemplate is returned when marking a template done
+	ErrTemplate = errors.New("list is a template")
+	// ErrInvalidCarryOver is returned when items cannot be carried over to
+	// the requested list
+	ErrInvalidCarryOver = errors.New("cannot carry items over to that list")
+	// ErrInvalidTarget is returned when items are added to something other
+	// than an active list, such as a template, or when a recurrence has no
+	// active list to add its items to
+	ErrInvalidTarget = errors.New("items need an active list")
+	// ErrJobState is returned when a job is not in a state the operation
+	// applies to
+	ErrJobState = errors.New("job is not in that state")
+	// ErrJobExists is returned when enqueueing a job whose unique key was
+	// already used
+	ErrJobExists = errors.New("job already enqueued")
+)
+
+// Conditional writes take a version pointer: nil applies the write to any
+// version, otherwise the row must still be at that version. Writes that
+// show up in a list's activity feed take the id of the user making them.
+
+// ListRepository stores shopping lists
+type ListRepository interface {
+	// Create inserts a list and fills in its id, version and timestamps
+	Create(list *models.ShoppingList) error
+	// Visible returns a page of the lists, with items, of a user within a
+	// household (or their personal lists when householdID is nil) plus
+	// lists shared with them directly, and the cursor of the next page
+	Visible(userID int, householdID *int, q ListQuery) ([]models.ShoppingList, string, error)
+	// Get returns a list with its items
+	Get(id int) (models.ShoppingList, error)
+	// Rename changes a list's name and returns its new version
+	Rename(id int, name string, version *int, actorID int) (int, error)
+	// Delete removes a list and its items
+	Delete(id int, version *int) error
+	// Copy creates a list of kind c.To with unpurchased copies of the items
+	// of list id, which must be of kind c.From
+	Copy(id int, c ListCopy) (models.ShoppingList, error)
+}
+
+// ItemRepository stores list items
+type ItemRepository interface {
+	// Create inserts an item and fills in its id, version and created_at
+	Create(item *models.ShoppingItem, actorID int) error
+	// Get returns a single item
+	Get(id int) (models.ShoppingItem, error)
+	// Replace overwrites every editable field of an item and refreshes it
+	// from the stored row
+	Replace(item *models.ShoppingItem, version *int, actorID int) error
+	// Merge applies a partial update field by field, see ItemPatch
+	Merge(id int, patch ItemPatch, version *int, actorID int) (ItemMerge, error)
+	// Delete removes an item
+	Delete(id int, version *int, actorID int) error
+}
+
+// HistoryRepository stores completed lists and other history entries
+type HistoryRepository interface {
+	// Visible returns a page of the history of a user within a household
+	// (or their personal history when householdID is nil) and the cursor of
+	// the next page
+	Visible(userID int, householdID *int, q HistoryQuery) ([]models.ListHistory, string, error)
+	// Get returns a single history entry
+	Get(id int) (models.ListHistory, error)
+	// ArchiveList snapshots a list into history and hides it, atomically.
+	// The list is kept until the trash is purged so this can be undone.
+	// With carry set, the items that were not purchased move on to another
+	// list instead of being archived with it.
+	ArchiveList(listID int, actorID int, carry *CarryOver) (models.ListHistory, error)
+	// RestoreList creates a new list from a history snapshot, or adds its
+	// items to an existing one, and records the reuse, atomically. The
+	// returned list holds only the items that were added or merged, which
+	// are also returned apart.
+	RestoreList(historyID, userID int, householdID *int, opts ReuseOptions) (models.ShoppingList, AddedItems, error)
+}
+
+// TrashRepository manages deleted lists and items until they are purged
+type TrashRepository interface {
+	// Visible returns the lists and items in the trash that a user can see
+	// within a household (or their personal ones when householdID is nil),
+	// most recently deleted first
+	Visible(userID int, householdID *int) (models.Trash, error)
+	// RestoreList takes a list out of the trash along with its items
+	RestoreList(id int) (models.ShoppingList, error)
+	// RestoreItem takes an item out of the trash
+	RestoreItem(id int, actorID int) (models.ShoppingItem, error)
+	// PurgeList permanently deletes a list in the trash and its items
+	PurgeList(id int) error
+	// PurgeItem permanently deletes an item in the trash
+	PurgeItem(id int) error
+	// PurgeExpired permanently deletes everything that went into the trash
+	// before the given time and returns how many lists and items it removed
+	PurgeExpired(before time.Time) (int64, error)
+}
+
+// SyncChanges is what changed for a user after a sync cursor. Cursor is
+// where the next sync picks up.
+type SyncChanges struct {
+	Cursor  int64
+	Lists   []models.ShoppingList
+	Items   []models.ShoppingItem
+	Deleted []models.Tombstone
+}
+
+// SyncRepository reads the change feed offline clients sync from
+type SyncRepository interface {
+	// Changes returns the lists and items a user can see within a household
+	// (or their personal ones when householdID is nil) that were created or
+	// changed after the since cursor, and tombstones for those deleted
+	// since. Lists and items in the trash count as deleted. A zero cursor
+	// returns a full snapshot, which has nothing deleted.
+	Changes(userID int, householdID *int, since int64) (SyncChanges, error)
+}
+
+// ActivityRepository reads the activity feed of lists. Entries are written
+// by the list and item repositories as part of each change.
+//
+// The feed doubles as each list's undo stack: Undo reverts the latest
+// change that is not undone yet, Redo reapplies the earliest change undone
+// since the last new change. Changes to items that were purged from the
+// trash or carried over to another list are skipped.
+type ActivityRepository interface {
+	// ForList returns a page of the activity of a list and the cursor of
+	// the next page
+	ForList(listID int, q ActivityQuery) ([]models.ListActivity, string, error)
+	// Undo reverts the latest change to a list and returns its entry
+	Undo(listID, actorID int) (models.ListActivity, error)
+	// Redo reapplies the last undone change to a list and returns its entry
+	Redo(listID, actorID int) (models.ListActivity, error)
+}
+
+// RecurrenceRepository stores recurring templates and items
+type RecurrenceRepository interface {
+	// Create validates a rule, schedules its first run at or after StartsAt
+	// and inserts it
+	Create(rec *models.Recurrence) error
+	// ForUser returns the rules a user created, next to run first
+	ForUser(userID int) ([]models.Recurrence, error)
+	// Get returns a single rule
+	Get(id int) (models.Recurrence, error)
+	// Delete removes a rule
+	Delete(id int) error
+	// RunDue adds the items of every rule due by now to their lists and
+	// schedules each rule's next run after now, in one transaction per rule
+	// so an occurrence is never added twice. Occurrences missed while the
+	// server was down are added once. Items already on the list and not
+	// bought yet are left alone; bought ones are needed again. It returns
+	// the runs that added or changed items.
+	RunDue(now time.Time) ([]RecurrenceRun, error)
+}
+
+// JobRepository is the background job queue. Jobs run at least once: a
+// job whose worker dies is requeued and runs again.
+type JobRepository interface {
+	// Enqueue inserts a pending job that runs at RunAt, or right away when
+	// that is zero, and fills in its id and defaults. A job with the
+	// UniqueKey of an earlier one is left out with ErrJobExists.
+	Enqueue(job *models.Job) error
+	// Claim marks the next job due by now as running and counts the
+	// attempt, skipping jobs other workers are claiming. It returns
+	// ErrNotFound when no job is due.
+	Claim(now time.Time) (models.Job, error)
+	// Complete marks a running job done
+	Complete(id int) error
+	// Fail records why a running job failed and schedules it to run again
+	// at retryAt, or dead-letters it once it has used up its attempts
+	Fail(id int, reason string, retryAt time.Time) (models.Job, error)
+	// Requeue hands back running jobs claimed before the given time, whose
+	// worker must have died, and returns how many it released
+	Requeue(claimedBefore time.Time) (int64, error)
+	// List returns a page of jobs and the cursor of the next page
+	List(q JobQuery) ([]models.Job, string, error)
+	// Get returns a single job
+	Get(id int) (models.Job, error)
+	// Retry runs a dead or pending job right away with its attempts reset
+	Retry(id int) (models.Job, error)
+	// PurgeDone permanently deletes jobs that finished before the given
+	// time and returns how many it removed
+	PurgeDone(before time.Time) (int64, error)
+}
+
+// Item fields tracked for last-writer-wins merging
+const (
+	FieldName      = "name"
+	FieldQuantity  = "quantity"
+	FieldUnit      = "unit"
+	FieldPurchased = "purchased"
+)
+
+// ListCopy describes a copy made by ListRepository.Copy. The copy belongs
+// to UserID within HouseholdID and takes the name of the list it copies
+// unless Name is set.
+type ListCopy struct {
+	From        string
+	To          string
+	Name        string
+	UserID      int
+	HouseholdID *int
+}
+
+// CarryOver says where ArchiveList moves the items that were not purchased:
+// into the active list ListID, or else into a new list named Name that
+// belongs to UserID within HouseholdID
+type CarryOver struct {
+	ListID      *int
+	Name        string
+	UserID      int
+	HouseholdID *int
+}
+
+// ReuseOptions narrows down a reuse. ListID reuses into that active list,
+// where items with the same name and unit as an existing item add to its
+// quantity instead of being added twice. Unpurchased keeps only the items
+// that were not bought and ItemIDs, when set, only the snapshot items
+// listed.
+type ReuseOptions struct {
+	ListID      *int
+	Unpurchased bool
+	ItemIDs     []int
+}
+
+// AddedItems are the items put on a list, told apart by whether they were
+// created or merged into an item already on it
+type AddedItems struct {
+	Created []models.ShoppingItem
+	Merged  []models.ShoppingItem
+}
+
+// RecurrenceRun is a rule run by RecurrenceRepository.RunDue and the list
+// it added to, holding the items that were added or changed. Added tells
+// those apart.
+type RecurrenceRun struct {
+	Recurrence models.Recurrence
+	List       models.ShoppingList
+	Added      AddedItems
+}
+
+// ItemPatch holds the fields a client changed. ChangedAt is when the edit
+// was made on the client, which may be well before it reaches the server
+// for offline edits.
+type ItemPatch struct {
+	Name      *string    `json:"name"`
+	Quantity  *float64   `json:"quantity"`
+	Unit      *string    `json:"unit"`
+	Purchased *bool      `json:"purchased"`
+	ChangedAt *time.Time `json:"changed_at"`
+}
+
+// ItemMerge is the outcome of merging a patch: the resulting item, the
+// fields that were applied and the fields that lost to a newer edit
+type ItemMerge struct {
+	Item    models.ShoppingItem `json:"item"`
+	Applied []string            `json:"applied"`
+	Stale   []string            `json:"stale"`
+}
+
+// allFieldsStamp marks every field as changed at t, used by full updates
+func allFieldsStamp(t time.Time) map[string]time.Time {
+	return map[string]time.Time{
+		FieldName:      t,
+		FieldQuantity:  t,
+		FieldUnit:      t,
+		FieldPurchased: t,
+	}
+}
+
+// patchTime is the time a patch is merged at. Client clocks that run ahead
+// of ours are not trusted.
+func patchTime(patch ItemPatch) time.Time {
+	now := time.Now().UTC()
+	if patch.ChangedAt != nil && patch.ChangedAt.Before(now) {
+		return patch.ChangedAt.UTC()
+	}
+	return now
+}
+
+// scheduleRecurrence checks the shape of a new rule and sets its first run.
+// Rules start now unless told otherwise and run to the second.
+func scheduleRecurrence(rec *models.Recurrence) error {
+	rule, err := models.ParseRule(rec.Rule)
+	if err != nil {
+		return err
+	}
+	if (rec.TemplateID == nil) == (rec.ItemID == nil) {
+		return ErrInvalidTarget
+	}
+	if rec.StartsAt.IsZero() {
+		rec.StartsAt = time.Now()
+	}
+	rec.StartsAt = rec.StartsAt.UTC().Truncate(time.Second)
+	rec.NextRunAt = rule.Next(rec.StartsAt, rec.StartsAt.Add(-time.Nanosecond))
+	return nil
+}
+
+// jobAbandoned is the error recorded for jobs whose worker died
+const jobAbandoned = "worker stopped before the job finished"
+
+// prepareJob fills in the defaults of a new job
+func prepareJob(job *models.Job) {
+	if len(job.Payload) == 0 {
+		job.Payload = json.RawMessage("{}")
+	}
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = models.DefaultJobAttempts
+	}
+	if job.RunAt.IsZero() {
+		job.RunAt = time.Now()
+	}
+	job.RunAt = job.RunAt.UTC()
+	job.Status = models.JobPending
+}
+
+// applyPatch merges a patch into an item: each field is only overwritten if
+// the patch is at least as recent as the last change to that field, so
+// concurrent edits to different fields never clobber each other. stamps is
+// updated in place.
+func applyPatch(item *models.ShoppingItem, stamps map[string]time.Time, patch ItemPatch, changedAt time.Time) ItemMerge {
+	result := ItemMerge{Applied: []string{}, Stale: []string{}}
+	apply := func(field string, set func()) {
+		if last, ok := stamps[field]; ok && changedAt.Before(last) {
+			result.Stale = append(result.Stale, field)
+			return
+		}
+		set()
+		stamps[field] = changedAt
+		result.Applied = append(result.Applied, field)
+	}
+
+	if patch.Name != nil {
+		apply(FieldName, func() { item.Name = *patch.Name })
+	}
+	if patch.Quantity != nil {
+		apply(FieldQuantity, func() { item.Quantity = *patch.Quantity })
+	}
+	if patch.Unit != nil {
+		apply(FieldUnit, func() { item.Unit = *patch.Unit })
+	}
+	if patch.Purchased != nil {
+		apply(FieldPurchased, func() { item.Purchased = *patch.Purchased })
+	}
+
+	result.Item = *item
+	return result
+}
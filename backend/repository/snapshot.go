@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"strings"
+
+	"github.com/shopping-list/backend/models"
+)
+
+// untitledList names the snapshot of a list saved without a name, which
+// older versions allowed
+const untitledList = "Untitled list"
+
+// encodeSnapshot builds and validates the history data recorded when a
+// list is done
+func encodeSnapshot(name string, items []models.SnapshotItem, carriedOverTo *int) (string, error) {
+	if strings.TrimSpace(name) == "" {
+		name = untitledList
+	}
+	if items == nil {
+		items = []models.SnapshotItem{}
+	}
+	data, err := models.EncodeHistory(models.ListSnapshot{Name: name, Items: items, CarriedOverTo: carriedOverTo})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// decodeSnapshot reads the list snapshot of a "created" history entry back
+// into items ready to insert, keeping those opts selects. Items with the
+// same name and unit are merged into one.
+func decodeSnapshot(entry models.ListHistory, opts ReuseOptions) (string, []models.ShoppingItem, error) {
+	payload, err := entry.Payload()
+	snapshot, ok := payload.(*models.ListSnapshot)
+	if err != nil || !ok {
+		return "", nil, ErrInvalidSnapshot
+	}
+
+	selected := map[int]bool{}
+	for _, id := range opts.ItemIDs {
+		selected[id] = true
+	}
+	items := make([]models.ShoppingItem, 0, len(snapshot.Items))
+	merged := map[string]int{}
+	for _, si := range snapshot.Items {
+		if (opts.Unpurchased && si.Purchased) || (opts.ItemIDs != nil && !selected[si.ID]) {
+			continue
+		}
+		key := itemKey(si.Name, si.Unit)
+		if i, ok := merged[key]; ok {
+			items[i].Quantity += si.Quantity
+			continue
+		}
+		merged[key] = len(items)
+		items = append(items, models.ShoppingItem{Name: si.Name, Quantity: si.Quantity, Unit: si.Unit})
+	}
+	return snapshot.Name, items, nil
+}
+
+// itemKey identifies the items that a reuse merges: the same name and unit
+// regardless of case and surrounding spaces
+func itemKey(name, unit string) string {
+	return strings.ToLower(strings.TrimSpace(name)) + "\x00" + strings.ToLower(strings.TrimSpace(unit))
+}
+
+// mergeFunc returns the fields to change when an item is added to a list
+// that already has one with the same name and unit, or nil to leave it be
+type mergeFunc func(existing, added models.ShoppingItem) models.ActivityFields
+
+// mergeReused merges a reused item: the quantities add up and the item
+// needs buying again
+func mergeReused(existing, reused models.ShoppingItem) models.ActivityFields {
+	return models.ActivityFields{
+		FieldQuantity:  existing.Quantity + reused.Quantity,
+		FieldPurchased: false,
+	}
+}
+
+// mergeRecurring merges an item that came round again: one still to be
+// bought is left alone, one already bought is needed again in the
+// recurring quantity
+func mergeRecurring(existing, recurring models.ShoppingItem) models.ActivityFields {
+	if !existing.Purchased {
+		return nil
+	}
+	return models.ActivityFields{
+		FieldQuantity:  recurring.Quantity,
+		FieldPurchased: false,
+	}
+}
+
+// recurringItems returns fresh copies of the items a recurrence adds
+func recurringItems(items []models.ShoppingItem) []models.ShoppingItem {
+	fresh := make([]models.ShoppingItem, len(items))
+	for i, item := range items {
+		fresh[i] = models.ShoppingItem{Name: item.Name, Quantity: item.Quantity, Unit: item.Unit}
+	}
+	return fresh
+}
+
+// carriedOver returns the ids of the items a list marked done carried over
+// and the list they went to, or nil if it carried nothing over
+func carriedOver(entry models.ListHistory) (*int, []int) {
+	payload, err := entry.Payload()
+	snapshot, ok := payload.(*models.ListSnapshot)
+	if err != nil || !ok || snapshot.CarriedOverTo == nil {
+		return nil, nil
+	}
+	var ids []int
+	for _, si := range snapshot.Items {
+		if si.CarriedOver {
+			ids = append(ids, si.ID)
+		}
+	}
+	return snapshot.CarriedOverTo, ids
+}
+
+// encodeReuse builds the history data recorded when a list is reused
+func encodeReuse(historyID, newListID int, existing bool) (string, error) {
+	data, err := models.EncodeHistory(models.ListReuse{OriginalHistoryID: historyID, NewListID: newListID, Existing: existing})
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,1740 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopping-list/backend/db"
+	"github.com/shopping-list/backend/models"
+)
+
+// SQL implements the repositories on top of a Postgres or SQLite database
+type SQL struct {
+	db *sql.DB
+}
+
+// NewSQL creates repositories backed by the given database
+func NewSQL(conn *sql.DB) *SQL {
+	return &SQL{db: conn}
+}
+
+// Lists returns the list repository
+func (s *SQL) Lists() ListRepository { return sqlLists{s.db} }
+
+// Items returns the item repository
+func (s *SQL) Items() ItemRepository { return sqlItems{s.db} }
+
+// History returns the history repository
+func (s *SQL) History() HistoryRepository { return sqlHistory{s.db} }
+
+// Trash returns the trash repository
+func (s *SQL) Trash() TrashRepository { return sqlTrash{s.db} }
+
+// Sync returns the sync change feed
+func (s *SQL) Sync() SyncRepository { return sqlSync{s.db} }
+
+// Activity returns the activity repository
+func (s *SQL) Activity() ActivityRepository { return sqlActivity{s.db} }
+
+// Recurrences returns the recurrence repository
+func (s *SQL) Recurrences() RecurrenceRepository { return sqlRecurrences{s.db} }
+
+// Jobs returns the job queue
+func (s *SQL) Jobs() JobRepository { return sqlJobs{s.db} }
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+const (
+	listColumns = "id, user_id, household_id, name, kind, version, created_at, updated_at, deleted_at"
+	itemColumns = "id, list_id, name, quantity, unit, purchased, version, created_at, deleted_at"
+)
+
+// visibleLists matches the lists of the user in $1 within their active
+// household in $2 (or their personal lists when it is null), plus any
+// lists shared with them directly
+const visibleLists = `(($2::INTEGER IS NULL AND user_id = $1 AND household_id IS NULL)
+	OR household_id = $2::INTEGER
+	OR id IN (SELECT list_id FROM list_members WHERE user_id = $1))`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanList(row scanner, list *models.ShoppingList) error {
+	return row.Scan(&list.ID, &list.UserID, &list.HouseholdID, &list.Name, &list.Kind, &list.Version, &list.CreatedAt, &list.UpdatedAt, &list.DeletedAt)
+}
+
+func scanItem(row scanner, item *models.ShoppingItem) error {
+	return row.Scan(&item.ID, &item.ListID, &item.Name, &item.Quantity, &item.Unit, &item.Purchased, &item.Version, &item.CreatedAt, &item.DeletedAt)
+}
+
+// loadItems returns the items of a list, leaving out those in the trash
+func loadItems(q queryer, listID int) ([]models.ShoppingItem, error) {
+	rows, err := q.Query("SELECT "+itemColumns+" FROM shopping_items WHERE list_id = $1 AND deleted_at IS NULL", listID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.ShoppingItem{}
+	for rows.Next() {
+		var item models.ShoppingItem
+		if err := scanItem(rows, &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// attachItems loads the items of several lists with a single query, leaving
+// out those in the trash
+func attachItems(q queryer, lists []models.ShoppingList) error {
+	if len(lists) == 0 {
+		return nil
+	}
+
+	index := make(map[int]int, len(lists))
+	placeholders := make([]string, len(lists))
+	args := make([]interface{}, len(lists))
+	for i := range lists {
+		lists[i].Items = []models.ShoppingItem{}
+		index[lists[i].ID] = i
+		placeholders[i] = "$" + strconv.Itoa(i+1)
+		args[i] = lists[i].ID
+	}
+
+	rows, err := q.Query(
+		"SELECT "+itemColumns+" FROM shopping_items WHERE list_id IN ("+strings.Join(placeholders, ", ")+") AND deleted_at IS NULL ORDER BY list_id, id",
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item models.ShoppingItem
+		if err := scanItem(rows, &item); err != nil {
+			return err
+		}
+		list := &lists[index[item.ListID]]
+		list.Items = append(list.Items, item)
+	}
+	return rows.Err()
+}
+
+// missingOrStale tells apart the two reasons a conditional write matched
+// no rows. Rows in the trash count as missing.
+func missingOrStale(q queryer, table string, id int) error {
+	var exists bool
+	err := q.QueryRow("SELECT EXISTS (SELECT 1 FROM "+table+" WHERE id = $1 AND deleted_at IS NULL)", id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return ErrVersionMismatch
+}
+
+type sqlLists struct {
+	db *sql.DB
+}
+
+func (r sqlLists) Create(list *models.ShoppingList) error {
+	if list.Kind == "" {
+		list.Kind = models.ListKindList
+	}
+	return r.db.QueryRow(
+		"INSERT INTO shopping_lists (user_id, household_id, name, kind) VALUES ($1, $2, $3, $4) RETURNING id, version, created_at, updated_at",
+		list.UserID, list.HouseholdID, list.Name, list.Kind,
+	).Scan(&list.ID, &list.Version, &list.CreatedAt, &list.UpdatedAt)
+}
+
+func (r sqlLists) Visible(userID int, householdID *int, q ListQuery) ([]models.ShoppingList, string, error) {
+	o, sort, err := parseOrder(q.Sort, defaultListSort, listSorts)
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := decodeCursor(q.Cursor, sort)
+	if err != nil {
+		return nil, "", err
+	}
+
+	w := &sqlWhere{conds: []string{visibleLists, "deleted_at IS NULL"}, args: []interface{}{userID, householdID}}
+	w.add("kind = ?", listKind(q.Kind))
+	if q.Name != "" {
+		w.add(`LOWER(name) LIKE ? ESCAPE '\'`, likePattern(q.Name))
+	}
+	w.addRange("created_at", q.CreatedAfter, q.CreatedBefore)
+	w.addRange("updated_at", q.UpdatedAfter, q.UpdatedBefore)
+	if err := w.addCursor(o, c); err != nil {
+		return nil, "", err
+	}
+	limit := pageLimit(q.Limit)
+
+	rows, err := r.db.Query(
+		"SELECT "+listColumns+" FROM shopping_lists WHERE "+w.String()+" ORDER BY "+o.orderBy()+" LIMIT "+w.arg(limit+1),
+		w.args...,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	lists := []models.ShoppingList{}
+	for rows.Next() {
+		var list models.ShoppingList
+		if err := scanList(rows, &list); err != nil {
+			return nil, "", err
+		}
+		lists = append(lists, list)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	lists, next := nextListCursor(lists, o, sort, limit)
+	if err := attachItems(r.db, lists); err != nil {
+		return nil, "", err
+	}
+	return lists, next, nil
+}
+
+func (r sqlLists) Get(id int) (models.ShoppingList, error) {
+	var list models.ShoppingList
+	err := scanList(r.db.QueryRow("SELECT "+listColumns+" FROM shopping_lists WHERE id = $1 AND deleted_at IS NULL", id), &list)
+	if err == sql.ErrNoRows {
+		return list, ErrNotFound
+	}
+	if err != nil {
+		return list, err
+	}
+
+	list.Items, err = loadItems(r.db, id)
+	return list, err
+}
+
+func (r sqlLists) Rename(id int, name string, version *int, actorID int) (int, error) {
+	var newVersion int
+	err := db.WithTx(r.db, func(tx *sql.Tx) error {
+		var oldName string
+		var current int
+		err := tx.QueryRow("SELECT name, version FROM shopping_lists WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", id).Scan(&oldName, &current)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if version != nil && *version != current {
+			return ErrVersionMismatch
+		}
+
+		err = tx.QueryRow(
+			"UPDATE shopping_lists SET name = $1, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 RETURNING version",
+			name, id,
+		).Scan(&newVersion)
+		if err != nil {
+			return err
+		}
+		if a, ok := listRenamed(id, oldName, name, actorID); ok {
+			return recordActivity(tx, a)
+		}
+		return nil
+	})
+	return newVersion, err
+}
+
+// Delete moves a list to the trash; its items go with it
+func (r sqlLists) Delete(id int, version *int) error {
+	result, err := r.db.Exec(
+		`UPDATE shopping_lists SET deleted_at = CURRENT_TIMESTAMP, version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL AND ($2::INTEGER IS NULL OR version = $2)`,
+		id, version,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return missingOrStale(r.db, "shopping_lists", id)
+	}
+	return nil
+}
+
+func (r sqlLists) Copy(id int, c ListCopy) (models.ShoppingList, error) {
+	var list models.ShoppingList
+	err := db.WithTx(r.db, func(tx *sql.Tx) error {
+		var err error
+		list, err = copyList(tx, id, c)
+		return err
+	})
+	return list, err
+}
+
+func copyList(tx *sql.Tx, id int, c ListCopy) (models.ShoppingList, error) {
+	var list models.ShoppingList
+	var name string
+	err := tx.QueryRow("SELECT name FROM shopping_lists WHERE id = $1 AND kind = $2 AND deleted_at IS NULL", id, c.From).Scan(&name)
+	if err == sql.ErrNoRows {
+		return list, ErrNotFound
+	}
+	if err != nil {
+		return list, err
+	}
+	if c.Name != "" {
+		name = c.Name
+	}
+
+	err = scanList(tx.QueryRow(
+		"INSERT INTO shopping_lists (user_id, household_id, name, kind) VALUES ($1, $2, $3, $4) RETURNING "+listColumns,
+		c.UserID, c.HouseholdID, name, c.To,
+	), &list)
+	if err != nil {
+		return list, err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO shopping_items (list_id, name, quantity, unit) SELECT $1::INTEGER, name, quantity, unit FROM shopping_items WHERE list_id = $2 AND deleted_at IS NULL ORDER BY id",
+		list.ID, id,
+	)
+	if err != nil {
+		return list, err
+	}
+	list.Items, err = loadItems(tx, list.ID)
+	return list, err
+}
+
+// listKind defaults an empty kind to plain lists
+func listKind(kind string) string {
+	if kind == "" {
+		return models.ListKindList
+	}
+	return kind
+}
+
+type sqlItems struct {
+	db *sql.DB
+}
+
+func (r sqlItems) Create(item *models.ShoppingItem, actorID int) error {
+	return db.WithTx(r.db, func(tx *sql.Tx) error {
+		// Lists in the trash take no new items
+		err := tx.QueryRow(
+			`INSERT INTO shopping_items (list_id, name, quantity, unit, purchased)
+			SELECT $1::INTEGER, $2::VARCHAR, $3::DECIMAL, $4::VARCHAR, $5::BOOLEAN WHERE EXISTS (SELECT 1 FROM shopping_lists WHERE id = $1 AND deleted_at IS NULL)
+			RETURNING id, version, created_at`,
+			item.ListID, item.Name, item.Quantity, item.Unit, item.Purchased,
+		).Scan(&item.ID, &item.Version, &item.CreatedAt)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return recordActivity(tx, itemCreated(*item, actorID))
+	})
+}
+
+func (r sqlItems) Get(id int) (models.ShoppingItem, error) {
+	var item models.ShoppingItem
+	err := scanItem(r.db.QueryRow("SELECT "+itemColumns+" FROM shopping_items WHERE id = $1 AND deleted_at IS NULL", id), &item)
+	if err == sql.ErrNoRows {
+		return item, ErrNotFound
+	}
+	return item, err
+}
+
+func (r sqlItems) Replace(item *models.ShoppingItem, version *int, actorID int) error {
+	stamps, _ := json.Marshal(allFieldsStamp(time.Now().UTC()))
+	return db.WithTx(r.db, func(tx *sql.Tx) error {
+		var old models.ShoppingItem
+		err := scanItem(tx.QueryRow("SELECT "+itemColumns+" FROM shopping_items WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", item.ID), &old)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if version != nil && *version != old.Version {
+			return ErrVersionMismatch
+		}
+
+		err = scanItem(tx.QueryRow(
+			`UPDATE shopping_items SET name = $1, quantity = $2, unit = $3, purchased = $4, field_updated_at = $5, version = version + 1
+			WHERE id = $6
+			RETURNING `+itemColumns,
+			item.Name, item.Quantity, item.Unit, item.Purchased, string(stamps), item.ID,
+		), item)
+		if err != nil {
+			return err
+		}
+		if a, ok := itemUpdated(old, *item, actorID); ok {
+			return recordActivity(tx, a)
+		}
+		return nil
+	})
+}
+
+const maxMergeAttempts = 5
+
+func (r sqlItems) Merge(id int, patch ItemPatch, version *int, actorID int) (ItemMerge, error) {
+	changedAt := patchTime(patch)
+
+	for attempt := 0; attempt < maxMergeAttempts; attempt++ {
+		var item models.ShoppingItem
+		var stampJSON []byte
+		err := r.db.QueryRow(
+			"SELECT "+itemColumns+", field_updated_at FROM shopping_items WHERE id = $1 AND deleted_at IS NULL",
+			id,
+		).Scan(&item.ID, &item.ListID, &item.Name, &item.Quantity, &item.Unit, &item.Purchased, &item.Version, &item.CreatedAt, &item.DeletedAt, &stampJSON)
+		if err == sql.ErrNoRows {
+			return ItemMerge{}, ErrNotFound
+		}
+		if err != nil {
+			return ItemMerge{}, err
+		}
+		if version != nil && *version != item.Version {
+			return ItemMerge{Item: item}, ErrVersionMismatch
+		}
+
+		stamps := map[string]time.Time{}
+		if err := json.Unmarshal(stampJSON, &stamps); err != nil {
+			return ItemMerge{}, err
+		}
+
+		old := item
+		result := applyPatch(&item, stamps, patch, changedAt)
+		if len(result.Applied) == 0 {
+			return result, nil
+		}
+
+		newStamps, err := json.Marshal(stamps)
+		if err != nil {
+			return ItemMerge{}, err
+		}
+
+		// Only write if nobody changed the item since we read it; otherwise
+		// re-read and merge again
+		err = db.WithTx(r.db, func(tx *sql.Tx) error {
+			err := tx.QueryRow(
+				`UPDATE shopping_items SET name = $1, quantity = $2, unit = $3, purchased = $4, field_updated_at = $5, version = version + 1
+				WHERE id = $6 AND version = $7
+				RETURNING version`,
+				item.Name, item.Quantity, item.Unit, item.Purchased, string(newStamps), item.ID, item.Version,
+			).Scan(&result.Item.Version)
+			if err != nil {
+				return err
+			}
+			if a, ok := itemUpdated(old, result.Item, actorID); ok {
+				return recordActivity(tx, a)
+			}
+			return nil
+		})
+		if err == sql.ErrNoRows {
+			if version != nil {
+				return ItemMerge{Item: item}, ErrVersionMismatch
+			}
+			continue
+		}
+		if err != nil {
+			return ItemMerge{}, err
+		}
+		return result, nil
+	}
+
+	return ItemMerge{}, ErrMergeContention
+}
+
+// Delete moves an item to the trash
+func (r sqlItems) Delete(id int, version *int, actorID int) error {
+	return db.WithTx(r.db, func(tx *sql.Tx) error {
+		var item models.ShoppingItem
+		err := scanItem(tx.QueryRow(
+			`UPDATE shopping_items SET deleted_at = CURRENT_TIMESTAMP, version = version + 1
+			WHERE id = $1 AND deleted_at IS NULL AND ($2::INTEGER IS NULL OR version = $2)
+			RETURNING `+itemColumns,
+			id, version,
+		), &item)
+		if err == sql.ErrNoRows {
+			return missingOrStale(tx, "shopping_items", id)
+		}
+		if err != nil {
+			return err
+		}
+		return recordActivity(tx, itemDeleted(item, actorID))
+	})
+}
+
+type sqlHistory struct {
+	db *sql.DB
+}
+
+const historyColumns = "id, user_id, household_id, original_list_id, action, data, created_at"
+
+func scanHistory(row scanner, h *models.ListHistory) error {
+	// SQLite hands JSON back as TEXT, which json.RawMessage cannot scan
+	var data []byte
+	if err := row.Scan(&h.ID, &h.UserID, &h.HouseholdID, &h.OriginalListID, &h.Action, &data, &h.CreatedAt); err != nil {
+		return err
+	}
+	h.Data = data
+	return nil
+}
+
+func (r sqlHistory) Visible(userID int, householdID *int, q HistoryQuery) ([]models.ListHistory, string, error) {
+	o, sort, err := parseOrder(q.Sort, defaultHistorySort, historySorts)
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := decodeCursor(q.Cursor, sort)
+	if err != nil {
+		return nil, "", err
+	}
+
+	w := &sqlWhere{
+		conds: []string{"(($2::INTEGER IS NULL AND user_id = $1 AND household_id IS NULL) OR household_id = $2::INTEGER)"},
+		args:  []interface{}{userID, householdID},
+	}
+	if q.Action != "" {
+		w.add("action = ?", q.Action)
+	}
+	w.addRange("created_at", q.CreatedAfter, q.CreatedBefore)
+	if err := w.addCursor(o, c); err != nil {
+		return nil, "", err
+	}
+	limit := pageLimit(q.Limit)
+
+	rows, err := r.db.Query(
+		"SELECT "+historyColumns+" FROM list_history WHERE "+w.String()+" ORDER BY "+o.orderBy()+" LIMIT "+w.arg(limit+1),
+		w.args...,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	history := []models.ListHistory{}
+	for rows.Next() {
+		var h models.ListHistory
+		if err := scanHistory(rows, &h); err != nil {
+			return nil, "", err
+		}
+		history = append(history, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	history, next := nextHistoryCursor(history, sort, limit)
+	return history, next, nil
+}
+
+func (r sqlHistory) Get(id int) (models.ListHistory, error) {
+	return historyEntry(r.db, id)
+}
+
+func historyEntry(q queryer, id int) (models.ListHistory, error) {
+	var h models.ListHistory
+	err := scanHistory(q.QueryRow("SELECT "+historyColumns+" FROM list_history WHERE id = $1", id), &h)
+	if err == sql.ErrNoRows {
+		return h, ErrNotFound
+	}
+	return h, err
+}
+
+func (r sqlHistory) ArchiveList(listID int, actorID int, carry *CarryOver) (models.ListHistory, error) {
+	var entry models.ListHistory
+	err := db.WithTx(r.db, func(tx *sql.Tx) error {
+		var err error
+		if entry, err = archiveList(tx, listID, carry); err != nil {
+			return err
+		}
+		return recordActivity(tx, listDone(listID, entry, actorID))
+	})
+	return entry, err
+}
+
+// archiveList snapshots a list into history and hides it, carrying its
+// unpurchased items over first when asked to
+func archiveList(tx *sql.Tx, listID int, carry *CarryOver) (models.ListHistory, error) {
+	// History is kept by the list's owner and shared with its household.
+	// Lock the row so concurrent edits wait for the list to be archived.
+	var entry models.ListHistory
+	var name, kind string
+	err := tx.QueryRow(
+		"SELECT name, kind, user_id, household_id FROM shopping_lists WHERE id = $1 AND deleted_at IS NULL FOR UPDATE",
+		listID,
+	).Scan(&name, &kind, &entry.UserID, &entry.HouseholdID)
+	if err == sql.ErrNoRows {
+		return entry, ErrNotFound
+	}
+	if err != nil {
+		return entry, err
+	}
+	if kind == models.ListKindTemplate {
+		return entry, ErrTemplate
+	}
+
+	var carriedOverTo *int
+	if carry != nil {
+		to, err := carryOverList(tx, listID, name, carry)
+		if err != nil {
+			return entry, err
+		}
+		carriedOverTo = &to
+	}
+
+	rows, err := tx.Query(
+		"SELECT id, name, quantity, unit, purchased FROM shopping_items WHERE list_id = $1 AND deleted_at IS NULL ORDER BY id",
+		listID,
+	)
+	if err != nil {
+		return entry, err
+	}
+	var items []models.SnapshotItem
+	for rows.Next() {
+		var si models.SnapshotItem
+		var unit sql.NullString
+		if err := rows.Scan(&si.ID, &si.Name, &si.Quantity, &unit, &si.Purchased); err != nil {
+			rows.Close()
+			return entry, err
+		}
+		si.Unit = unit.String
+		si.CarriedOver = carriedOverTo != nil && !si.Purchased
+		items = append(items, si)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return entry, err
+	}
+
+	data, err := encodeSnapshot(name, items, carriedOverTo)
+	if err != nil {
+		return entry, err
+	}
+
+	if carriedOverTo != nil {
+		_, err := tx.Exec(
+			"UPDATE shopping_items SET list_id = $1, version = version + 1 WHERE list_id = $2 AND NOT purchased AND deleted_at IS NULL",
+			*carriedOverTo, listID,
+		)
+		if err != nil {
+			return entry, err
+		}
+	}
+
+	err = scanHistory(tx.QueryRow(
+		"INSERT INTO list_history (user_id, household_id, original_list_id, action, data) VALUES ($1, $2, $3, $4, $5) RETURNING "+historyColumns,
+		entry.UserID, entry.HouseholdID, listID, models.ActionCreated, data,
+	), &entry)
+	if err != nil {
+		return entry, err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE shopping_lists SET deleted_at = CURRENT_TIMESTAMP, archived = TRUE, version = version + 1 WHERE id = $1",
+		listID,
+	)
+	return entry, err
+}
+
+// carryOverList returns the list that items are carried over to, locking an
+// existing one or creating a new one, named like the list marked done
+// unless a name was given
+func carryOverList(tx *sql.Tx, listID int, name string, carry *CarryOver) (int, error) {
+	var id int
+	if carry.ListID != nil {
+		if *carry.ListID == listID {
+			return 0, ErrInvalidCarryOver
+		}
+		err := tx.QueryRow("SELECT id FROM shopping_lists WHERE id = $1 AND kind = $2 AND deleted_at IS NULL FOR UPDATE", *carry.ListID, models.ListKindList).Scan(&id)
+		if err == sql.ErrNoRows {
+			return 0, ErrInvalidCarryOver
+		}
+		return id, err
+	}
+	if carry.Name != "" {
+		name = carry.Name
+	}
+	err := tx.QueryRow(
+		"INSERT INTO shopping_lists (user_id, household_id, name) VALUES ($1, $2, $3) RETURNING id",
+		carry.UserID, carry.HouseholdID, name,
+	).Scan(&id)
+	return id, err
+}
+
+// unarchiveList brings back a list marked done, along with any items it
+// carried over, and drops the history entry it was saved to
+func unarchiveList(tx *sql.Tx, listID, historyID int) error {
+	entry, err := historyEntry(tx, historyID)
+	if errors.Is(err, ErrNotFound) {
+		return ErrUndoConflict
+	}
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(
+		"UPDATE shopping_lists SET deleted_at = NULL, archived = FALSE, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND archived",
+		listID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrUndoConflict
+	}
+	// Items carried over come back unless they were moved on since
+	if to, ids := carriedOver(entry); to != nil {
+		for _, id := range ids {
+			_, err := tx.Exec("UPDATE shopping_items SET list_id = $1, version = version + 1 WHERE id = $2 AND list_id = $3", listID, id, *to)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if err := resendItems(tx, listID); err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM list_history WHERE id = $1", historyID)
+	return err
+}
+
+// resendItems touches the items of a list that comes back from the trash
+// or from being marked done. Clients dropped them along with the list;
+// this sends them out again on the next sync.
+func resendItems(tx *sql.Tx, listID int) error {
+	_, err := tx.Exec("UPDATE shopping_items SET version = version + 1 WHERE list_id = $1 AND deleted_at IS NULL", listID)
+	return err
+}
+
+func (r sqlHistory) RestoreList(historyID, userID int, householdID *int, opts ReuseOptions) (models.ShoppingList, AddedItems, error) {
+	var added AddedItems
+	entry, err := r.Get(historyID)
+	if err != nil {
+		return models.ShoppingList{}, added, err
+	}
+	name, items, err := decodeSnapshot(entry, opts)
+	if err != nil {
+		return models.ShoppingList{}, added, err
+	}
+
+	list := models.ShoppingList{UserID: userID, HouseholdID: householdID, Name: name, Kind: models.ListKindList}
+	err = db.WithTx(r.db, func(tx *sql.Tx) error {
+		var err error
+		if opts.ListID != nil {
+			list, added, err = addItems(tx, *opts.ListID, items, userID, mergeReused)
+		} else {
+			err = reuseAsNew(tx, &list, items)
+			added = AddedItems{Created: list.Items}
+		}
+		if err != nil {
+			return err
+		}
+
+		data, err := encodeReuse(historyID, list.ID, opts.ListID != nil)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			"INSERT INTO list_history (user_id, household_id, original_list_id, action, data) VALUES ($1, $2, $3, $4, $5)",
+			userID, householdID, list.ID, models.ActionReused, data,
+		)
+		return err
+	})
+	return list, added, err
+}
+
+// reuseAsNew creates a list holding the reused items
+func reuseAsNew(tx *sql.Tx, list *models.ShoppingList, items []models.ShoppingItem) error {
+	err := tx.QueryRow(
+		"INSERT INTO shopping_lists (user_id, household_id, name) VALUES ($1, $2, $3) RETURNING id, version, created_at, updated_at",
+		list.UserID, list.HouseholdID, list.Name,
+	).Scan(&list.ID, &list.Version, &list.CreatedAt, &list.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	for i := range items {
+		items[i].ListID = list.ID
+		err := tx.QueryRow(
+			"INSERT INTO shopping_items (list_id, name, quantity, unit) VALUES ($1, $2, $3, $4) RETURNING id, version, created_at",
+			list.ID, items[i].Name, items[i].Quantity, items[i].Unit,
+		).Scan(&items[i].ID, &items[i].Version, &items[i].CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	list.Items = items
+	return nil
+}
+
+// addItems adds items to a list, merging each into the item with the same
+// name and unit if there is one. The returned list holds the items added or
+// changed, which show up in the list's activity.
+func addItems(tx *sql.Tx, listID int, items []models.ShoppingItem, actorID int, merge mergeFunc) (models.ShoppingList, AddedItems, error) {
+	var list models.ShoppingList
+	var added AddedItems
+	err := scanList(tx.QueryRow("SELECT "+listColumns+" FROM shopping_lists WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", listID), &list)
+	if err == sql.ErrNoRows {
+		return list, added, ErrNotFound
+	}
+	if err != nil {
+		return list, added, err
+	}
+	if list.Kind != models.ListKindList {
+		return list, added, ErrInvalidTarget
+	}
+
+	current, err := loadItems(tx, listID)
+	if err != nil {
+		return list, added, err
+	}
+	// Duplicates already on the list merge into the oldest one
+	existing := map[string]models.ShoppingItem{}
+	for _, item := range current {
+		key := itemKey(item.Name, item.Unit)
+		if first, ok := existing[key]; !ok || item.ID < first.ID {
+			existing[key] = item
+		}
+	}
+
+	list.Items = []models.ShoppingItem{}
+	added = AddedItems{Created: []models.ShoppingItem{}, Merged: []models.ShoppingItem{}}
+	for _, item := range items {
+		old, ok := existing[itemKey(item.Name, item.Unit)]
+		if !ok {
+			item.ListID = listID
+			err := tx.QueryRow(
+				"INSERT INTO shopping_items (list_id, name, quantity, unit) VALUES ($1, $2, $3, $4) RETURNING id, version, created_at",
+				listID, item.Name, item.Quantity, item.Unit,
+			).Scan(&item.ID, &item.Version, &item.CreatedAt)
+			if err != nil {
+				return list, added, err
+			}
+			if err := recordActivity(tx, itemCreated(item, actorID)); err != nil {
+				return list, added, err
+			}
+			list.Items = append(list.Items, item)
+			added.Created = append(added.Created, item)
+			continue
+		}
+
+		fields := merge(old, item)
+		if fields == nil {
+			continue
+		}
+		merged, err := setItemFields(tx, old.ID, nil, fields)
+		if err != nil {
+			return list, added, err
+		}
+		if a, ok := itemUpdated(old, merged, actorID); ok {
+			if err := recordActivity(tx, a); err != nil {
+				return list, added, err
+			}
+		}
+		list.Items = append(list.Items, merged)
+		added.Merged = append(added.Merged, merged)
+	}
+	return list, added, nil
+}
+
+type sqlTrash struct {
+	db *sql.DB
+}
+
+func (r sqlTrash) Visible(userID int, householdID *int) (models.Trash, error) {
+	trash := models.Trash{Lists: []models.ShoppingList{}, Items: []models.ShoppingItem{}}
+
+	rows, err := r.db.Query(
+		"SELECT "+listColumns+" FROM shopping_lists WHERE deleted_at IS NOT NULL AND NOT archived AND "+visibleLists+" ORDER BY deleted_at DESC, id DESC",
+		userID, householdID,
+	)
+	if err != nil {
+		return trash, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var list models.ShoppingList
+		if err := scanList(rows, &list); err != nil {
+			return trash, err
+		}
+		trash.Lists = append(trash.Lists, list)
+	}
+	if err := rows.Err(); err != nil {
+		return trash, err
+	}
+	if err := attachItems(r.db, trash.Lists); err != nil {
+		return trash, err
+	}
+
+	itemRows, err := r.db.Query(
+		`SELECT `+itemColumns+` FROM shopping_items
+		WHERE deleted_at IS NOT NULL AND list_id IN (SELECT id FROM shopping_lists WHERE deleted_at IS NULL AND `+visibleLists+`)
+		ORDER BY deleted_at DESC, id DESC`,
+		userID, householdID,
+	)
+	if err != nil {
+		return trash, err
+	}
+	defer itemRows.Close()
+	for itemRows.Next() {
+		var item models.ShoppingItem
+		if err := scanItem(itemRows, &item); err != nil {
+			return trash, err
+		}
+		trash.Items = append(trash.Items, item)
+	}
+	return trash, itemRows.Err()
+}
+
+func (r sqlTrash) RestoreList(id int) (models.ShoppingList, error) {
+	var list models.ShoppingList
+	err := db.WithTx(r.db, func(tx *sql.Tx) error {
+		err := scanList(tx.QueryRow(
+			`UPDATE shopping_lists SET deleted_at = NULL, version = version + 1, updated_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND deleted_at IS NOT NULL AND NOT archived
+			RETURNING `+listColumns,
+			id,
+		), &list)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := resendItems(tx, id); err != nil {
+			return err
+		}
+		list.Items, err = loadItems(tx, id)
+		return err
+	})
+	return list, err
+}
+
+func (r sqlTrash) RestoreItem(id int, actorID int) (models.ShoppingItem, error) {
+	var item models.ShoppingItem
+	err := db.WithTx(r.db, func(tx *sql.Tx) error {
+		err := scanItem(tx.QueryRow(
+			`UPDATE shopping_items SET deleted_at = NULL, version = version + 1
+			WHERE id = $1 AND deleted_at IS NOT NULL
+				AND list_id IN (SELECT id FROM shopping_lists WHERE deleted_at IS NULL)
+			RETURNING `+itemColumns,
+			id,
+		), &item)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return recordActivity(tx, itemRestored(item, actorID))
+	})
+	return item, err
+}
+
+// purge deletes a row if it matches the condition of being in the trash
+func (r sqlTrash) purge(table, trashed string, id int) error {
+	result, err := r.db.Exec("DELETE FROM "+table+" WHERE id = $1 AND "+trashed, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r sqlTrash) PurgeList(id int) error {
+	return r.purge("shopping_lists", "deleted_at IS NOT NULL AND NOT archived", id)
+}
+
+func (r sqlTrash) PurgeItem(id int) error {
+	return r.purge("shopping_items", "deleted_at IS NOT NULL", id)
+}
+
+// PurgeExpired also removes lists marked done, which are only kept so
+// that can be undone
+func (r sqlTrash) PurgeExpired(before time.Time) (int64, error) {
+	var purged int64
+	err := db.WithTx(r.db, func(tx *sql.Tx) error {
+		for _, table := range []string{"shopping_items", "shopping_lists"} {
+			result, err := tx.Exec("DELETE FROM "+table+" WHERE deleted_at < $1", before)
+			if err != nil {
+				return err
+			}
+			n, _ := result.RowsAffected()
+			purged += n
+		}
+		return nil
+	})
+	return purged, err
+}
+
+type sqlSync struct {
+	db *sql.DB
+}
+
+// tombstoneRecipients matches list tombstones of the user in $1 or of their
+// active household in $2. Postgres keeps the list's users in an array,
+// SQLite in a JSON array.
+func tombstoneRecipients(conn *sql.DB) string {
+	if db.IsSQLite(conn) {
+		return "($1 IN (SELECT value FROM json_each(user_ids)) OR household_id = $2::INTEGER)"
+	}
+	return "($1 = ANY(user_ids) OR household_id = $2::INTEGER)"
+}
+
+func (r sqlSync) Changes(userID int, householdID *int, since int64) (SyncChanges, error) {
+	changes := SyncChanges{Cursor: since, Lists: []models.ShoppingList{}, Items: []models.ShoppingItem{}, Deleted: []models.Tombstone{}}
+	trashed := func(typ string, id, listID int, seq int64, at time.Time) {
+		// A full snapshot has nothing to delete on the client
+		if since > 0 {
+			changes.Deleted = append(changes.Deleted, models.Tombstone{Type: typ, ID: id, ListID: listID, ChangeSeq: seq, DeletedAt: at})
+		}
+	}
+
+	listRows, err := r.db.Query(
+		"SELECT "+listColumns+", change_seq FROM shopping_lists WHERE change_seq > $3 AND "+visibleLists+" ORDER BY change_seq",
+		userID, householdID, since,
+	)
+	if err != nil {
+		return SyncChanges{}, err
+	}
+	defer listRows.Close()
+	for listRows.Next() {
+		var list models.ShoppingList
+		if err := listRows.Scan(&list.ID, &list.UserID, &list.HouseholdID, &list.Name, &list.Kind, &list.Version, &list.CreatedAt, &list.UpdatedAt, &list.DeletedAt, &list.ChangeSeq); err != nil {
+			return SyncChanges{}, err
+		}
+		changes.Cursor = max(changes.Cursor, list.ChangeSeq)
+		if list.DeletedAt != nil {
+			trashed("list", list.ID, list.ID, list.ChangeSeq, *list.DeletedAt)
+			continue
+		}
+		changes.Lists = append(changes.Lists, list)
+	}
+	if err := listRows.Err(); err != nil {
+		return SyncChanges{}, err
+	}
+
+	itemRows, err := r.db.Query(
+		`SELECT `+itemColumns+`, change_seq FROM shopping_items
+		WHERE change_seq > $3 AND list_id IN (SELECT id FROM shopping_lists WHERE deleted_at IS NULL AND `+visibleLists+`)
+		ORDER BY change_seq`,
+		userID, householdID, since,
+	)
+	if err != nil {
+		return SyncChanges{}, err
+	}
+	defer itemRows.Close()
+	for itemRows.Next() {
+		var item models.ShoppingItem
+		if err := itemRows.Scan(&item.ID, &item.ListID, &item.Name, &item.Quantity, &item.Unit, &item.Purchased, &item.Version, &item.CreatedAt, &item.DeletedAt, &item.ChangeSeq); err != nil {
+			return SyncChanges{}, err
+		}
+		changes.Cursor = max(changes.Cursor, item.ChangeSeq)
+		if item.DeletedAt != nil {
+			trashed("item", item.ID, item.ListID, item.ChangeSeq, *item.DeletedAt)
+			continue
+		}
+		changes.Items = append(changes.Items, item)
+	}
+	if err := itemRows.Err(); err != nil {
+		return SyncChanges{}, err
+	}
+
+	if since > 0 {
+		tombRows, err := r.db.Query(
+			`SELECT entity_type, entity_id, list_id, change_seq, deleted_at FROM sync_tombstones
+			WHERE change_seq > $3 AND (
+				(entity_type = 'list' AND `+tombstoneRecipients(r.db)+`)
+				OR (entity_type = 'item' AND list_id IN (SELECT id FROM shopping_lists WHERE `+visibleLists+`))
+			)
+			ORDER BY change_seq`,
+			userID, householdID, since,
+		)
+		if err != nil {
+			return SyncChanges{}, err
+		}
+		defer tombRows.Close()
+		for tombRows.Next() {
+			var t models.Tombstone
+			if err := tombRows.Scan(&t.Type, &t.ID, &t.ListID, &t.ChangeSeq, &t.DeletedAt); err != nil {
+				return SyncChanges{}, err
+			}
+			changes.Cursor = max(changes.Cursor, t.ChangeSeq)
+			changes.Deleted = append(changes.Deleted, t)
+		}
+		if err := tombRows.Err(); err != nil {
+			return SyncChanges{}, err
+		}
+	}
+	sort.Slice(changes.Deleted, func(i, j int) bool { return changes.Deleted[i].ChangeSeq < changes.Deleted[j].ChangeSeq })
+	return changes, nil
+}
+
+type sqlActivity struct {
+	db *sql.DB
+}
+
+const activityColumns = "id, list_id, item_id, user_id, action, before_fields, after_fields, created_at, undone_at, undone_by"
+
+// fieldsJSON encodes activity fields for storage, leaving absent ones NULL
+func fieldsJSON(f models.ActivityFields) (interface{}, error) {
+	if f == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// recordActivity adds an entry to a list's activity feed as part of the
+// change it describes
+func recordActivity(tx *sql.Tx, a models.ListActivity) error {
+	before, err := fieldsJSON(a.Before)
+	if err != nil {
+		return err
+	}
+	after, err := fieldsJSON(a.After)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO list_activity (list_id, item_id, user_id, action, before_fields, after_fields) VALUES ($1, $2, $3, $4, $5, $6)",
+		a.ListID, a.ItemID, a.UserID, a.Action, before, after,
+	)
+	if err != nil {
+		return err
+	}
+	// A new change discards whatever could be redone
+	_, err = tx.Exec("UPDATE list_activity SET redoable = FALSE WHERE list_id = $1 AND redoable", a.ListID)
+	return err
+}
+
+func scanActivity(row scanner, a *models.ListActivity) error {
+	var before, after []byte
+	if err := row.Scan(&a.ID, &a.ListID, &a.ItemID, &a.UserID, &a.Action, &before, &after, &a.CreatedAt, &a.UndoneAt, &a.UndoneBy); err != nil {
+		return err
+	}
+	if before != nil {
+		if err := json.Unmarshal(before, &a.Before); err != nil {
+			return err
+		}
+	}
+	if after != nil {
+		return json.Unmarshal(after, &a.After)
+	}
+	return nil
+}
+
+func (r sqlActivity) ForList(listID int, q ActivityQuery) ([]models.ListActivity, string, error) {
+	o, sort, err := parseOrder(q.Sort, defaultActivitySort, activitySorts)
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := decodeCursor(q.Cursor, sort)
+	if err != nil {
+		return nil, "", err
+	}
+
+	w := &sqlWhere{conds: []string{"list_id = $1"}, args: []interface{}{listID}}
+	if q.Action != "" {
+		w.add("action = ?", q.Action)
+	}
+	w.addRange("created_at", q.CreatedAfter, q.CreatedBefore)
+	if err := w.addCursor(o, c); err != nil {
+		return nil, "", err
+	}
+	limit := pageLimit(q.Limit)
+
+	rows, err := r.db.Query(
+		"SELECT "+activityColumns+" FROM list_activity WHERE "+w.String()+" ORDER BY "+o.orderBy()+" LIMIT "+w.arg(limit+1),
+		w.args...,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	activity := []models.ListActivity{}
+	for rows.Next() {
+		var a models.ListActivity
+		if err := scanActivity(rows, &a); err != nil {
+			return nil, "", err
+		}
+		activity = append(activity, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	activity, next := nextActivityCursor(activity, sort, limit)
+	return activity, next, nil
+}
+
+func (r sqlActivity) Undo(listID, actorID int) (models.ListActivity, error) {
+	return r.step(listID, actorID, true)
+}
+
+func (r sqlActivity) Redo(listID, actorID int) (models.ListActivity, error) {
+	return r.step(listID, actorID, false)
+}
+
+// undoableActivity matches the activity of the list in $1 that can still
+// be reverted: changes to items purged from the trash or carried over to
+// another list cannot
+const undoableActivity = "list_id = $1 AND (item_id IS NULL OR item_id IN (SELECT id FROM shopping_items WHERE list_id = $1))"
+
+// step undoes or redoes one change to a list
+func (r sqlActivity) step(listID, actorID int, undo bool) (models.ListActivity, error) {
+	var a models.ListActivity
+	err := db.WithTx(r.db, func(tx *sql.Tx) error {
+		// Lock the list so concurrent undos take turns
+		var id int
+		err := tx.QueryRow("SELECT id FROM shopping_lists WHERE id = $1 FOR UPDATE", listID).Scan(&id)
+		if err == sql.ErrNoRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		query, nothing := "SELECT "+activityColumns+" FROM list_activity WHERE "+undoableActivity+" AND undone_at IS NULL ORDER BY id DESC LIMIT 1", ErrNothingToUndo
+		if !undo {
+			query, nothing = "SELECT "+activityColumns+" FROM list_activity WHERE "+undoableActivity+" AND redoable ORDER BY id LIMIT 1", ErrNothingToRedo
+		}
+		err = scanActivity(tx.QueryRow(query, listID), &a)
+		if err == sql.ErrNoRows {
+			return nothing
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := revertActivity(tx, &a, undo); err != nil {
+			return err
+		}
+		if !undo {
+			a.UndoneAt, a.UndoneBy = nil, nil
+			_, err := tx.Exec("UPDATE list_activity SET undone_at = NULL, undone_by = NULL, redoable = FALSE WHERE id = $1", a.ID)
+			return err
+		}
+		return tx.QueryRow(
+			"UPDATE list_activity SET undone_at = CURRENT_TIMESTAMP, undone_by = $2, redoable = TRUE WHERE id = $1 RETURNING undone_at, undone_by",
+			a.ID, actorID,
+		).Scan(&a.UndoneAt, &a.UndoneBy)
+	})
+	return a, err
+}
+
+// revertActivity applies a change backwards when undoing it or forwards
+// again when redoing it
+func revertActivity(tx *sql.Tx, a *models.ListActivity, undo bool) error {
+	fields, current := a.After, a.Before
+	if undo {
+		fields, current = a.Before, a.After
+	}
+
+	switch a.Action {
+	case models.ActivityItemCreated, models.ActivityItemRestored:
+		return trashItem(tx, *a.ItemID, undo)
+	case models.ActivityItemDeleted:
+		return trashItem(tx, *a.ItemID, !undo)
+	case models.ActivityItemUpdated:
+		_, err := setItemFields(tx, *a.ItemID, current, fields)
+		return err
+	case models.ActivityListRenamed:
+		name, _ := fields["name"].(string)
+		result, err := tx.Exec(
+			"UPDATE shopping_lists SET name = $1, version = version + 1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND deleted_at IS NULL",
+			name, a.ListID,
+		)
+		return undoResult(result, err)
+	case models.ActivityListDone:
+		if undo {
+			return unarchiveList(tx, a.ListID, afterID(*a, "history_id"))
+		}
+		// Marking the list done again saves it to a new history entry
+		entry, err := archiveList(tx, a.ListID, redoCarryOver(*a))
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidCarryOver) {
+			return ErrUndoConflict
+		}
+		if err != nil {
+			return err
+		}
+		a.After = listDone(a.ListID, entry, a.UserID).After
+		after, err := fieldsJSON(a.After)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE list_activity SET after_fields = $1 WHERE id = $2", after, a.ID)
+		return err
+	}
+	return ErrUndoConflict
+}
+
+// undoResult turns an update that matched no rows into ErrUndoConflict
+func undoResult(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrUndoConflict
+	}
+	return nil
+}
+
+// trashItem moves an item into or out of the trash
+func trashItem(tx *sql.Tx, itemID int, trashed bool) error {
+	query := "UPDATE shopping_items SET deleted_at = NULL, version = version + 1 WHERE id = $1 AND deleted_at IS NOT NULL"
+	if trashed {
+		query = "UPDATE shopping_items SET deleted_at = CURRENT_TIMESTAMP, version = version + 1 WHERE id = $1 AND deleted_at IS NULL"
+	}
+	result, err := tx.Exec(query, itemID)
+	return undoResult(result, err)
+}
+
+// setItemFields writes back recorded item fields, stamping them as just
+// changed so the write wins over older queued edits, and returns the item.
+// An item that no longer holds the current fields is an ErrUndoConflict.
+func setItemFields(tx *sql.Tx, itemID int, current, fields models.ActivityFields) (models.ShoppingItem, error) {
+	var item models.ShoppingItem
+	var stampJSON []byte
+	err := tx.QueryRow(
+		"SELECT "+itemColumns+", field_updated_at FROM shopping_items WHERE id = $1 AND deleted_at IS NULL FOR UPDATE",
+		itemID,
+	).Scan(&item.ID, &item.ListID, &item.Name, &item.Quantity, &item.Unit, &item.Purchased, &item.Version, &item.CreatedAt, &item.DeletedAt, &stampJSON)
+	if err == sql.ErrNoRows {
+		return item, ErrUndoConflict
+	}
+	if err != nil {
+		return item, err
+	}
+	if !holdsFields(item, current) {
+		return item, ErrUndoConflict
+	}
+
+	stamps := map[string]time.Time{}
+	if err := json.Unmarshal(stampJSON, &stamps); err != nil {
+		return item, err
+	}
+	now := time.Now().UTC()
+	for _, field := range applyFields(&item, fields) {
+		stamps[field] = now
+	}
+	newStamps, err := json.Marshal(stamps)
+	if err != nil {
+		return item, err
+	}
+
+	_, err = tx.Exec(
+		"UPDATE shopping_items SET name = $1, quantity = $2, unit = $3, purchased = $4, field_updated_at = $5, version = version + 1 WHERE id = $6",
+		item.Name, item.Quantity, item.Unit, item.Purchased, string(newStamps), item.ID,
+	)
+	item.Version++
+	return item, err
+}
+
+type sqlRecurrences struct {
+	db *sql.DB
+}
+
+const recurrenceColumns = "id, user_id, template_id, item_id, list_id, rule, starts_at, next_run_at, last_run_at, created_at"
+
+func scanRecurrence(row scanner, rec *models.Recurrence) error {
+	return row.Scan(&rec.ID, &rec.UserID, &rec.TemplateID, &rec.ItemID, &rec.ListID, &rec.Rule, &rec.StartsAt, &rec.NextRunAt, &rec.LastRunAt, &rec.CreatedAt)
+}
+
+// activeList reports whether a list is live and of kind list, so items can
+// be added to it
+func activeList(q queryer, id int) (bool, error) {
+	var ok bool
+	err := q.QueryRow("SELECT EXISTS (SELECT 1 FROM shopping_lists WHERE id = $1 AND kind = $2 AND deleted_at IS NULL)", id, models.ListKindList).Scan(&ok)
+	return ok, err
+}
+
+func (r sqlRecurrences) Create(rec *models.Recurrence) error {
+	if err := scheduleRecurrence(rec); err != nil {
+		return err
+	}
+	return db.WithTx(r.db, func(tx *sql.Tx) error {
+		if rec.TemplateID != nil {
+			var exists bool
+			err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM shopping_lists WHERE id = $1 AND kind = $2 AND deleted_at IS NULL)", *rec.TemplateID, models.ListKindTemplate).Scan(&exists)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return ErrNotFound
+			}
+		} else {
+			var kind string
+			err := tx.QueryRow(
+				"SELECT l.kind FROM shopping_items i JOIN shopping_lists l ON l.id = i.list_id WHERE i.id = $1 AND i.deleted_at IS NULL AND l.deleted_at IS NULL",
+				*rec.ItemID,
+			).Scan(&kind)
+			if err == sql.ErrNoRows {
+				return ErrNotFound
+			}
+			if err != nil {
+				return err
+			}
+			// An item on a template has no list of its own to go back to
+			if kind != models.ListKindList && rec.ListID == nil {
+				return ErrInvalidTarget
+			}
+		}
+		if rec.ListID != nil {
+			ok, err := activeList(tx, *rec.ListID)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return ErrInvalidTarget
+			}
+		}
+
+		return tx.QueryRow(
+			"INSERT INTO recurrences (user_id, template_id, item_id, list_id, rule, starts_at, next_run_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at",
+			rec.UserID, rec.TemplateID, rec.ItemID, rec.ListID, rec.Rule, rec.StartsAt, rec.NextRunAt,
+		).Scan(&rec.ID, &rec.CreatedAt)
+	})
+}
+
+func (r sqlRecurrences) ForUser(userID int) ([]models.Recurrence, error) {
+	rows, err := r.db.Query("SELECT "+recurrenceColumns+" FROM recurrences WHERE user_id = $1 ORDER BY next_run_at, id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recs := []models.Recurrence{}
+	for rows.Next() {
+		var rec models.Recurrence
+		if err := scanRecurrence(rows, &rec); err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+	return recs, rows.Err()
+}
+
+func (r sqlRecurrences) Get(id int) (models.Recurrence, error) {
+	var rec models.Recurrence
+	err := scanRecurrence(r.db.QueryRow("SELECT "+recurrenceColumns+" FROM recurrences WHERE id = $1", id), &rec)
+	if err == sql.ErrNoRows {
+		return rec, ErrNotFound
+	}
+	return rec, err
+}
+
+func (r sqlRecurrences) Delete(id int) error {
+	result, err := r.db.Exec("DELETE FROM recurrences WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r sqlRecurrences) RunDue(now time.Time) ([]RecurrenceRun, error) {
+	now = now.UTC()
+	rows, err := r.db.Query("SELECT id FROM recurrences WHERE next_run_at <= $1 ORDER BY next_run_at, id", now)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	runs := []RecurrenceRun{}
+	var errs []error
+	for _, id := range ids {
+		var run RecurrenceRun
+		var ran bool
+		err := db.WithTx(r.db, func(tx *sql.Tx) error {
+			// Rules another server is running right now are left to it
+			rec := &run.Recurrence
+			err := scanRecurrence(tx.QueryRow("SELECT "+recurrenceColumns+" FROM recurrences WHERE id = $1 AND next_run_at <= $2 FOR UPDATE SKIP LOCKED", id, now), rec)
+			if err == sql.ErrNoRows {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			rule, err := models.ParseRule(rec.Rule)
+			if err != nil {
+				return err
+			}
+			ran, err = runRecurrence(tx, &run)
+			if err != nil {
+				return err
+			}
+
+			rec.NextRunAt = rule.Next(rec.StartsAt, now)
+			rec.LastRunAt = &now
+			_, err = tx.Exec("UPDATE recurrences SET next_run_at = $1, last_run_at = $2 WHERE id = $3", rec.NextRunAt, now, rec.ID)
+			return err
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("recurrence %d: %w", id, err))
+			continue
+		}
+		if ran {
+			runs = append(runs, run)
+		}
+	}
+	return runs, errors.Join(errs...)
+}
+
+// runRecurrence adds the items of the rule of run to the list they go to,
+// filling in run, and reports whether it added anything. Rules whose
+// template or item is in the trash, or that have no active list left to go
+// to, are skipped.
+func runRecurrence(tx *sql.Tx, run *RecurrenceRun) (bool, error) {
+	rec := run.Recurrence
+	var target *int
+	if rec.ListID != nil {
+		ok, err := activeList(tx, *rec.ListID)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			target = rec.ListID
+		}
+	}
+
+	var items []models.ShoppingItem
+	if rec.TemplateID != nil {
+		var householdID *int
+		err := tx.QueryRow("SELECT household_id FROM shopping_lists WHERE id = $1 AND kind = $2 AND deleted_at IS NULL", *rec.TemplateID, models.ListKindTemplate).Scan(&householdID)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if target == nil {
+			list, err := copyList(tx, *rec.TemplateID, ListCopy{From: models.ListKindTemplate, To: models.ListKindList, UserID: rec.UserID, HouseholdID: householdID})
+			if err != nil {
+				return false, err
+			}
+			run.List = list
+			run.Added = AddedItems{Created: list.Items, Merged: []models.ShoppingItem{}}
+			return true, nil
+		}
+		if items, err = loadItems(tx, *rec.TemplateID); err != nil {
+			return false, err
+		}
+	} else {
+		var item models.ShoppingItem
+		err := tx.QueryRow("SELECT list_id, name, quantity, unit FROM shopping_items WHERE id = $1 AND deleted_at IS NULL", *rec.ItemID).Scan(&item.ListID, &item.Name, &item.Quantity, &item.Unit)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if target == nil {
+			ok, err := activeList(tx, item.ListID)
+			if err != nil || !ok {
+				return false, err
+			}
+			target = &item.ListID
+		}
+		items = []models.ShoppingItem{item}
+	}
+
+	var err error
+	run.List, run.Added, err = addItems(tx, *target, recurringItems(items), rec.UserID, mergeRecurring)
+	return err == nil && len(run.List.Items) > 0, err
+}
+
+type sqlJobs struct {
+	db *sql.DB
+}
+
+const jobColumns = "id, kind, payload, unique_key, status, attempts, max_attempts, run_at, locked_at, last_error, created_at, updated_at"
+
+func scanJob(row scanner, job *models.Job) error {
+	// SQLite hands JSON back as TEXT, which json.RawMessage cannot scan
+	var payload []byte
+	err := row.Scan(&job.ID, &job.Kind, &payload, &job.UniqueKey, &job.Status, &job.Attempts, &job.MaxAttempts, &job.RunAt, &job.LockedAt, &job.LastError, &job.CreatedAt, &job.UpdatedAt)
+	job.Payload = payload
+	return err
+}
+
+// scanJobRow scans a job returned by a write, which matched no row when the
+// job is missing or not in the state the write expects
+func scanJobRow(q queryer, row *sql.Row, id int) (models.Job, error) {
+	var job models.Job
+	err := scanJob(row, &job)
+	if err != sql.ErrNoRows {
+		return job, err
+	}
+	var exists bool
+	if err := q.QueryRow("SELECT EXISTS (SELECT 1 FROM jobs WHERE id = $1)", id).Scan(&exists); err != nil {
+		return job, err
+	}
+	if !exists {
+		return job, ErrNotFound
+	}
+	return job, ErrJobState
+}
+
+func (r sqlJobs) Enqueue(job *models.Job) error {
+	prepareJob(job)
+	err := scanJob(r.db.QueryRow(
+		`INSERT INTO jobs (kind, payload, unique_key, max_attempts, run_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (unique_key) DO NOTHING
+		RETURNING `+jobColumns,
+		job.Kind, string(job.Payload), job.UniqueKey, job.MaxAttempts, job.RunAt,
+	), job)
+	if err == sql.ErrNoRows {
+		return ErrJobExists
+	}
+	return err
+}
+
+func (r sqlJobs) Claim(now time.Time) (models.Job, error) {
+	var job models.Job
+	now = now.UTC()
+	err := scanJob(r.db.QueryRow(
+		`UPDATE jobs SET status = $1, attempts = attempts + 1, locked_at = $2, updated_at = CURRENT_TIMESTAMP
+		WHERE id = (SELECT id FROM jobs WHERE status = $3 AND run_at <= $2 ORDER BY run_at, id LIMIT 1 FOR UPDATE SKIP LOCKED)
+		RETURNING `+jobColumns,
+		models.JobRunning, now, models.JobPending,
+	), &job)
+	if err == sql.ErrNoRows {
+		return job, ErrNotFound
+	}
+	return job, err
+}
+
+func (r sqlJobs) Complete(id int) error {
+	_, err := scanJobRow(r.db, r.db.QueryRow(
+		"UPDATE jobs SET status = $1, locked_at = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $2 AND status = $3 RETURNING "+jobColumns,
+		models.JobDone, id, models.JobRunning,
+	), id)
+	return err
+}
+
+func (r sqlJobs) Fail(id int, reason string, retryAt time.Time) (models.Job, error) {
+	return scanJobRow(r.db, r.db.QueryRow(
+		`UPDATE jobs SET status = CASE WHEN attempts >= max_attempts THEN $1 ELSE $2 END,
+			run_at = $3, locked_at = NULL, last_error = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $5 AND status = $6 RETURNING `+jobColumns,
+		models.JobDead, models.JobPending, retryAt.UTC(), reason, id, models.JobRunning,
+	), id)
+}
+
+func (r sqlJobs) Requeue(claimedBefore time.Time) (int64, error) {
+	result, err := r.db.Exec(
+		`UPDATE jobs SET status = CASE WHEN attempts >= max_attempts THEN $1 ELSE $2 END,
+			locked_at = NULL, last_error = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE status = $4 AND locked_at < $5`,
+		models.JobDead, models.JobPending, jobAbandoned, models.JobRunning, claimedBefore.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (r sqlJobs) List(q JobQuery) ([]models.Job, string, error) {
+	o, sort, err := parseOrder(q.Sort, defaultJobSort, jobSorts)
+	if err != nil {
+		return nil, "", err
+	}
+	c, err := decodeCursor(q.Cursor, sort)
+	if err != nil {
+		return nil, "", err
+	}
+
+	w := &sqlWhere{}
+	if q.Status != "" {
+		w.add("status = ?", q.Status)
+	}
+	if q.Kind != "" {
+		w.add("kind = ?", q.Kind)
+	}
+	if err := w.addCursor(o, c); err != nil {
+		return nil, "", err
+	}
+	limit := pageLimit(q.Limit)
+
+	rows, err := r.db.Query(
+		"SELECT "+jobColumns+" FROM jobs WHERE "+w.String()+" ORDER BY "+o.orderBy()+" LIMIT "+w.arg(limit+1),
+		w.args...,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	jobs := []models.Job{}
+	for rows.Next() {
+		var job models.Job
+		if err := scanJob(rows, &job); err != nil {
+			return nil, "", err
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	jobs, next := nextJobCursor(jobs, sort, limit)
+	return jobs, next, nil
+}
+
+func (r sqlJobs) Get(id int) (models.Job, error) {
+	var job models.Job
+	err := scanJob(r.db.QueryRow("SELECT "+jobColumns+" FROM jobs WHERE id = $1", id), &job)
+	if err == sql.ErrNoRows {
+		return job, ErrNotFound
+	}
+	return job, err
+}
+
+func (r sqlJobs) Retry(id int) (models.Job, error) {
+	return scanJobRow(r.db, r.db.QueryRow(
+		"UPDATE jobs SET status = $1, attempts = 0, run_at = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3 AND status IN ($4, $1) RETURNING "+jobColumns,
+		models.JobPending, time.Now().UTC(), id, models.JobDead,
+	), id)
+}
+
+func (r sqlJobs) PurgeDone(before time.Time) (int64, error) {
+	result, err := r.db.Exec("DELETE FROM jobs WHERE status = $1 AND updated_at < $2", models.JobDone, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
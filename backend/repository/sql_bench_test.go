@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/shopping-list/backend/db"
+	"github.com/shopping-list/backend/models"
+)
+
+const (
+	benchLists        = 50
+	benchItemsPerList = 20
+)
+
+// seedBenchDB creates a user with benchLists lists of benchItemsPerList
+// items each in a fresh in-memory SQLite database
+func seedBenchDB(b *testing.B) (*sql.DB, int) {
+	b.Helper()
+	conn, err := db.InitDB("sqlite::memory:")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { conn.Close() })
+	if err := db.RunMigrations(conn); err != nil {
+		b.Fatal(err)
+	}
+
+	var userID int
+	err = conn.QueryRow("INSERT INTO users (email, password) VALUES ('bench@example.com', 'x') RETURNING id").Scan(&userID)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	lists := NewSQL(conn).Lists()
+	items := NewSQL(conn).Items()
+	for l := 0; l < benchLists; l++ {
+		list := models.ShoppingList{UserID: userID, Name: fmt.Sprintf("List %d", l)}
+		if err := lists.Create(&list); err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < benchItemsPerList; i++ {
+			item := models.ShoppingItem{ListID: list.ID, Name: fmt.Sprintf("Item %d", i), Quantity: 1}
+			if err := items.Create(&item, userID); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	return conn, userID
+}
+
+// BenchmarkVisibleLists loads every list with its items in two queries
+func BenchmarkVisibleLists(b *testing.B) {
+	conn, userID := seedBenchDB(b)
+	lists := NewSQL(conn).Lists()
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, _, err := lists.Visible(userID, nil, ListQuery{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkVisibleListsPerList is the previous approach of one item query
+// per list, kept as a baseline
+func BenchmarkVisibleListsPerList(b *testing.B) {
+	conn, userID := seedBenchDB(b)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := perListVisible(conn, userID, nil, models.ListKindList); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,616 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopping-list/backend/db/dbtest"
+	"github.com/shopping-list/backend/models"
+)
+
+// The tests in this file run against every database backend, see dbtest
+
+// eachBackend runs test against the SQL repositories on a fresh database
+// of each backend
+func eachBackend(t *testing.T, test func(t *testing.T, conn *sql.DB, repo *SQL)) {
+	dbtest.Run(t, func(t *testing.T, conn *sql.DB) {
+		test(t, conn, NewSQL(conn))
+	})
+}
+
+// newList creates a list with one unpurchased item of each name
+func newList(t *testing.T, repo *SQL, userID int, householdID *int, name string, items ...string) models.ShoppingList {
+	t.Helper()
+	list := models.ShoppingList{UserID: userID, HouseholdID: householdID, Name: name}
+	if err := repo.Lists().Create(&list); err != nil {
+		t.Fatal(err)
+	}
+	for _, itemName := range items {
+		item := models.ShoppingItem{ListID: list.ID, Name: itemName, Quantity: 1}
+		if err := repo.Items().Create(&item, userID); err != nil {
+			t.Fatal(err)
+		}
+		list.Items = append(list.Items, item)
+	}
+	return list
+}
+
+// itemNames returns the names of items, as a set
+func itemNames(items []models.ShoppingItem) map[string]bool {
+	names := map[string]bool{}
+	for _, item := range items {
+		names[item.Name] = true
+	}
+	return names
+}
+
+// listNames returns the names of lists in order
+func listNames(lists []models.ShoppingList) []string {
+	names := []string{}
+	for _, list := range lists {
+		names = append(names, list.Name)
+	}
+	return names
+}
+
+func sameNames(got []string, want ...string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestListLifecycle(t *testing.T) {
+	eachBackend(t, func(t *testing.T, conn *sql.DB, repo *SQL) {
+		alice := dbtest.User(t, conn, "alice@example.com")
+		lists := repo.Lists()
+
+		list := newList(t, repo, alice, nil, "Groceries", "Milk")
+		got, err := lists.Get(list.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != "Groceries" || got.Kind != models.ListKindList || got.Version != 1 || len(got.Items) != 1 {
+			t.Fatalf("Get = %+v", got)
+		}
+
+		v1 := 1
+		version, err := lists.Rename(list.ID, "Weekly", &v1, alice)
+		if err != nil || version != 2 {
+			t.Fatalf("Rename = %d, %v; want 2", version, err)
+		}
+		if _, err := lists.Rename(list.ID, "Stale", &v1, alice); !errors.Is(err, ErrVersionMismatch) {
+			t.Fatalf("Rename at a stale version = %v, want ErrVersionMismatch", err)
+		}
+		if err := lists.Delete(list.ID, &v1); !errors.Is(err, ErrVersionMismatch) {
+			t.Fatalf("Delete at a stale version = %v, want ErrVersionMismatch", err)
+		}
+
+		if err := lists.Delete(list.ID, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := lists.Get(list.ID); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Get after delete = %v, want ErrNotFound", err)
+		}
+		if _, err := lists.Rename(list.ID, "Gone", nil, alice); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Rename after delete = %v, want ErrNotFound", err)
+		}
+		if err := lists.Delete(list.ID, nil); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Delete twice = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestVisibleLists(t *testing.T) {
+	eachBackend(t, func(t *testing.T, conn *sql.DB, repo *SQL) {
+		alice := dbtest.User(t, conn, "alice@example.com")
+		bob := dbtest.User(t, conn, "bob@example.com")
+		home := dbtest.Household(t, conn, "Home", alice, bob)
+
+		newList(t, repo, alice, nil, "Alice groceries", "Milk", "Bread")
+		newList(t, repo, bob, nil, "Bob hardware")
+		newList(t, repo, bob, &home, "Home groceries", "Eggs")
+		shared := newList(t, repo, bob, nil, "Bob party")
+		dbtest.Share(t, conn, shared.ID, alice, "viewer")
+		template := models.ShoppingList{UserID: alice, Name: "Weekly", Kind: models.ListKindTemplate}
+		if err := repo.Lists().Create(&template); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name        string
+			householdID *int
+			q           ListQuery
+			want        []string
+		}{
+			{"personal", nil, ListQuery{Page: Page{Sort: "name"}}, []string{"Alice groceries", "Bob party"}},
+			{"household", &home, ListQuery{Page: Page{Sort: "name"}}, []string{"Bob party", "Home groceries"}},
+			{"templates", nil, ListQuery{Kind: models.ListKindTemplate}, []string{"Weekly"}},
+			{"name filter", &home, ListQuery{Name: "GROC"}, []string{"Home groceries"}},
+		}
+		for _, tt := range tests {
+			got, _, err := repo.Lists().Visible(alice, tt.householdID, tt.q)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if names := listNames(got); !sameNames(names, tt.want...) {
+				t.Errorf("%s: Visible = %v, want %v", tt.name, names, tt.want)
+			}
+		}
+
+		// Pages follow on from each other and lists come with their items
+		var names []string
+		q := ListQuery{Page: Page{Sort: "-name", Limit: 1}}
+		for page := 0; page < 3; page++ {
+			got, next, err := repo.Lists().Visible(alice, nil, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, list := range got {
+				if list.Name == "Alice groceries" && len(list.Items) != 2 {
+					t.Errorf("%s has %d items, want 2", list.Name, len(list.Items))
+				}
+			}
+			names = append(names, listNames(got)...)
+			if next == "" {
+				break
+			}
+			q.Cursor = next
+		}
+		if !sameNames(names, "Bob party", "Alice groceries") {
+			t.Errorf("paged Visible = %v", names)
+		}
+	})
+}
+
+func TestItemWrites(t *testing.T) {
+	eachBackend(t, func(t *testing.T, conn *sql.DB, repo *SQL) {
+		alice := dbtest.User(t, conn, "alice@example.com")
+		items := repo.Items()
+		list := newList(t, repo, alice, nil, "Groceries", "Milk")
+		milk := list.Items[0]
+
+		milk.Quantity = 2
+		milk.Unit = "l"
+		v1 := 1
+		if err := items.Replace(&milk, &v1, alice); err != nil {
+			t.Fatal(err)
+		}
+		if milk.Version != 2 || milk.Quantity != 2 || milk.Unit != "l" {
+			t.Fatalf("Replace = %+v", milk)
+		}
+		if err := items.Replace(&milk, &v1, alice); !errors.Is(err, ErrVersionMismatch) {
+			t.Fatalf("Replace at a stale version = %v, want ErrVersionMismatch", err)
+		}
+
+		// Edits made before the last change to a field lose to it
+		name := "Oat milk"
+		merged, err := items.Merge(milk.ID, ItemPatch{Name: &name}, nil, alice)
+		if err != nil || merged.Item.Name != name || len(merged.Applied) != 1 {
+			t.Fatalf("Merge = %+v, %v", merged, err)
+		}
+		older := time.Now().Add(-time.Hour)
+		oldName, purchased := "Milk", true
+		merged, err = items.Merge(milk.ID, ItemPatch{Name: &oldName, Purchased: &purchased, ChangedAt: &older}, nil, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if merged.Item.Name != name || merged.Item.Purchased || len(merged.Applied) != 0 || len(merged.Stale) != 2 {
+			t.Fatalf("Merge of an older edit = %+v", merged)
+		}
+
+		if err := items.Delete(milk.ID, &v1, alice); !errors.Is(err, ErrVersionMismatch) {
+			t.Fatalf("Delete at a stale version = %v, want ErrVersionMismatch", err)
+		}
+		if err := items.Delete(milk.ID, nil, alice); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := items.Get(milk.ID); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Get after delete = %v, want ErrNotFound", err)
+		}
+
+		// Lists in the trash take no new items
+		if err := repo.Lists().Delete(list.ID, nil); err != nil {
+			t.Fatal(err)
+		}
+		item := models.ShoppingItem{ListID: list.ID, Name: "Bread", Quantity: 1}
+		if err := items.Create(&item, alice); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Create on a deleted list = %v, want ErrNotFound", err)
+		}
+
+		activity, _, err := repo.Activity().ForList(list.ID, ActivityQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// created, replaced, renamed, deleted
+		if len(activity) != 4 {
+			t.Errorf("got %d activity entries, want 4", len(activity))
+		}
+	})
+}
+
+func TestArchiveAndReuse(t *testing.T) {
+	eachBackend(t, func(t *testing.T, conn *sql.DB, repo *SQL) {
+		alice := dbtest.User(t, conn, "alice@example.com")
+		list := newList(t, repo, alice, nil, "Groceries", "Milk", "Bread")
+		bought := list.Items[0]
+		bought.Purchased = true
+		if err := repo.Items().Replace(&bought, nil, alice); err != nil {
+			t.Fatal(err)
+		}
+
+		entry, err := repo.History().ArchiveList(list.ID, alice, &CarryOver{UserID: alice})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Action != models.ActionCreated || entry.OriginalListID == nil || *entry.OriginalListID != list.ID {
+			t.Fatalf("ArchiveList = %+v", entry)
+		}
+		if _, err := repo.Lists().Get(list.ID); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Get of a done list = %v, want ErrNotFound", err)
+		}
+
+		// Bread was not bought and moved on to a new list of the same name
+		active, _, err := repo.Lists().Visible(alice, nil, ListQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(active) != 1 || active[0].Name != "Groceries" {
+			t.Fatalf("Visible after carry-over = %v", listNames(active))
+		}
+		if names := itemNames(active[0].Items); len(names) != 1 || !names["Bread"] {
+			t.Fatalf("carried over %v, want Bread", names)
+		}
+
+		history, _, err := repo.History().Visible(alice, nil, HistoryQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 1 || history[0].ID != entry.ID {
+			t.Fatalf("history = %+v", history)
+		}
+
+		reused, added, err := repo.History().RestoreList(entry.ID, alice, nil, ReuseOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(added.Created) != 2 || len(added.Merged) != 0 {
+			t.Fatalf("reuse as a new list created %d and merged %d items, want 2 and 0", len(added.Created), len(added.Merged))
+		}
+		if names := itemNames(reused.Items); len(names) != 2 || reused.Name != "Groceries" {
+			t.Fatalf("RestoreList = %s %v", reused.Name, names)
+		}
+		for _, item := range reused.Items {
+			if item.Purchased {
+				t.Errorf("reused %s is purchased", item.Name)
+			}
+		}
+
+		// Reusing into the carry-over list adds milk and merges bread
+		merged, added, err := repo.History().RestoreList(entry.ID, alice, nil, ReuseOptions{ListID: &active[0].ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(added.Created) != 1 || added.Created[0].Name != "Milk" || len(added.Merged) != 1 || added.Merged[0].Name != "Bread" {
+			t.Fatalf("reuse into a list created %+v and merged %+v, want milk and bread", added.Created, added.Merged)
+		}
+		got, err := repo.Lists().Get(active[0].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(merged.Items) != 2 || len(got.Items) != 2 {
+			t.Fatalf("reuse into a list changed %d items, list has %d; want 2 and 2", len(merged.Items), len(got.Items))
+		}
+
+		if _, _, err := repo.History().RestoreList(entry.ID, alice, nil, ReuseOptions{ListID: &list.ID}); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("reuse into a done list = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestArchiveUnnamedList(t *testing.T) {
+	eachBackend(t, func(t *testing.T, conn *sql.DB, repo *SQL) {
+		// Lists saved before names were required can still be marked done
+		alice := dbtest.User(t, conn, "alice@example.com")
+		list := newList(t, repo, alice, nil, "", "Milk")
+		entry, err := repo.History().ArchiveList(list.ID, alice, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload, err := entry.Payload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if snapshot := payload.(*models.ListSnapshot); snapshot.Name != untitledList || len(snapshot.Items) != 1 {
+			t.Fatalf("snapshot = %+v", snapshot)
+		}
+
+		reused, _, err := repo.History().RestoreList(entry.ID, alice, nil, ReuseOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reused.Name != untitledList || len(reused.Items) != 1 {
+			t.Fatalf("RestoreList = %s with %d items", reused.Name, len(reused.Items))
+		}
+	})
+}
+
+func TestUndoMarkDone(t *testing.T) {
+	eachBackend(t, func(t *testing.T, conn *sql.DB, repo *SQL) {
+		alice := dbtest.User(t, conn, "alice@example.com")
+		list := newList(t, repo, alice, nil, "Groceries", "Milk")
+
+		if _, err := repo.History().ArchiveList(list.ID, alice, &CarryOver{UserID: alice, Name: "Next week"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.Activity().Undo(list.ID, alice); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := repo.Lists().Get(list.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names := itemNames(got.Items); !names["Milk"] {
+			t.Fatalf("undo did not bring back the carried over items: %v", names)
+		}
+		history, _, err := repo.History().Visible(alice, nil, HistoryQuery{})
+		if err != nil || len(history) != 0 {
+			t.Fatalf("history after undo = %d entries, %v; want none", len(history), err)
+		}
+	})
+}
+
+func TestUndoRedo(t *testing.T) {
+	eachBackend(t, func(t *testing.T, conn *sql.DB, repo *SQL) {
+		alice := dbtest.User(t, conn, "alice@example.com")
+		list := newList(t, repo, alice, nil, "Groceries", "Milk")
+		activity := repo.Activity()
+
+		if _, err := repo.Lists().Rename(list.ID, "Weekly", nil, alice); err != nil {
+			t.Fatal(err)
+		}
+		name := func() string {
+			t.Helper()
+			got, err := repo.Lists().Get(list.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return got.Name
+		}
+
+		a, err := activity.Undo(list.ID, alice)
+		if err != nil || a.Action != models.ActivityListRenamed || name() != "Groceries" {
+			t.Fatalf("Undo = %s, %v; name %q", a.Action, err, name())
+		}
+		if _, err := activity.Undo(list.ID, alice); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.Items().Get(list.Items[0].ID); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("undoing a create left the item: %v", err)
+		}
+		if _, err := activity.Undo(list.ID, alice); !errors.Is(err, ErrNothingToUndo) {
+			t.Fatalf("Undo with nothing left = %v, want ErrNothingToUndo", err)
+		}
+
+		// Redo goes forwards in the order the changes were made
+		if a, err := activity.Redo(list.ID, alice); err != nil || a.Action != models.ActivityItemCreated {
+			t.Fatalf("Redo = %s, %v; want the create", a.Action, err)
+		}
+		// A new change drops the rest of the redo stack
+		if _, err := repo.Lists().Rename(list.ID, "Party", nil, alice); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := activity.Redo(list.ID, alice); !errors.Is(err, ErrNothingToRedo) {
+			t.Fatalf("Redo after a new change = %v, want ErrNothingToRedo", err)
+		}
+	})
+}
+
+func TestUndoKeepsLaterEdits(t *testing.T) {
+	type repos interface {
+		Lists() ListRepository
+		Items() ItemRepository
+		History() HistoryRepository
+		Activity() ActivityRepository
+	}
+	test := func(t *testing.T, repo repos, alice int) {
+		groceries := models.ShoppingList{UserID: alice, Name: "Groceries"}
+		next := models.ShoppingList{UserID: alice, Name: "Next week"}
+		for _, l := range []*models.ShoppingList{&groceries, &next} {
+			if err := repo.Lists().Create(l); err != nil {
+				t.Fatal(err)
+			}
+		}
+		milk := models.ShoppingItem{ListID: groceries.ID, Name: "Milk", Quantity: 1}
+		if err := repo.Items().Create(&milk, alice); err != nil {
+			t.Fatal(err)
+		}
+		setQuantity := func(quantity float64) {
+			t.Helper()
+			if _, err := repo.Items().Merge(milk.ID, ItemPatch{Quantity: &quantity}, nil, alice); err != nil {
+				t.Fatal(err)
+			}
+		}
+		quantity := func() float64 {
+			t.Helper()
+			got, err := repo.Items().Get(milk.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return got.Quantity
+		}
+
+		// An edit still in place is undone
+		setQuantity(2)
+		if _, err := repo.Activity().Undo(groceries.ID, alice); err != nil || quantity() != 1 {
+			t.Fatalf("Undo = %v, quantity %v; want 1", err, quantity())
+		}
+
+		// The milk is edited on the list it was carried over to, then comes
+		// back when marking the list done is undone. Undoing the edit made
+		// before would overwrite the later one.
+		setQuantity(2)
+		if _, err := repo.History().ArchiveList(groceries.ID, alice, &CarryOver{ListID: &next.ID}); err != nil {
+			t.Fatal(err)
+		}
+		setQuantity(5)
+		if _, err := repo.Activity().Undo(groceries.ID, alice); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.Activity().Undo(groceries.ID, alice); !errors.Is(err, ErrUndoConflict) {
+			t.Fatalf("undoing an overwritten edit = %v, want ErrUndoConflict", err)
+		}
+		if got := quantity(); got != 5 {
+			t.Errorf("quantity after the refused undo = %v, want 5", got)
+		}
+	}
+
+	dbtest.Run(t, func(t *testing.T, conn *sql.DB) {
+		test(t, NewSQL(conn), dbtest.User(t, conn, "alice@example.com"))
+	})
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemory(), 1001)
+	})
+}
+
+func TestTrash(t *testing.T) {
+	eachBackend(t, func(t *testing.T, conn *sql.DB, repo *SQL) {
+		alice := dbtest.User(t, conn, "alice@example.com")
+		bob := dbtest.User(t, conn, "bob@example.com")
+		trash := repo.Trash()
+
+		deleted := newList(t, repo, alice, nil, "Old", "Milk")
+		kept := newList(t, repo, alice, nil, "Groceries", "Bread")
+		newList(t, repo, bob, nil, "Bob's", "Nails")
+		if err := repo.Lists().Delete(deleted.ID, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Items().Delete(kept.Items[0].ID, nil, alice); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := trash.Visible(alice, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Lists) != 1 || got.Lists[0].ID != deleted.ID || len(got.Lists[0].Items) != 1 {
+			t.Fatalf("trash lists = %+v", got.Lists)
+		}
+		if len(got.Items) != 1 || got.Items[0].ID != kept.Items[0].ID {
+			t.Fatalf("trash items = %+v", got.Items)
+		}
+		if theirs, err := trash.Visible(bob, nil); err != nil || len(theirs.Lists)+len(theirs.Items) != 0 {
+			t.Fatalf("someone else's trash = %+v, %v", theirs, err)
+		}
+
+		restored, err := trash.RestoreList(deleted.ID)
+		if err != nil || len(restored.Items) != 1 {
+			t.Fatalf("RestoreList = %+v, %v", restored, err)
+		}
+		if _, err := trash.RestoreList(deleted.ID); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("RestoreList twice = %v, want ErrNotFound", err)
+		}
+		if _, err := trash.RestoreItem(kept.Items[0].ID, alice); err != nil {
+			t.Fatal(err)
+		}
+
+		// Everything deleted before the cutoff goes, along with done lists
+		if err := repo.Lists().Delete(kept.ID, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.History().ArchiveList(deleted.ID, alice, nil); err != nil {
+			t.Fatal(err)
+		}
+		n, err := trash.PurgeExpired(time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 {
+			t.Errorf("PurgeExpired removed %d rows, want 2", n)
+		}
+		var left int
+		if err := conn.QueryRow("SELECT COUNT(*) FROM shopping_lists WHERE user_id = $1", alice).Scan(&left); err != nil || left != 0 {
+			t.Errorf("%d lists left after purge, %v", left, err)
+		}
+	})
+}
+
+func TestCopyTemplate(t *testing.T) {
+	eachBackend(t, func(t *testing.T, conn *sql.DB, repo *SQL) {
+		alice := dbtest.User(t, conn, "alice@example.com")
+		list := newList(t, repo, alice, nil, "Groceries", "Milk", "Bread")
+		bought := list.Items[0]
+		bought.Purchased = true
+		if err := repo.Items().Replace(&bought, nil, alice); err != nil {
+			t.Fatal(err)
+		}
+
+		template, err := repo.Lists().Copy(list.ID, ListCopy{From: models.ListKindList, To: models.ListKindTemplate, Name: "Weekly", UserID: alice})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if template.Kind != models.ListKindTemplate || template.Name != "Weekly" || len(template.Items) != 2 {
+			t.Fatalf("Copy = %+v", template)
+		}
+		for _, item := range template.Items {
+			if item.Purchased {
+				t.Errorf("copied %s is purchased", item.Name)
+			}
+		}
+
+		if _, err := repo.Lists().Copy(list.ID, ListCopy{From: models.ListKindTemplate, To: models.ListKindList, UserID: alice}); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Copy of the wrong kind = %v, want ErrNotFound", err)
+		}
+		if _, err := repo.History().ArchiveList(template.ID, alice, nil); !errors.Is(err, ErrTemplate) {
+			t.Fatalf("ArchiveList of a template = %v, want ErrTemplate", err)
+		}
+	})
+}
+
+func TestReuseIntoTemplate(t *testing.T) {
+	test := func(t *testing.T, lists ListRepository, items ItemRepository, history HistoryRepository, alice int) {
+		list := models.ShoppingList{UserID: alice, Name: "Groceries"}
+		template := models.ShoppingList{UserID: alice, Name: "Weekly", Kind: models.ListKindTemplate}
+		for _, l := range []*models.ShoppingList{&list, &template} {
+			if err := lists.Create(l); err != nil {
+				t.Fatal(err)
+			}
+		}
+		milk := models.ShoppingItem{ListID: list.ID, Name: "Milk", Quantity: 1}
+		if err := items.Create(&milk, alice); err != nil {
+			t.Fatal(err)
+		}
+		entry, err := history.ArchiveList(list.ID, alice, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Templates only change through their own endpoints
+		if _, _, err := history.RestoreList(entry.ID, alice, nil, ReuseOptions{ListID: &template.ID}); !errors.Is(err, ErrInvalidTarget) {
+			t.Fatalf("reuse into a template = %v, want ErrInvalidTarget", err)
+		}
+		got, err := lists.Get(template.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Items) != 0 || got.Version != template.Version {
+			t.Fatalf("template after a refused reuse = %+v", got)
+		}
+	}
+
+	eachBackend(t, func(t *testing.T, conn *sql.DB, repo *SQL) {
+		test(t, repo.Lists(), repo.Items(), repo.History(), dbtest.User(t, conn, "alice@example.com"))
+	})
+	t.Run("memory", func(t *testing.T) {
+		repo := NewMemory()
+		test(t, repo.Lists(), repo.Items(), repo.History(), 1001)
+	})
+}
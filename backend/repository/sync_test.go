@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/shopping-list/backend/db/dbtest"
+	"github.com/shopping-list/backend/models"
+)
+
+// syncRepos are the repositories the change feed test writes through
+type syncRepos interface {
+	Lists() ListRepository
+	Items() ItemRepository
+	Trash() TrashRepository
+	Sync() SyncRepository
+}
+
+// eachSyncBackend runs test on every database backend and in memory, with
+// the ids of two users
+func eachSyncBackend(t *testing.T, test func(t *testing.T, repos syncRepos, alice, bob int)) {
+	dbtest.Run(t, func(t *testing.T, conn *sql.DB) {
+		test(t, NewSQL(conn), dbtest.User(t, conn, "alice@example.com"), dbtest.User(t, conn, "bob@example.com"))
+	})
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemory(), 1001, 1002)
+	})
+}
+
+func TestSyncChanges(t *testing.T) {
+	eachSyncBackend(t, func(t *testing.T, repos syncRepos, alice, bob int) {
+		changes := func(userID int, since int64) SyncChanges {
+			t.Helper()
+			c, err := repos.Sync().Changes(userID, nil, since)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Cursor < since {
+				t.Fatalf("cursor went back from %d to %d", since, c.Cursor)
+			}
+			return c
+		}
+		deleted := func(c SyncChanges) []string {
+			var got []string
+			for _, d := range c.Deleted {
+				got = append(got, d.Type)
+			}
+			return got
+		}
+
+		list := models.ShoppingList{UserID: alice, Name: "Groceries"}
+		template := models.ShoppingList{UserID: alice, Name: "Weekly", Kind: models.ListKindTemplate}
+		for _, l := range []*models.ShoppingList{&list, &template} {
+			if err := repos.Lists().Create(l); err != nil {
+				t.Fatal(err)
+			}
+		}
+		milk := models.ShoppingItem{ListID: list.ID, Name: "Milk", Quantity: 1}
+		bread := models.ShoppingItem{ListID: list.ID, Name: "Bread", Quantity: 1}
+		for _, item := range []*models.ShoppingItem{&milk, &bread} {
+			if err := repos.Items().Create(item, alice); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// A full snapshot has everything and nothing deleted
+		full := changes(alice, 0)
+		if len(full.Lists) != 2 || len(full.Items) != 2 || len(full.Deleted) != 0 || full.Cursor == 0 {
+			t.Fatalf("full snapshot = %d lists, %d items, %d deleted at %d", len(full.Lists), len(full.Items), len(full.Deleted), full.Cursor)
+		}
+		if other := changes(bob, 0); len(other.Lists) != 0 || len(other.Items) != 0 {
+			t.Fatalf("bob sees %d lists and %d items", len(other.Lists), len(other.Items))
+		}
+
+		// Changes come through once, trashed rows as deleted
+		if _, err := repos.Lists().Rename(list.ID, "Weekly shop", nil, alice); err != nil {
+			t.Fatal(err)
+		}
+		if err := repos.Items().Delete(bread.ID, nil, alice); err != nil {
+			t.Fatal(err)
+		}
+		delta := changes(alice, full.Cursor)
+		if len(delta.Lists) != 1 || delta.Lists[0].Name != "Weekly shop" || len(delta.Items) != 0 {
+			t.Fatalf("delta = %+v", delta)
+		}
+		if got := deleted(delta); len(got) != 1 || got[0] != "item" || delta.Deleted[0].ID != bread.ID {
+			t.Fatalf("delta deleted %+v, want bread", delta.Deleted)
+		}
+		if again := changes(alice, delta.Cursor); len(again.Lists)+len(again.Items)+len(again.Deleted) != 0 || again.Cursor != delta.Cursor {
+			t.Fatalf("nothing changed but got %+v", again)
+		}
+
+		// Purging leaves a tombstone, and so does trashing the list
+		if err := repos.Trash().PurgeItem(bread.ID); err != nil {
+			t.Fatal(err)
+		}
+		purged := changes(alice, delta.Cursor)
+		if got := deleted(purged); len(got) != 1 || got[0] != "item" || purged.Deleted[0].ID != bread.ID {
+			t.Fatalf("after purge deleted %+v, want bread", purged.Deleted)
+		}
+		if err := repos.Lists().Delete(list.ID, nil); err != nil {
+			t.Fatal(err)
+		}
+		gone := changes(alice, purged.Cursor)
+		if got := deleted(gone); len(got) != 1 || got[0] != "list" || gone.Deleted[0].ID != list.ID || len(gone.Items) != 0 {
+			t.Fatalf("after deleting the list = %+v", gone)
+		}
+		if other := changes(bob, purged.Cursor); len(other.Deleted) != 0 {
+			t.Fatalf("bob was told about %+v", other.Deleted)
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/shopping-list/backend/db/dbtest"
+	"github.com/shopping-list/backend/models"
+)
+
+// listTables hold everything the list flows write
+var listTables = []string{"shopping_lists", "shopping_items", "list_history", "list_activity", "sync_tombstones"}
+
+// failEachStatement runs op with each of its statements failing in turn,
+// checking that a failure leaves no trace of op behind, and finally runs it
+// through. It returns the number of statements op took.
+func failEachStatement(t *testing.T, conn *sql.DB, faults *dbtest.Faults, op func() error) int {
+	t.Helper()
+	faults.FailAt(0)
+	before := dbtest.Snapshot(t, conn, listTables...)
+	for n := 1; ; n++ {
+		faults.FailAt(n)
+		err := op()
+		if !faults.Failed() {
+			faults.FailAt(0)
+			if err != nil {
+				t.Fatalf("op failed on its own: %v", err)
+			}
+			return n - 1
+		}
+		faults.FailAt(0)
+		if !errors.Is(err, dbtest.ErrInjected) {
+			t.Fatalf("statement %d failed but op returned %v", n, err)
+		}
+		if after := dbtest.Snapshot(t, conn, listTables...); !reflect.DeepEqual(before, after) {
+			t.Fatalf("statement %d failed and left\n%v\nbehind, had\n%v", n, after, before)
+		}
+	}
+}
+
+// faultyList sets up a list with a bought and an unbought item
+func faultyList(t *testing.T, repo *SQL, userID int, name string) models.ShoppingList {
+	t.Helper()
+	list := models.ShoppingList{UserID: userID, Name: name}
+	if err := repo.Lists().Create(&list); err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range []models.ShoppingItem{{Name: "Milk", Quantity: 1}, {Name: "Bread", Quantity: 2}} {
+		item.ListID = list.ID
+		if err := repo.Items().Create(&item, userID); err != nil {
+			t.Fatal(err)
+		}
+		list.Items = append(list.Items, item)
+	}
+	bought := list.Items[0]
+	bought.Purchased = true
+	if err := repo.Items().Replace(&bought, nil, userID); err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestArchiveListFailures(t *testing.T) {
+	tests := []struct {
+		name  string
+		carry func(userID, nextID int) *CarryOver
+	}{
+		{"archive", func(userID, nextID int) *CarryOver { return nil }},
+		{"carry over to a new list", func(userID, nextID int) *CarryOver { return &CarryOver{UserID: userID} }},
+		{"carry over to a list", func(userID, nextID int) *CarryOver { return &CarryOver{ListID: &nextID, UserID: userID} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbtest.RunFaulty(t, func(t *testing.T, conn *sql.DB, faults *dbtest.Faults) {
+				repo := NewSQL(conn)
+				alice := dbtest.User(t, conn, "alice@example.com")
+				list := faultyList(t, repo, alice, "Groceries")
+				next := faultyList(t, repo, alice, "Next week")
+
+				var entry models.ListHistory
+				n := failEachStatement(t, conn, faults, func() (err error) {
+					entry, err = repo.History().ArchiveList(list.ID, alice, tt.carry(alice, next.ID))
+					return err
+				})
+				if n < 3 {
+					t.Errorf("ArchiveList ran %d statements, want at least begin, a write and commit", n)
+				}
+				if _, err := repo.Lists().Get(list.ID); !errors.Is(err, ErrNotFound) || entry.ID == 0 {
+					t.Fatalf("list still there after ArchiveList: %v", err)
+				}
+			})
+		})
+	}
+}
+
+func TestRestoreListFailures(t *testing.T) {
+	dbtest.RunFaulty(t, func(t *testing.T, conn *sql.DB, faults *dbtest.Faults) {
+		repo := NewSQL(conn)
+		alice := dbtest.User(t, conn, "alice@example.com")
+		list := faultyList(t, repo, alice, "Groceries")
+		next := faultyList(t, repo, alice, "Next week")
+		entry, err := repo.History().ArchiveList(list.ID, alice, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Reusing into a new list, then merging into an existing one
+		for _, opts := range []ReuseOptions{{}, {ListID: &next.ID}} {
+			var reused models.ShoppingList
+			failEachStatement(t, conn, faults, func() (err error) {
+				reused, _, err = repo.History().RestoreList(entry.ID, alice, nil, opts)
+				return err
+			})
+			if len(reused.Items) != 2 {
+				t.Fatalf("RestoreList with %+v = %d items, want 2", opts, len(reused.Items))
+			}
+		}
+	})
+}
+
+func TestTrashRestoreFailures(t *testing.T) {
+	dbtest.RunFaulty(t, func(t *testing.T, conn *sql.DB, faults *dbtest.Faults) {
+		repo := NewSQL(conn)
+		alice := dbtest.User(t, conn, "alice@example.com")
+		list := faultyList(t, repo, alice, "Groceries")
+		if err := repo.Lists().Delete(list.ID, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		failEachStatement(t, conn, faults, func() error {
+			_, err := repo.Trash().RestoreList(list.ID)
+			return err
+		})
+		if got, err := repo.Lists().Get(list.ID); err != nil || len(got.Items) != 2 {
+			t.Fatalf("restored list = %+v, %v", got, err)
+		}
+	})
+}
+
+func TestUndoFailures(t *testing.T) {
+	dbtest.RunFaulty(t, func(t *testing.T, conn *sql.DB, faults *dbtest.Faults) {
+		repo := NewSQL(conn)
+		alice := dbtest.User(t, conn, "alice@example.com")
+		list := faultyList(t, repo, alice, "Groceries")
+		if _, err := repo.History().ArchiveList(list.ID, alice, &CarryOver{UserID: alice}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Undoing mark-done brings the list back and takes the carried
+		// over items with it
+		failEachStatement(t, conn, faults, func() error {
+			_, err := repo.Activity().Undo(list.ID, alice)
+			return err
+		})
+		if got, err := repo.Lists().Get(list.ID); err != nil || len(got.Items) != 2 {
+			t.Fatalf("list after undo = %+v, %v", got, err)
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/shopping-list/backend/db/dbtest"
+	"github.com/shopping-list/backend/models"
+)
+
+// perListVisible loads the visible lists of a kind the way GetUserLists
+// used to, with one item query per list
+func perListVisible(q queryer, userID int, householdID *int, kind string) ([]models.ShoppingList, error) {
+	rows, err := q.Query("SELECT "+listColumns+" FROM shopping_lists WHERE "+visibleLists+" AND deleted_at IS NULL AND kind = $3 ORDER BY updated_at DESC", userID, householdID, kind)
+	if err != nil {
+		return nil, err
+	}
+	var result []models.ShoppingList
+	for rows.Next() {
+		var list models.ShoppingList
+		if err := scanList(rows, &list); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		result = append(result, list)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range result {
+		if result[i].Items, err = loadItems(q, result[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// byID orders lists and their items by id, so that loads which break ties
+// differently compare equal
+func byID(lists []models.ShoppingList) []models.ShoppingList {
+	sort.Slice(lists, func(i, j int) bool { return lists[i].ID < lists[j].ID })
+	for _, list := range lists {
+		sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].ID < list.Items[j].ID })
+	}
+	return lists
+}
+
+func TestVisibleMatchesPerListQueries(t *testing.T) {
+	eachBackend(t, func(t *testing.T, conn *sql.DB, repo *SQL) {
+		alice := dbtest.User(t, conn, "alice@example.com")
+		bob := dbtest.User(t, conn, "bob@example.com")
+		household := dbtest.Household(t, conn, "Home", alice, bob)
+
+		// Personal, household and shared lists and templates, each with a
+		// bought, a trashed and some plain items, plus a list in the trash
+		add := func(userID int, householdID *int, kind, name string, items int) models.ShoppingList {
+			t.Helper()
+			list := models.ShoppingList{UserID: userID, HouseholdID: householdID, Name: name, Kind: kind}
+			if err := repo.Lists().Create(&list); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < items; i++ {
+				item := models.ShoppingItem{ListID: list.ID, Name: fmt.Sprintf("Item %d", i), Quantity: float64(i + 1)}
+				if err := repo.Items().Create(&item, userID); err != nil {
+					t.Fatal(err)
+				}
+				switch i {
+				case 0:
+					item.Purchased = true
+					if err := repo.Items().Replace(&item, nil, userID); err != nil {
+						t.Fatal(err)
+					}
+				case 1:
+					if err := repo.Items().Delete(item.ID, nil, userID); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			return list
+		}
+		for i := 0; i < 4; i++ {
+			add(alice, nil, models.ListKindList, fmt.Sprintf("Alice %d", i), i+2)
+			add(bob, &household, models.ListKindList, fmt.Sprintf("Home %d", i), i)
+		}
+		add(alice, nil, models.ListKindList, "Empty", 0)
+		add(alice, nil, models.ListKindTemplate, "Weekly", 3)
+		add(bob, &household, models.ListKindTemplate, "Party", 2)
+		dbtest.Share(t, conn, add(bob, nil, models.ListKindList, "Shared", 3).ID, alice, "viewer")
+		add(bob, nil, models.ListKindList, "Bob's own", 3)
+		trashed := add(alice, nil, models.ListKindList, "Trashed", 3)
+		if err := repo.Lists().Delete(trashed.ID, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range []struct {
+			name        string
+			userID      int
+			householdID *int
+			kind        string
+		}{
+			{"personal lists", alice, nil, models.ListKindList},
+			{"household lists", alice, &household, models.ListKindList},
+			{"personal templates", alice, nil, models.ListKindTemplate},
+			{"household templates", bob, &household, models.ListKindTemplate},
+			{"bob's personal lists", bob, nil, models.ListKindList},
+		} {
+			want, err := perListVisible(conn, tt.userID, tt.householdID, tt.kind)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Small pages so that the items of several pages are loaded
+			var got []models.ShoppingList
+			q := ListQuery{Kind: tt.kind, Page: Page{Limit: 2}}
+			for {
+				page, next, err := repo.Lists().Visible(tt.userID, tt.householdID, q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, page...)
+				if next == "" {
+					break
+				}
+				q.Cursor = next
+			}
+
+			if len(want) == 0 {
+				t.Fatalf("%s: nothing to compare", tt.name)
+			}
+			if !reflect.DeepEqual(byID(got), byID(want)) {
+				t.Errorf("%s: Visible =\n%+v\nper list queries =\n%+v", tt.name, got, want)
+			}
+		}
+	})
+}
@@ -4,41 +4,135 @@ import (
 	"database/sql"
 
 	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/auth"
+	"github.com/shopping-list/backend/authz"
+	"github.com/shopping-list/backend/events"
 	"github.com/shopping-list/backend/handlers"
+	"github.com/shopping-list/backend/repository"
 )
 
 // SetupRoutes sets up all routes for the API
-func SetupRoutes(router *gin.Engine, db *sql.DB) {
+func SetupRoutes(router *gin.Engine, db *sql.DB, hub *events.Hub) {
 	// Middleware
 	router.Use(CORSMiddleware())
 
+	repos := repository.NewSQL(db)
+	access := authz.NewChecker(db)
+
 	// API v1 routes
 	v1 := router.Group("/api/v1")
 	{
+		// Auth routes
+		authRoutes := v1.Group("/auth")
+		{
+			authRoutes.POST("/register", handlers.Register(db))
+			authRoutes.POST("/login", handlers.Login(db))
+			authRoutes.POST("/logout", auth.Middleware(db), handlers.Logout(db))
+		}
+
+		// Everything below requires an authenticated user
+		protected := v1.Group("")
+		protected.Use(auth.Middleware(db))
+
 		// Shopping Lists routes
-		lists := v1.Group("/lists")
+		lists := protected.Group("/lists")
 		{
-			lists.POST("", handlers.CreateList(db))
-			lists.GET("", handlers.GetUserLists(db))
-			lists.GET("/:id", handlers.GetList(db))
-			lists.PUT("/:id", handlers.UpdateList(db))
-			lists.DELETE("/:id", handlers.DeleteList(db))
-			lists.POST("/:id/done", handlers.MarkListDone(db))
+			lists.POST("", handlers.CreateList(repos.Lists()))
+			lists.GET("", handlers.GetUserLists(repos.Lists()))
+			lists.GET("/:id", authz.RequireList(db, authz.RoleViewer), handlers.GetList(repos.Lists()))
+			lists.PUT("/:id", authz.RequireList(db, authz.RoleEditor), handlers.UpdateList(repos.Lists(), hub))
+			lists.DELETE("/:id", authz.RequireList(db, authz.RoleOwner), handlers.DeleteList(repos.Lists(), hub))
+			lists.POST("/:id/done", authz.RequireList(db, authz.RoleEditor), handlers.MarkListDone(repos.History(), repos.Lists(), access, hub))
+			lists.GET("/:id/events", authz.RequireList(db, authz.RoleViewer), handlers.ListEvents(hub, access))
+			lists.GET("/:id/activity", authz.RequireList(db, authz.RoleViewer), handlers.GetListActivity(repos.Activity()))
+			lists.POST("/:id/undo", authz.RequireUndoableList(db, authz.RoleEditor), handlers.UndoChange(repos.Activity(), hub))
+			lists.POST("/:id/redo", authz.RequireUndoableList(db, authz.RoleEditor), handlers.RedoChange(repos.Activity(), hub))
+			lists.POST("/:id/template", authz.RequireList(db, authz.RoleViewer), handlers.SaveAsTemplate(repos.Lists()))
+			lists.POST("/:id/duplicate", authz.RequireList(db, authz.RoleViewer), handlers.DuplicateList(repos.Lists()))
+
+			// List sharing
+			lists.GET("/:id/members", authz.RequireList(db, authz.RoleViewer), handlers.GetListMembers(db))
+			lists.POST("/:id/members", authz.RequireList(db, authz.RoleOwner), handlers.AddListMember(db))
+			lists.PUT("/:id/members/:userId", authz.RequireList(db, authz.RoleOwner), handlers.UpdateListMember(db))
+			lists.DELETE("/:id/members/:userId", authz.RequireList(db, authz.RoleViewer), handlers.RemoveListMember(db, hub))
+			lists.GET("/:id/invites", authz.RequireList(db, authz.RoleOwner), handlers.GetListInvites(db))
+			lists.POST("/:id/invites", authz.RequireList(db, authz.RoleOwner), handlers.CreateListInvite(db))
+			lists.DELETE("/:id/invites/:inviteId", authz.RequireList(db, authz.RoleOwner), handlers.RevokeListInvite(db))
 		}
 
 		// Shopping Items routes
-		items := v1.Group("/items")
+		items := protected.Group("/items")
 		{
-			items.POST("", handlers.CreateItem(db))
-			items.PUT("/:id", handlers.UpdateItem(db))
-			items.DELETE("/:id", handlers.DeleteItem(db))
+			items.POST("", handlers.CreateItem(repos.Items(), access, hub))
+			items.PUT("/:id", authz.RequireItem(db, authz.RoleEditor), handlers.UpdateItem(repos.Items(), hub))
+			items.PATCH("/:id", authz.RequireItem(db, authz.RoleEditor), handlers.PatchItem(repos.Items(), hub))
+			items.DELETE("/:id", authz.RequireItem(db, authz.RoleEditor), handlers.DeleteItem(repos.Items(), hub))
+			items.POST("/:id/recurrences", authz.RequireItem(db, authz.RoleEditor), handlers.CreateItemRecurrence(repos.Recurrences(), access))
 		}
 
+		// Household routes
+		households := protected.Group("/households")
+		{
+			households.POST("", handlers.CreateHousehold(db))
+			households.GET("", handlers.GetUserHouseholds(db))
+			households.PUT("/active", handlers.SetActiveHousehold(db))
+			households.GET("/:id", authz.RequireHousehold(db, authz.RoleMember), handlers.GetHousehold(db))
+			households.PUT("/:id", authz.RequireHousehold(db, authz.RoleOwner), handlers.UpdateHousehold(db))
+			households.DELETE("/:id", authz.RequireHousehold(db, authz.RoleOwner), handlers.DeleteHousehold(db, hub))
+			households.POST("/:id/members", authz.RequireHousehold(db, authz.RoleOwner), handlers.AddHouseholdMember(db))
+			households.PUT("/:id/members/:userId", authz.RequireHousehold(db, authz.RoleOwner), handlers.UpdateHouseholdMember(db))
+			households.DELETE("/:id/members/:userId", authz.RequireHousehold(db, authz.RoleMember), handlers.RemoveHouseholdMember(db, hub))
+			households.GET("/:id/invites", authz.RequireHousehold(db, authz.RoleOwner), handlers.GetHouseholdInvites(db))
+			households.POST("/:id/invites", authz.RequireHousehold(db, authz.RoleOwner), handlers.CreateHouseholdInvite(db))
+			households.DELETE("/:id/invites/:inviteId", authz.RequireHousehold(db, authz.RoleOwner), handlers.RevokeHouseholdInvite(db))
+		}
+
+		// Offline sync routes
+		protected.GET("/sync", handlers.GetSyncChanges(repos.Sync()))
+		protected.POST("/sync", handlers.ApplySync(repos.Lists(), repos.Items(), access, hub))
+
+		// Invite routes
+		protected.POST("/invites/:token/accept", handlers.AcceptInvite(db))
+
 		// History routes
-		history := v1.Group("/history")
+		history := protected.Group("/history")
+		{
+			history.GET("", handlers.GetUserHistory(repos.History()))
+			history.POST("/reuse/:id", authz.RequireHistory(db), handlers.ReuseList(repos.History(), access, hub))
+		}
+
+		// Template routes
+		templates := protected.Group("/templates")
+		{
+			templates.GET("", handlers.GetTemplates(repos.Lists()))
+			templates.POST("/:id/instantiate", authz.RequireList(db, authz.RoleViewer), handlers.InstantiateTemplate(repos.Lists()))
+			templates.POST("/:id/recurrences", authz.RequireList(db, authz.RoleEditor), handlers.CreateTemplateRecurrence(repos.Recurrences(), access))
+		}
+
+		// Recurrence routes
+		recurrences := protected.Group("/recurrences")
+		{
+			recurrences.GET("", handlers.GetRecurrences(repos.Recurrences()))
+			recurrences.DELETE("/:id", handlers.DeleteRecurrence(repos.Recurrences()))
+		}
+
+		// Admin routes, for the users listed in ADMIN_EMAILS
+		admin := protected.Group("/admin")
+		admin.Use(authz.RequireAdmin())
+		{
+			admin.GET("/jobs", handlers.GetJobs(repos.Jobs()))
+			admin.GET("/jobs/:id", handlers.GetJob(repos.Jobs()))
+			admin.POST("/jobs/:id/retry", handlers.RetryJob(repos.Jobs()))
+		}
+
+		// Trash routes
+		trash := protected.Group("/trash")
 		{
-			history.GET("", handlers.GetUserHistory(db))
-			history.POST("/reuse/:id", handlers.ReuseList(db))
+			trash.GET("", handlers.GetTrash(repos.Trash()))
+			trash.POST("/lists/:id/restore", authz.RequireTrashedList(db, authz.RoleOwner), handlers.RestoreTrashedList(repos.Trash(), hub))
+			trash.DELETE("/lists/:id", authz.RequireTrashedList(db, authz.RoleOwner), handlers.PurgeTrashedList(repos.Trash()))
+			trash.POST("/items/:id/restore", authz.RequireTrashedItem(db, authz.RoleEditor), handlers.RestoreTrashedItem(repos.Trash(), hub))
+			trash.DELETE("/items/:id", authz.RequireTrashedItem(db, authz.RoleEditor), handlers.PurgeTrashedItem(repos.Trash()))
 		}
 	}
 
@@ -51,8 +145,9 @@ func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, Last-Event-ID, If-Match")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
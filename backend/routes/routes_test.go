@@ -0,0 +1,490 @@
+package routes
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shopping-list/backend/db"
+	"github.com/shopping-list/backend/db/dbtest"
+	"github.com/shopping-list/backend/events"
+)
+
+// The tests in this file drive the full API against every database
+// backend, see dbtest
+
+// client makes API requests as one user. Writes apply to any version
+// unless the client was made with at.
+type client struct {
+	t       *testing.T
+	router  *gin.Engine
+	token   string
+	ifMatch string
+}
+
+// at returns a client whose writes expect the given version
+func (c *client) at(version int) *client {
+	at := *c
+	at.ifMatch = `"` + strconv.Itoa(version) + `"`
+	return &at
+}
+
+// newRouter sets up the API on a database
+func newRouter(conn *sql.DB) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	SetupRoutes(router, conn, events.NewHub(conn))
+	return router
+}
+
+// register signs up a user and returns a client for them
+func register(t *testing.T, router *gin.Engine, email string) *client {
+	t.Helper()
+	c := &client{t: t, router: router, ifMatch: "*"}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	c.do(http.MethodPost, "/api/v1/auth/register", gin.H{"email": email, "password": "password123"}, http.StatusCreated, &resp)
+	c.token = resp.Token
+	return c
+}
+
+// do sends a request, checks its status and decodes the response into out
+// unless it is nil
+func (c *client) do(method, path string, body interface{}, status int, out interface{}) {
+	c.t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("If-Match", c.ifMatch)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	w := httptest.NewRecorder()
+	c.router.ServeHTTP(w, req)
+
+	if w.Code != status {
+		c.t.Fatalf("%s %s = %d %s, want %d", method, path, w.Code, w.Body.String(), status)
+	}
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			c.t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+}
+
+type listResponse struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Items []struct {
+		ID        int    `json:"id"`
+		Name      string `json:"name"`
+		Purchased bool   `json:"purchased"`
+	} `json:"items"`
+}
+
+// idPath appends an id to a path
+func idPath(prefix string, id int) string {
+	return prefix + "/" + strconv.Itoa(id)
+}
+
+func TestShoppingFlow(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, conn *sql.DB) {
+		router := newRouter(conn)
+		alice := register(t, router, "alice@example.com")
+		bob := register(t, router, "bob@example.com")
+
+		var list listResponse
+		alice.do(http.MethodPost, "/api/v1/lists", gin.H{"name": "Groceries"}, http.StatusCreated, &list)
+		var milk struct {
+			ID       int     `json:"id"`
+			Quantity float64 `json:"quantity"`
+		}
+		alice.do(http.MethodPost, "/api/v1/items", gin.H{"list_id": list.ID, "name": "Milk", "quantity": 2}, http.StatusCreated, &milk)
+		alice.do(http.MethodPost, "/api/v1/items", gin.H{"list_id": list.ID, "name": "Bread", "quantity": 1}, http.StatusCreated, nil)
+		alice.do(http.MethodPatch, idPath("/api/v1/items", milk.ID), gin.H{"purchased": true}, http.StatusOK, nil)
+		alice.at(1).do(http.MethodPut, idPath("/api/v1/lists", list.ID), gin.H{"name": "Weekly"}, http.StatusOK, nil)
+		alice.at(1).do(http.MethodPut, idPath("/api/v1/lists", list.ID), gin.H{"name": "Stale"}, http.StatusPreconditionFailed, nil)
+
+		alice.do(http.MethodGet, idPath("/api/v1/lists", list.ID), nil, http.StatusOK, &list)
+		if list.Name != "Weekly" || len(list.Items) != 2 {
+			t.Fatalf("list = %+v", list)
+		}
+
+		// Other users cannot see the list until it is shared with them
+		bob.do(http.MethodGet, idPath("/api/v1/lists", list.ID), nil, http.StatusForbidden, nil)
+		bob.do(http.MethodPost, "/api/v1/items", gin.H{"list_id": list.ID, "name": "Beer", "quantity": 1}, http.StatusForbidden, nil)
+
+		// Marking it done carries the bread over to a new list
+		var done struct {
+			History struct {
+				ID int `json:"id"`
+			} `json:"history"`
+			CarriedOverTo listResponse `json:"carried_over_to"`
+		}
+		alice.do(http.MethodPost, idPath("/api/v1/lists", list.ID)+"/done", gin.H{"carry_over": gin.H{"name": "Next week"}}, http.StatusOK, &done)
+		if done.CarriedOverTo.Name != "Next week" || len(done.CarriedOverTo.Items) != 1 || done.CarriedOverTo.Items[0].Name != "Bread" {
+			t.Fatalf("carried over to %+v", done.CarriedOverTo)
+		}
+		alice.do(http.MethodGet, idPath("/api/v1/lists", list.ID), nil, http.StatusNotFound, nil)
+
+		var history struct {
+			Data []struct {
+				ID int `json:"id"`
+			} `json:"data"`
+		}
+		alice.do(http.MethodGet, "/api/v1/history", nil, http.StatusOK, &history)
+		if len(history.Data) != 1 || history.Data[0].ID != done.History.ID {
+			t.Fatalf("history = %+v", history)
+		}
+		bob.do(http.MethodPost, idPath("/api/v1/history/reuse", done.History.ID), nil, http.StatusForbidden, nil)
+
+		var reused struct {
+			ID int `json:"id"`
+		}
+		alice.do(http.MethodPost, idPath("/api/v1/history/reuse", done.History.ID), nil, http.StatusCreated, &reused)
+		alice.do(http.MethodGet, idPath("/api/v1/lists", reused.ID), nil, http.StatusOK, &list)
+		if list.Name != "Weekly" || len(list.Items) != 2 {
+			t.Fatalf("reused list = %+v", list)
+		}
+
+		var sync struct {
+			Cursor string         `json:"cursor"`
+			Lists  []listResponse `json:"lists"`
+			Items  []struct {
+				ID int `json:"id"`
+			} `json:"items"`
+		}
+		alice.do(http.MethodGet, "/api/v1/sync", nil, http.StatusOK, &sync)
+		if len(sync.Lists) != 2 || len(sync.Items) != 3 {
+			t.Fatalf("sync has %d lists and %d items, want 2 and 3", len(sync.Lists), len(sync.Items))
+		}
+
+		// Deleting a list shows up as a tombstone on the next sync
+		alice.do(http.MethodDelete, idPath("/api/v1/lists", reused.ID), nil, http.StatusOK, nil)
+		var delta struct {
+			Deleted []struct {
+				Type string `json:"type"`
+				ID   int    `json:"id"`
+			} `json:"deleted"`
+		}
+		alice.do(http.MethodGet, "/api/v1/sync?since="+sync.Cursor, nil, http.StatusOK, &delta)
+		if len(delta.Deleted) != 1 || delta.Deleted[0].Type != "list" || delta.Deleted[0].ID != reused.ID {
+			t.Fatalf("delta deletions = %+v", delta.Deleted)
+		}
+
+		alice.do(http.MethodPost, idPath("/api/v1/trash/lists", reused.ID)+"/restore", nil, http.StatusOK, nil)
+		alice.do(http.MethodGet, idPath("/api/v1/lists", reused.ID), nil, http.StatusOK, nil)
+	})
+}
+
+func TestListNames(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, conn *sql.DB) {
+		router := newRouter(conn)
+		alice := register(t, router, "alice@example.com")
+
+		for _, name := range []string{"", "   "} {
+			alice.do(http.MethodPost, "/api/v1/lists", gin.H{"name": name}, http.StatusBadRequest, nil)
+		}
+		var list listResponse
+		alice.do(http.MethodPost, "/api/v1/lists", gin.H{"name": "  Groceries "}, http.StatusCreated, &list)
+		if list.Name != "Groceries" {
+			t.Fatalf("created %q, want the name trimmed", list.Name)
+		}
+		alice.do(http.MethodPut, idPath("/api/v1/lists", list.ID), gin.H{"name": " "}, http.StatusBadRequest, nil)
+		alice.do(http.MethodPut, idPath("/api/v1/lists", list.ID), gin.H{}, http.StatusBadRequest, nil)
+
+		var batch struct {
+			Results []struct {
+				Status int `json:"status"`
+			} `json:"results"`
+		}
+		alice.do(http.MethodPost, "/api/v1/sync", gin.H{"operations": []gin.H{
+			{"type": "create_list", "name": "  "},
+			{"type": "update_list", "list_id": list.ID, "version": 1, "name": "\t"},
+		}}, http.StatusOK, &batch)
+		if len(batch.Results) != 2 {
+			t.Fatalf("sync returned %d results, want 2", len(batch.Results))
+		}
+		for i, result := range batch.Results {
+			if result.Status != http.StatusBadRequest {
+				t.Errorf("sync operation %d = %d, want 400", i, result.Status)
+			}
+		}
+
+		// The list can be marked done with its name intact
+		alice.do(http.MethodPost, idPath("/api/v1/lists", list.ID)+"/done", nil, http.StatusOK, nil)
+	})
+}
+
+func TestTemplates(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, conn *sql.DB) {
+		router := newRouter(conn)
+		alice := register(t, router, "alice@example.com")
+
+		var list, template listResponse
+		alice.do(http.MethodPost, "/api/v1/lists", gin.H{"name": "Groceries"}, http.StatusCreated, &list)
+		alice.do(http.MethodPost, "/api/v1/items", gin.H{"list_id": list.ID, "name": "Milk", "quantity": 2}, http.StatusCreated, nil)
+		alice.do(http.MethodPost, idPath("/api/v1/lists", list.ID)+"/template", gin.H{"name": "Weekly"}, http.StatusCreated, &template)
+
+		// Templates come with their items and stay out of the active lists
+		var page struct {
+			Data []listResponse `json:"data"`
+		}
+		alice.do(http.MethodGet, "/api/v1/templates", nil, http.StatusOK, &page)
+		if len(page.Data) != 1 || page.Data[0].ID != template.ID || len(page.Data[0].Items) != 1 || page.Data[0].Items[0].Name != "Milk" {
+			t.Fatalf("templates = %+v", page.Data)
+		}
+		alice.do(http.MethodGet, "/api/v1/lists", nil, http.StatusOK, &page)
+		if len(page.Data) != 1 || page.Data[0].ID != list.ID {
+			t.Fatalf("lists = %+v", page.Data)
+		}
+
+		// Past lists cannot be reused into a template
+		var done struct {
+			History struct {
+				ID int `json:"id"`
+			} `json:"history"`
+		}
+		alice.do(http.MethodPost, idPath("/api/v1/lists", list.ID)+"/done", nil, http.StatusOK, &done)
+		alice.do(http.MethodPost, idPath("/api/v1/history/reuse", done.History.ID), gin.H{"list_id": template.ID}, http.StatusUnprocessableEntity, nil)
+		alice.do(http.MethodGet, idPath("/api/v1/lists", template.ID), nil, http.StatusOK, &template)
+		if len(template.Items) != 1 {
+			t.Fatalf("template after a refused reuse = %+v", template)
+		}
+	})
+}
+
+func TestReuseIntoList(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, conn *sql.DB) {
+		router := newRouter(conn)
+		alice := register(t, router, "alice@example.com")
+
+		var last, next listResponse
+		alice.do(http.MethodPost, "/api/v1/lists", gin.H{"name": "Last week"}, http.StatusCreated, &last)
+		alice.do(http.MethodPost, "/api/v1/items", gin.H{"list_id": last.ID, "name": "Milk", "quantity": 1}, http.StatusCreated, nil)
+		alice.do(http.MethodPost, "/api/v1/items", gin.H{"list_id": last.ID, "name": "Bread", "quantity": 1}, http.StatusCreated, nil)
+		var done struct {
+			History struct {
+				ID int `json:"id"`
+			} `json:"history"`
+		}
+		alice.do(http.MethodPost, idPath("/api/v1/lists", last.ID)+"/done", nil, http.StatusOK, &done)
+
+		// Bread is already on the next list, untouched since it was added
+		alice.do(http.MethodPost, "/api/v1/lists", gin.H{"name": "This week"}, http.StatusCreated, &next)
+		var bread struct {
+			ID int `json:"id"`
+		}
+		alice.do(http.MethodPost, "/api/v1/items", gin.H{"list_id": next.ID, "name": "Bread", "quantity": 2}, http.StatusCreated, &bread)
+
+		var reused struct {
+			Created []struct {
+				Name string `json:"name"`
+			} `json:"created"`
+			Merged []struct {
+				ID       int     `json:"id"`
+				Quantity float64 `json:"quantity"`
+			} `json:"merged"`
+		}
+		alice.do(http.MethodPost, idPath("/api/v1/history/reuse", done.History.ID), gin.H{"list_id": next.ID}, http.StatusOK, &reused)
+		if len(reused.Created) != 1 || reused.Created[0].Name != "Milk" {
+			t.Errorf("created %+v, want milk", reused.Created)
+		}
+		if len(reused.Merged) != 1 || reused.Merged[0].ID != bread.ID || reused.Merged[0].Quantity != 3 {
+			t.Errorf("merged %+v, want bread %d with 3", reused.Merged, bread.ID)
+		}
+	})
+}
+
+func TestHouseholdSharing(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, conn *sql.DB) {
+		router := newRouter(conn)
+		alice := register(t, router, "alice@example.com")
+		bob := register(t, router, "bob@example.com")
+
+		var household struct {
+			ID int `json:"id"`
+		}
+		alice.do(http.MethodPost, "/api/v1/households", gin.H{"name": "Home"}, http.StatusCreated, &household)
+		bob.do(http.MethodGet, idPath("/api/v1/households", household.ID), nil, http.StatusForbidden, nil)
+		alice.do(http.MethodPost, idPath("/api/v1/households", household.ID)+"/members", gin.H{"email": "bob@example.com"}, http.StatusCreated, nil)
+		bob.do(http.MethodGet, idPath("/api/v1/households", household.ID), nil, http.StatusOK, nil)
+
+		alice.do(http.MethodPut, "/api/v1/households/active", gin.H{"household_id": household.ID}, http.StatusOK, nil)
+		var list listResponse
+		alice.do(http.MethodPost, "/api/v1/lists", gin.H{"name": "Home groceries"}, http.StatusCreated, &list)
+
+		// Household members can edit its lists but only owners delete them
+		bob.do(http.MethodPost, "/api/v1/items", gin.H{"list_id": list.ID, "name": "Eggs", "quantity": 12}, http.StatusCreated, nil)
+		bob.do(http.MethodDelete, idPath("/api/v1/lists", list.ID), nil, http.StatusForbidden, nil)
+
+		var lists struct {
+			Data []listResponse `json:"data"`
+		}
+		bob.do(http.MethodGet, "/api/v1/lists", nil, http.StatusOK, &lists)
+		if len(lists.Data) != 0 {
+			t.Fatalf("bob sees %d lists outside the household, want 0", len(lists.Data))
+		}
+		bob.do(http.MethodPut, "/api/v1/households/active", gin.H{"household_id": household.ID}, http.StatusOK, nil)
+		bob.do(http.MethodGet, "/api/v1/lists", nil, http.StatusOK, &lists)
+		if len(lists.Data) != 1 || len(lists.Data[0].Items) != 1 {
+			t.Fatalf("bob sees %+v in the household", lists.Data)
+		}
+	})
+}
+
+func TestListRoles(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, conn *sql.DB) {
+		router := newRouter(conn)
+		alice := register(t, router, "alice@example.com")
+		register(t, router, "bob@example.com")
+		register(t, router, "carol@example.com")
+
+		var list listResponse
+		alice.do(http.MethodPost, "/api/v1/lists", gin.H{"name": "Groceries"}, http.StatusCreated, &list)
+		members := idPath("/api/v1/lists", list.ID) + "/members"
+		var bob struct {
+			UserID int `json:"user_id"`
+		}
+		alice.do(http.MethodPost, members, gin.H{"email": "bob@example.com", "role": "viewer"}, http.StatusCreated, &bob)
+
+		// Member is a household role and means nothing on a list
+		for _, role := range []string{"member", "admin"} {
+			alice.do(http.MethodPost, members, gin.H{"email": "carol@example.com", "role": role}, http.StatusBadRequest, nil)
+			alice.do(http.MethodPut, idPath(members, bob.UserID), gin.H{"role": role}, http.StatusBadRequest, nil)
+			alice.do(http.MethodPost, idPath("/api/v1/lists", list.ID)+"/invites", gin.H{"role": role}, http.StatusBadRequest, nil)
+		}
+		alice.do(http.MethodPut, idPath(members, bob.UserID), gin.H{"role": "editor"}, http.StatusOK, nil)
+		alice.do(http.MethodPost, idPath("/api/v1/lists", list.ID)+"/invites", gin.H{"role": "viewer"}, http.StatusCreated, nil)
+
+		var household struct {
+			ID int `json:"id"`
+		}
+		alice.do(http.MethodPost, "/api/v1/households", gin.H{"name": "Home"}, http.StatusCreated, &household)
+		alice.do(http.MethodPost, idPath("/api/v1/households", household.ID)+"/invites", gin.H{"role": "editor"}, http.StatusBadRequest, nil)
+		alice.do(http.MethodPost, idPath("/api/v1/households", household.ID)+"/invites", gin.H{"role": "member"}, http.StatusCreated, nil)
+	})
+}
+
+// stream opens a list's event stream on server and returns the types of
+// the events it sends. The channel is closed when the stream ends.
+func (c *client) stream(server *httptest.Server, listID int) <-chan string {
+	c.t.Helper()
+	req, err := http.NewRequest(http.MethodGet, server.URL+idPath("/api/v1/lists", listID)+"/events", nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		c.t.Fatalf("opening the stream of list %d = %d, want 200", listID, resp.StatusCode)
+	}
+	c.t.Cleanup(func() { resp.Body.Close() })
+
+	types := make(chan string, 16)
+	go func() {
+		defer close(types)
+		lines := bufio.NewScanner(resp.Body)
+		for lines.Scan() {
+			if typ, ok := strings.CutPrefix(lines.Text(), "event:"); ok {
+				types <- typ
+			}
+		}
+	}()
+	return types
+}
+
+// expectEvents checks that a stream sends the given events and then ends
+// if closed is set
+func expectEvents(t *testing.T, stream <-chan string, closed bool, want ...string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for _, typ := range want {
+		select {
+		case got, ok := <-stream:
+			if !ok {
+				t.Fatalf("stream ended before %s", typ)
+			}
+			if got != typ {
+				t.Fatalf("got a %s event, want %s", got, typ)
+			}
+		case <-timeout:
+			t.Fatalf("no %s event", typ)
+		}
+	}
+	if !closed {
+		return
+	}
+	select {
+	case got, ok := <-stream:
+		if ok {
+			t.Fatalf("got a %s event, want the stream to end", got)
+		}
+	case <-timeout:
+		t.Fatal("the stream is still open")
+	}
+}
+
+func TestEventStreamsEndWithAccess(t *testing.T) {
+	dbtest.Run(t, func(t *testing.T, conn *sql.DB) {
+		if !db.IsSQLite(conn) {
+			t.Skip("events only reach Postgres subscribers through the listener")
+		}
+		router := newRouter(conn)
+		// Cleanups run last first, so the streams close before the server
+		// waits for them
+		server := httptest.NewServer(router)
+		t.Cleanup(server.Close)
+		alice := register(t, router, "alice@example.com")
+		bob := register(t, router, "bob@example.com")
+
+		var list listResponse
+		alice.do(http.MethodPost, "/api/v1/lists", gin.H{"name": "Groceries"}, http.StatusCreated, &list)
+		members := idPath("/api/v1/lists", list.ID) + "/members"
+		var member struct {
+			UserID int `json:"user_id"`
+		}
+		alice.do(http.MethodPost, members, gin.H{"email": "bob@example.com", "role": "viewer"}, http.StatusCreated, &member)
+
+		// Bob's stream ends when he is removed; Alice's carries on
+		aliceEvents, bobEvents := alice.stream(server, list.ID), bob.stream(server, list.ID)
+		alice.do(http.MethodDelete, idPath(members, member.UserID), nil, http.StatusOK, nil)
+		expectEvents(t, bobEvents, true, "member.removed")
+		alice.do(http.MethodPost, "/api/v1/items", gin.H{"list_id": list.ID, "name": "Milk"}, http.StatusCreated, nil)
+		expectEvents(t, aliceEvents, false, "member.removed", "item.created")
+
+		// Nobody sees a list in the trash
+		alice.do(http.MethodDelete, idPath("/api/v1/lists", list.ID), nil, http.StatusOK, nil)
+		expectEvents(t, aliceEvents, true, "list.deleted")
+
+		// Leaving a household ends the streams of its lists
+		var household struct {
+			ID int `json:"id"`
+		}
+		alice.do(http.MethodPost, "/api/v1/households", gin.H{"name": "Home"}, http.StatusCreated, &household)
+		alice.do(http.MethodPost, idPath("/api/v1/households", household.ID)+"/members", gin.H{"email": "bob@example.com"}, http.StatusCreated, nil)
+		alice.do(http.MethodPut, "/api/v1/households/active", gin.H{"household_id": household.ID}, http.StatusOK, nil)
+		alice.do(http.MethodPost, "/api/v1/lists", gin.H{"name": "Home groceries"}, http.StatusCreated, &list)
+		bobEvents = bob.stream(server, list.ID)
+		bob.do(http.MethodDelete, idPath("/api/v1/households", household.ID)+"/members/"+strconv.Itoa(member.UserID), nil, http.StatusOK, nil)
+		expectEvents(t, bobEvents, true, "member.removed")
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/shopping-list/backend/jobs"
+	"github.com/shopping-list/backend/models"
+	"github.com/shopping-list/backend/repository"
+)
+
+const (
+	// defaultTrashRetention is how long deleted lists and items can be
+	// restored before they are purged
+	defaultTrashRetention = 30 * 24 * time.Hour
+
+	// purgeTrashJob permanently deletes whatever has been in the trash
+	// longer than the retention period, every trashPurgeInterval
+	purgeTrashJob      = "purge_trash"
+	trashPurgeInterval = time.Hour
+)
+
+// trashRetention reads the retention period from TRASH_RETENTION, a
+// duration such as "720h"
+func trashRetention() (time.Duration, error) {
+	value := os.Getenv("TRASH_RETENTION")
+	if value == "" {
+		return defaultTrashRetention, nil
+	}
+	retention, err := time.ParseDuration(value)
+	if err != nil || retention <= 0 {
+		return 0, fmt.Errorf("invalid TRASH_RETENTION %q", value)
+	}
+	return retention, nil
+}
+
+// purgeTrash returns the handler of purgeTrashJob
+func purgeTrash(trash repository.TrashRepository, retention time.Duration) jobs.Handler {
+	return func(ctx context.Context, job models.Job) error {
+		n, err := trash.PurgeExpired(time.Now().Add(-retention))
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			log.Printf("Purged %d lists and items from the trash", n)
+		}
+		return nil
+	}
+}
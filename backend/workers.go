@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// jobWorkers reads how many background jobs each instance runs at once from
+// JOB_WORKERS. It returns 0 when that is not set, which leaves the pool at
+// its default size.
+func jobWorkers() (int, error) {
+	value := os.Getenv("JOB_WORKERS")
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid JOB_WORKERS %q", value)
+	}
+	return n, nil
+}
@@ -71,8 +71,12 @@ export function ActiveList({
     if (!list.id) return;
     setIsLoading(true);
     setError('');
+    const unpurchased = items.filter(i => !i.purchased).length;
+    const carryOver =
+      unpurchased > 0 &&
+      window.confirm(`Carry ${unpurchased} unpurchased item(s) over to a new list?`);
     try {
-      await markListDone(list.id);
+      await markListDone(list.id, carryOver ? {} : undefined);
       setSuccess('Shopping list marked as done!');
       setTimeout(() => {
         onListDeleted(); // Use onListDeleted since the list is removed from active lists
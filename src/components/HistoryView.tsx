@@ -74,12 +74,7 @@ export function HistoryView({
         ) : (
           <div className="space-y-3">
             {filteredHistory.map((entry) => {
-              let data: any = {};
-              try {
-                data = JSON.parse(entry.data);
-              } catch (e) {
-                // ignore parse errors
-              }
+              const snapshot = entry.action === 'created' ? entry.data : null;
 
               return (
                 <div
@@ -88,7 +83,7 @@ export function HistoryView({
                 >
                   <div className="flex-1">
                     <div className="font-semibold text-gray-900 mb-1">
-                      {data.name || 'Shopping List'}
+                      {snapshot?.name || 'Shopping List'}
                     </div>
                     <div className="text-sm text-gray-600">
                       <span className="capitalize font-medium">{entry.action}</span>
@@ -101,9 +96,11 @@ export function HistoryView({
                         minute: '2-digit',
                       })}
                     </div>
-                    {data.items && (
+                    {snapshot && (
                       <div className="text-xs text-gray-500 mt-1">
-                        {data.items.length} items
+                        {snapshot.items.length} items
+                        {snapshot.carried_over_to !== undefined &&
+                          ` · ${snapshot.items.filter(i => i.carried_over).length} carried over`}
                       </div>
                     )}
                   </div>
@@ -1,50 +1,194 @@
+import type { Page, Recurrence } from '@/types';
+
 const API_BASE_URL = process.env.NEXT_PUBLIC_API_URL || 'http://localhost:8080/api/v1';
-const USER_ID = 1; // Default user ID
+const TOKEN_KEY = 'authToken';
+
+function getToken(): string | null {
+  if (typeof window === 'undefined') return null;
+  return window.localStorage.getItem(TOKEN_KEY);
+}
+
+function authHeaders(extra: Record<string, string> = {}): Record<string, string> {
+  const token = getToken();
+  return token ? { ...extra, Authorization: `Bearer ${token}` } : extra;
+}
+
+// If-Match value for a row version; without one the write is unconditional
+function ifMatch(version?: number): string {
+  return version !== undefined ? `"${version}"` : '*';
+}
+
+export function isLoggedIn(): boolean {
+  return getToken() !== null;
+}
+
+export async function register(email: string, password: string): Promise<any> {
+  const response = await fetch(`${API_BASE_URL}/auth/register`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ email, password }),
+  });
+  if (!response.ok) throw new Error('Failed to register');
+  const data = await response.json();
+  window.localStorage.setItem(TOKEN_KEY, data.token);
+  return data.user;
+}
+
+export async function login(email: string, password: string): Promise<any> {
+  const response = await fetch(`${API_BASE_URL}/auth/login`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify({ email, password }),
+  });
+  if (!response.ok) throw new Error('Failed to log in');
+  const data = await response.json();
+  window.localStorage.setItem(TOKEN_KEY, data.token);
+  return data.user;
+}
+
+export async function logout(): Promise<void> {
+  await fetch(`${API_BASE_URL}/auth/logout`, {
+    method: 'POST',
+    headers: authHeaders(),
+  });
+  window.localStorage.removeItem(TOKEN_KEY);
+}
 
 export async function createList(name: string): Promise<any> {
   const response = await fetch(`${API_BASE_URL}/lists`, {
     method: 'POST',
-    headers: { 'Content-Type': 'application/json' },
-    body: JSON.stringify({ user_id: USER_ID, name }),
+    headers: authHeaders({ 'Content-Type': 'application/json' }),
+    body: JSON.stringify({ name }),
   });
   if (!response.ok) throw new Error('Failed to create list');
   return response.json();
 }
 
+// Fetches every page of the user's lists
 export async function getUserLists(): Promise<any[]> {
-  const response = await fetch(`${API_BASE_URL}/lists?user_id=${USER_ID}`);
-  if (!response.ok) throw new Error('Failed to fetch lists');
-  return response.json() || [];
+  const lists: any[] = [];
+  let cursor: string | null = null;
+  do {
+    const query: string = cursor ? `?cursor=${encodeURIComponent(cursor)}` : '';
+    const response = await fetch(`${API_BASE_URL}/lists${query}`, { headers: authHeaders() });
+    if (!response.ok) throw new Error('Failed to fetch lists');
+    const page: Page<any> = await response.json();
+    lists.push(...(page.data || []));
+    cursor = page.next_cursor;
+  } while (cursor);
+  return lists;
+}
+
+// Fetches every page of the user's templates
+export async function getTemplates(): Promise<any[]> {
+  const templates: any[] = [];
+  let cursor: string | null = null;
+  do {
+    const query: string = cursor ? `?cursor=${encodeURIComponent(cursor)}` : '';
+    const response = await fetch(`${API_BASE_URL}/templates${query}`, { headers: authHeaders() });
+    if (!response.ok) throw new Error('Failed to fetch templates');
+    const page: Page<any> = await response.json();
+    templates.push(...(page.data || []));
+    cursor = page.next_cursor;
+  } while (cursor);
+  return templates;
+}
+
+async function copyList(path: string, name: string | undefined, failure: string): Promise<any> {
+  const response = await fetch(`${API_BASE_URL}${path}`, {
+    method: 'POST',
+    headers: authHeaders({ 'Content-Type': 'application/json' }),
+    body: JSON.stringify(name ? { name } : {}),
+  });
+  if (!response.ok) throw new Error(failure);
+  return response.json();
+}
+
+export function saveAsTemplate(listId: number, name?: string): Promise<any> {
+  return copyList(`/lists/${listId}/template`, name, 'Failed to save template');
+}
+
+export function instantiateTemplate(templateId: number, name?: string): Promise<any> {
+  return copyList(`/templates/${templateId}/instantiate`, name, 'Failed to create list from template');
+}
+
+export function duplicateList(listId: number, name?: string): Promise<any> {
+  return copyList(`/lists/${listId}/duplicate`, name, 'Failed to duplicate list');
+}
+
+export interface RecurrenceOptions {
+  startsAt?: string;
+  listId?: number;
+}
+
+async function createRecurrence(path: string, rule: string, options: RecurrenceOptions): Promise<Recurrence> {
+  const response = await fetch(`${API_BASE_URL}${path}`, {
+    method: 'POST',
+    headers: authHeaders({ 'Content-Type': 'application/json' }),
+    body: JSON.stringify({ rule, starts_at: options.startsAt, list_id: options.listId }),
+  });
+  if (!response.ok) throw new Error('Failed to create recurrence');
+  return response.json();
+}
+
+// Adds a template's items to a list on a schedule, e.g. "FREQ=WEEKLY;BYDAY=SA"
+export function createTemplateRecurrence(templateId: number, rule: string, options: RecurrenceOptions = {}): Promise<Recurrence> {
+  return createRecurrence(`/templates/${templateId}/recurrences`, rule, options);
+}
+
+// Puts an item back on a list on a schedule
+export function createItemRecurrence(itemId: number, rule: string, options: RecurrenceOptions = {}): Promise<Recurrence> {
+  return createRecurrence(`/items/${itemId}/recurrences`, rule, options);
+}
+
+export async function getRecurrences(): Promise<Recurrence[]> {
+  const response = await fetch(`${API_BASE_URL}/recurrences`, { headers: authHeaders() });
+  if (!response.ok) throw new Error('Failed to fetch recurrences');
+  return response.json();
+}
+
+export async function deleteRecurrence(id: number): Promise<void> {
+  const response = await fetch(`${API_BASE_URL}/recurrences/${id}`, {
+    method: 'DELETE',
+    headers: authHeaders(),
+  });
+  if (!response.ok) throw new Error('Failed to delete recurrence');
 }
 
 export async function getList(id: number): Promise<any> {
-  const response = await fetch(`${API_BASE_URL}/lists/${id}`);
+  const response = await fetch(`${API_BASE_URL}/lists/${id}`, { headers: authHeaders() });
   if (!response.ok) throw new Error('Failed to fetch list');
   return response.json();
 }
 
-export async function updateList(id: number, name: string): Promise<any> {
+export async function updateList(id: number, name: string, version?: number): Promise<any> {
   const response = await fetch(`${API_BASE_URL}/lists/${id}`, {
     method: 'PUT',
-    headers: { 'Content-Type': 'application/json' },
+    headers: authHeaders({ 'Content-Type': 'application/json', 'If-Match': ifMatch(version) }),
     body: JSON.stringify({ name }),
   });
   if (!response.ok) throw new Error('Failed to update list');
   return response.json();
 }
 
-export async function deleteList(id: number): Promise<void> {
+export async function deleteList(id: number, version?: number): Promise<void> {
   const response = await fetch(`${API_BASE_URL}/lists/${id}`, {
     method: 'DELETE',
+    headers: authHeaders({ 'If-Match': ifMatch(version) }),
   });
   if (!response.ok) throw new Error('Failed to delete list');
 }
 
-export async function markListDone(id: number): Promise<any> {
+// Pass carryOver to move unpurchased items on to an existing list (list_id)
+// or a new one; an empty object creates a list with the same name
+export async function markListDone(
+  id: number,
+  carryOver?: { list_id?: number; name?: string }
+): Promise<any> {
   const response = await fetch(`${API_BASE_URL}/lists/${id}/done`, {
     method: 'POST',
-    headers: { 'Content-Type': 'application/json' },
-    body: JSON.stringify({ user_id: USER_ID }),
+    headers: authHeaders({ 'Content-Type': 'application/json' }),
+    body: JSON.stringify(carryOver ? { carry_over: carryOver } : {}),
   });
   if (!response.ok) throw new Error('Failed to mark list as done');
   return response.json();
@@ -58,7 +202,7 @@ export async function createItem(
 ): Promise<any> {
   const response = await fetch(`${API_BASE_URL}/items`, {
     method: 'POST',
-    headers: { 'Content-Type': 'application/json' },
+    headers: authHeaders({ 'Content-Type': 'application/json' }),
     body: JSON.stringify({ list_id: listId, name, quantity, unit, purchased: false }),
   });
   if (!response.ok) throw new Error('Failed to create item');
@@ -70,35 +214,45 @@ export async function updateItem(
   name: string,
   quantity: number,
   unit: string,
-  purchased: boolean
+  purchased: boolean,
+  version?: number
 ): Promise<any> {
   const response = await fetch(`${API_BASE_URL}/items/${id}`, {
     method: 'PUT',
-    headers: { 'Content-Type': 'application/json' },
+    headers: authHeaders({ 'Content-Type': 'application/json', 'If-Match': ifMatch(version) }),
     body: JSON.stringify({ name, quantity, unit, purchased }),
   });
   if (!response.ok) throw new Error('Failed to update item');
   return response.json();
 }
 
-export async function deleteItem(id: number): Promise<void> {
+export async function deleteItem(id: number, version?: number): Promise<void> {
   const response = await fetch(`${API_BASE_URL}/items/${id}`, {
     method: 'DELETE',
+    headers: authHeaders({ 'If-Match': ifMatch(version) }),
   });
   if (!response.ok) throw new Error('Failed to delete item');
 }
 
-export async function getHistory(): Promise<any[]> {
-  const response = await fetch(`${API_BASE_URL}/history?user_id=${USER_ID}`);
+// Fetches the most recent page of history; pass next_cursor for older entries
+export async function getHistory(cursor?: string): Promise<any[]> {
+  const query = cursor ? `?cursor=${encodeURIComponent(cursor)}` : '';
+  const response = await fetch(`${API_BASE_URL}/history${query}`, { headers: authHeaders() });
   if (!response.ok) throw new Error('Failed to fetch history');
-  return response.json() || [];
+  const page: Page<any> = await response.json();
+  return page.data || [];
 }
 
-export async function reuseList(historyId: number): Promise<any> {
+// Without options the snapshot becomes a new list; list_id merges it into
+// an existing one instead
+export async function reuseList(
+  historyId: number,
+  options?: { list_id?: number; unpurchased_only?: boolean; item_ids?: number[] }
+): Promise<any> {
   const response = await fetch(`${API_BASE_URL}/history/reuse/${historyId}`, {
     method: 'POST',
-    headers: { 'Content-Type': 'application/json' },
-    body: JSON.stringify({ user_id: USER_ID }),
+    headers: authHeaders({ 'Content-Type': 'application/json' }),
+    body: JSON.stringify(options ?? {}),
   });
   if (!response.ok) throw new Error('Failed to reuse list');
   return response.json();
@@ -5,6 +5,7 @@ export interface ShoppingItem {
   quantity: number;
   unit: string;
   purchased: boolean;
+  version?: number;
   created_at?: string;
 }
 
@@ -12,16 +13,66 @@ export interface ShoppingList {
   id?: number;
   user_id?: number;
   name: string;
+  kind?: 'list' | 'template';
   items: ShoppingItem[];
+  version?: number;
   created_at?: string;
   updated_at?: string;
 }
 
-export interface ListHistory {
+export interface Recurrence {
+  id: number;
+  user_id: number;
+  template_id?: number;
+  item_id?: number;
+  list_id: number | null;
+  rule: string;
+  starts_at: string;
+  next_run_at: string;
+  last_run_at: string | null;
+  created_at: string;
+}
+
+export interface SnapshotItem {
+  id: number;
+  name: string;
+  quantity: number;
+  unit: string;
+  purchased: boolean;
+  carried_over?: boolean;
+}
+
+export interface ListSnapshot {
+  name: string;
+  items: SnapshotItem[];
+  carried_over_to?: number;
+}
+
+export interface ListReuse {
+  original_history_id: number;
+  new_list_id: number;
+  existing?: boolean;
+}
+
+export interface InviteAcceptance {
+  invite_id: number;
+  user_id: number;
+  email: string;
+  role: string;
+}
+
+export type ListHistory = {
   id: number;
   user_id: number;
   original_list_id: number | null;
-  action: string;
-  data: string;
   created_at: string;
+} & (
+  | { action: 'created'; data: ListSnapshot }
+  | { action: 'reused'; data: ListReuse }
+  | { action: 'invite_accepted'; data: InviteAcceptance }
+);
+
+export interface Page<T> {
+  data: T[];
+  next_cursor: string | null;
 }